@@ -0,0 +1,610 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// WhiteoutPrefix OCI/Docker 镜像层中表示删除文件的前缀, 如 .wh.foo 表示删除了foo
+	WhiteoutPrefix = ".wh."
+	// WhiteoutOpaqueDir 表示该目录为不透明目录，下层同名目录的内容全部被屏蔽
+	WhiteoutOpaqueDir = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+	// tar 包中保存xattr的PAX记录前缀
+	paxXattrPrefix = "SCHILY.xattr."
+	// 解压时按块检查全零数据以还原稀疏文件的空洞
+	sparseBlockSize = 4096
+)
+
+var (
+	// overlay 文件系统内部使用的xattr前缀，不写入tar包
+	overlayXattrPrefix = "trusted.overlay."
+	// overlay 文件系统在upper目录中标记不透明目录的xattr
+	overlayOpaqueXattr = overlayXattrPrefix + "opaque"
+)
+
+// UseUserXattr 用户命名空间中不能设置 trusted.* xattr，改用 user.overlay. 前缀，overlay 需以 userxattr 选项挂载
+func UseUserXattr() {
+	overlayXattrPrefix = "user.overlay."
+	overlayOpaqueXattr = overlayXattrPrefix + "opaque"
+}
+
+// 用于识别硬链接的inode
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// Tar 将目录打包为 tar 流，保留属主、权限、xattr、硬链接和设备文件
+func Tar(srcDir string, w io.Writer) error {
+	return tarDir(srcDir, "", w, false)
+}
+
+// TarPath 将文件或目录打包为 tar 流，条目名以 path 的文件名开头，如 /var/log 打包为 log/...
+func TarPath(path string, w io.Writer) error {
+	return tarDir(path, filepath.Base(path), w, false)
+}
+
+// TarPathAs 与 TarPath 相同，但条目名以 name 开头，用于复制时重命名
+func TarPathAs(path, name string, w io.Writer) error {
+	return tarDir(path, name, w, false)
+}
+
+// TarLayer 将 overlay 的 upper 目录打包成标准的镜像层 tar 流
+// overlay 的删除标记(0/0字符设备)和不透明目录(xattr)会被转换为 OCI 的 .wh. 文件
+func TarLayer(srcDir string, w io.Writer) error {
+	return tarDir(srcDir, "", w, true)
+}
+
+// 打包 srcDir，prefix 不为空时作为所有条目名的前缀，srcDir 本身也作为一个条目
+func tarDir(srcDir, prefix string, w io.Writer, layer bool) error {
+	tw := tar.NewWriter(w)
+	hardlinks := map[inode]string{}
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("walk %s fails: %v", path, err)
+		}
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		if relPath = filepath.ToSlash(filepath.Join(prefix, relPath)); relPath == "." {
+			return nil
+		}
+
+		// overlay whiteout: 主次设备号都为0的字符设备
+		if layer && isOverlayWhiteout(info) {
+			return writeMarker(tw, filepath.Join(filepath.Dir(relPath), WhiteoutPrefix+info.Name()), info.ModTime())
+		}
+
+		if err := writeEntry(tw, path, relPath, info, hardlinks); err != nil {
+			return err
+		}
+
+		// overlay 不透明目录转换为目录下的 .wh..wh..opq 文件
+		if layer && info.IsDir() && isOverlayOpaque(path) {
+			return writeMarker(tw, filepath.Join(relPath, WhiteoutOpaqueDir), info.ModTime())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+/*
+TarChanges 将目录 dir 中的文件变化打包成镜像层 tar 流，用于不使用 overlay 的快照
+新增和修改的文件从 dir 中读取(目录只写入目录本身)，删除的文件写入 .wh. 标记
+*/
+func TarChanges(dir string, changes []Change, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	hardlinks := map[inode]string{}
+	for _, change := range changes {
+		relPath := strings.TrimPrefix(change.Path, "/")
+		if change.Kind == ChangeDelete {
+			parent := filepath.Dir(relPath)
+			var modTime time.Time
+			if info, err := os.Lstat(filepath.Join(dir, parent)); err == nil {
+				modTime = info.ModTime()
+			}
+			if err := writeMarker(tw, filepath.Join(parent, WhiteoutPrefix+filepath.Base(relPath)), modTime); err != nil {
+				return err
+			}
+			continue
+		}
+		path := filepath.Join(dir, relPath)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if err := writeEntry(tw, path, relPath, info, hardlinks); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// MapIDs 返回将 r 中各条目的 uid、gid 经 mapIDs 转换后的 tar 流，用于在用户命名空间与宿主机之间转换文件属主
+func MapIDs(r io.Reader, mapIDs func(uid, gid int) (int, int)) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(mapTarIDs(r, writer, mapIDs))
+	}()
+	return reader
+}
+
+func mapTarIDs(r io.Reader, w io.Writer, mapIDs func(uid, gid int) (int, int)) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar header fails: %v", err)
+		}
+		hdr.Uid, hdr.Gid = mapIDs(hdr.Uid, hdr.Gid)
+		// 用户名和组名对应转换前的 id，转换后的 id 可能需要不同的格式编码
+		hdr.Uname, hdr.Gname = "", ""
+		hdr.Format = tar.FormatUnknown
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("write tar header of %s fails: %v", hdr.Name, err)
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// 写入单个文件的 tar 头和内容
+func writeEntry(tw *tar.Writer, path, relPath string, info os.FileInfo, hardlinks map[inode]string) error {
+	hdr, err := fileHeader(path, relPath, info, hardlinks)
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write tar header of %s fails: %v", relPath, err)
+	}
+	if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := io.Copy(tw, file); err != nil {
+		return fmt.Errorf("write content of %s fails: %v", relPath, err)
+	}
+	return nil
+}
+
+// 生成文件的 tar 头，记录属主、设备号、xattr，已打包过的inode记录为硬链接
+func fileHeader(path, relPath string, info os.FileInfo, hardlinks map[inode]string) (*tar.Header, error) {
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return nil, fmt.Errorf("read link %s fails: %v", path, err)
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return nil, fmt.Errorf("build tar header for %s fails: %v", path, err)
+	}
+	hdr.Name = relPath
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	// 只保存数字id，用户名在容器内可能不同
+	hdr.Uname, hdr.Gname = "", ""
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		hdr.Uid, hdr.Gid = int(stat.Uid), int(stat.Gid)
+		// 同一inode第二次出现时记录为指向第一次出现路径的硬链接
+		if info.Mode().IsRegular() && stat.Nlink > 1 {
+			key := inode{dev: uint64(stat.Dev), ino: stat.Ino}
+			if first, ok := hardlinks[key]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+			} else {
+				hardlinks[key] = relPath
+			}
+		}
+	}
+
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range xattrs {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords[paxXattrPrefix+name] = value
+	}
+	return hdr, nil
+}
+
+// 写入空的标记文件(.wh.)
+func writeMarker(tw *tar.Writer, name string, modTime time.Time) error {
+	hdr := &tar.Header{
+		Name:     filepath.ToSlash(name),
+		Typeflag: tar.TypeReg,
+		Mode:     0600,
+		ModTime:  modTime,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write tar header of %s fails: %v", name, err)
+	}
+	return nil
+}
+
+// 解压 tar 流的方式
+type untarMode int
+
+const (
+	untarPlain   untarMode = iota // 普通 tar 包
+	untarOverlay                  // 镜像层, .wh. 文件还原为 overlay 的删除标记
+	untarApply                    // 镜像层, 直接删除 .wh. 文件标记的下层文件
+)
+
+// Untar 将 tar 流安全地解压到目录
+// 拒绝包含 ../ 的路径，以及经由符号链接写到目录之外的条目
+func Untar(r io.Reader, dstDir string) error {
+	return untar(r, dstDir, untarPlain)
+}
+
+// UntarLayer 将镜像层 tar 流解压到目录，.wh. 文件会被还原为 overlay 可识别的删除标记
+func UntarLayer(r io.Reader, dstDir string) error {
+	return untar(r, dstDir, untarOverlay)
+}
+
+// ApplyLayer 将镜像层 tar 流叠加到已包含下层内容的目录上，删除 .wh. 文件标记的文件，清空不透明目录中下层的内容
+func ApplyLayer(r io.Reader, dstDir string) error {
+	return untar(r, dstDir, untarApply)
+}
+
+func untar(r io.Reader, dstDir string, mode untarMode) error {
+	dstDir, err := filepath.Abs(dstDir)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	// 目录的修改时间会因为其中文件的创建而改变，全部解压后再设置
+	var dirs []*tar.Header
+	// 本层解压出的文件，叠加不透明目录时保留
+	extracted := map[string]bool{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar header fails: %v", err)
+		}
+		name, err := sanitizeName(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			continue
+		}
+		path := filepath.Join(dstDir, name)
+		parent, base := filepath.Split(path)
+		if err := checkParents(dstDir, parent); err != nil {
+			return fmt.Errorf("extract %s fails: %v", hdr.Name, err)
+		}
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return fmt.Errorf("extract %s fails: %v", hdr.Name, err)
+		}
+
+		// 处理删除标记
+		if mode == untarOverlay && base == WhiteoutOpaqueDir {
+			if err := unix.Lsetxattr(parent, overlayOpaqueXattr, []byte("y"), 0); err != nil {
+				return fmt.Errorf("set opaque xattr on %s fails: %v", parent, err)
+			}
+			continue
+		}
+		if mode == untarApply && base == WhiteoutOpaqueDir {
+			if err := clearDir(parent, extracted); err != nil {
+				return fmt.Errorf("apply opaque dir %s fails: %v", parent, err)
+			}
+			continue
+		}
+		if mode != untarPlain && strings.HasPrefix(base, WhiteoutPrefix) {
+			target := filepath.Join(parent, strings.TrimPrefix(base, WhiteoutPrefix))
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			if mode == untarApply {
+				continue
+			}
+			if err := unix.Mknod(target, unix.S_IFCHR, 0); err != nil {
+				return fmt.Errorf("create whiteout %s fails: %v", target, err)
+			}
+			continue
+		}
+
+		if err := extractEntry(tr, hdr, path, dstDir); err != nil {
+			return fmt.Errorf("extract %s fails: %v", hdr.Name, err)
+		}
+		extracted[filepath.Clean(path)] = true
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, hdr)
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		name, _ := sanitizeName(dirs[i].Name)
+		path := filepath.Join(dstDir, name)
+		if err := os.Chtimes(path, dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			return fmt.Errorf("set times of %s fails: %v", dirs[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// 删除目录 dir 中不是本层解压出的文件，本层解压出的目录中同样只保留本层的文件
+func clearDir(dir string, extracted map[string]bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if extracted[path] {
+			if entry.IsDir() {
+				if err := clearDir(path, extracted); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 规范化 tar 中的路径，去掉开头的'/'，拒绝跳出解压目录的路径
+func sanitizeName(name string) (string, error) {
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if part == ".." {
+			return "", fmt.Errorf("invalid path %q in archive: path traversal is not allowed", name)
+		}
+	}
+	return strings.TrimPrefix(filepath.Clean("/"+name), "/"), nil
+}
+
+// 检查 dir 在 root 内的各级父目录都不是符号链接，防止经由符号链接写到 root 之外
+func checkParents(root, dir string) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return err
+	}
+	current := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("path escapes through symlink %s", strings.TrimPrefix(current, root))
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", strings.TrimPrefix(current, root))
+		}
+	}
+	return nil
+}
+
+// 还原单个 tar 条目
+func extractEntry(tr *tar.Reader, hdr *tar.Header, path, dstDir string) error {
+	mode := os.FileMode(hdr.Mode).Perm()
+	// 已存在的条目直接覆盖，目录除外
+	if fi, err := os.Lstat(path); err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(path, mode); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg:
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		if err := writeSparse(file, tr, hdr.Size); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		// 符号链接的目标不会在解压时被跟随，指向绝对路径是安全的
+		if err := os.Symlink(hdr.Linkname, path); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		linkName, err := sanitizeName(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dstDir, linkName)
+		if err := checkParents(dstDir, filepath.Dir(target)); err != nil {
+			return err
+		}
+		if err := os.Link(target, path); err != nil {
+			return err
+		}
+		return nil
+	case tar.TypeChar, tar.TypeBlock:
+		devType := uint32(unix.S_IFCHR)
+		if hdr.Typeflag == tar.TypeBlock {
+			devType = unix.S_IFBLK
+		}
+		dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		if err := unix.Mknod(path, devType|uint32(mode), int(dev)); err != nil {
+			return err
+		}
+	case tar.TypeFifo:
+		if err := unix.Mkfifo(path, uint32(mode)); err != nil {
+			return err
+		}
+	default:
+		logrus.Warnf("skip unsupported tar entry %s of type %c", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+
+	if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+		return err
+	}
+	if err := writeXattrs(path, hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	// chown 会清除 setuid/setgid 位, 需在之后设置权限
+	if err := os.Chmod(path, mode|modeBits(hdr.Mode)); err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeDir {
+		return nil
+	}
+	return os.Chtimes(path, hdr.ModTime, hdr.ModTime)
+}
+
+// 写入文件内容，全零的数据块跳过不写，从而还原稀疏文件的空洞
+func writeSparse(file *os.File, r io.Reader, size int64) error {
+	buf := make([]byte, sparseBlockSize)
+	zero := make([]byte, sparseBlockSize)
+	var written int64
+	for written < size {
+		n, err := io.ReadFull(r, buf[:min(int64(len(buf)), size-written)])
+		if n > 0 {
+			if bytes.Equal(buf[:n], zero[:n]) {
+				if _, err := file.Seek(int64(n), io.SeekCurrent); err != nil {
+					return err
+				}
+			} else if _, err := file.Write(buf[:n]); err != nil {
+				return err
+			}
+			written += int64(n)
+		}
+		if err != nil {
+			return fmt.Errorf("read content fails after %d of %d bytes: %v", written, size, err)
+		}
+	}
+	// 文件末尾是空洞时需通过 truncate 设置文件大小
+	return file.Truncate(size)
+}
+
+// 读取文件的xattr，overlay 内部使用的xattr除外
+func readXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil {
+		if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("list xattrs of %s fails: %v", path, err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(path, buf); err != nil {
+		return nil, fmt.Errorf("list xattrs of %s fails: %v", path, err)
+	}
+	xattrs := map[string]string{}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if name == "" || strings.HasPrefix(name, overlayXattrPrefix) {
+			continue
+		}
+		valueSize, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("get xattr %s of %s fails: %v", name, path, err)
+		}
+		value := make([]byte, valueSize)
+		if valueSize, err = unix.Lgetxattr(path, name, value); err != nil {
+			return nil, fmt.Errorf("get xattr %s of %s fails: %v", name, path, err)
+		}
+		xattrs[name] = string(value[:valueSize])
+	}
+	return xattrs, nil
+}
+
+// 还原 tar 条目中记录的xattr
+func writeXattrs(path string, hdr *tar.Header) error {
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, paxXattrPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, paxXattrPrefix)
+		if err := unix.Lsetxattr(path, name, []byte(value), 0); err != nil {
+			// 部分文件系统不支持xattr，或不允许在符号链接上设置 user.* xattr
+			if err == unix.ENOTSUP || err == unix.EOPNOTSUPP || (err == unix.EPERM && hdr.Typeflag == tar.TypeSymlink) {
+				logrus.Warnf("ignore xattr %s of %s: %v", name, hdr.Name, err)
+				continue
+			}
+			return fmt.Errorf("set xattr %s fails: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// 判断文件是否为 overlay 的删除标记
+func isOverlayWhiteout(info os.FileInfo) bool {
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+// 判断目录是否被 overlay 标记为不透明
+func isOverlayOpaque(path string) bool {
+	buf := make([]byte, 1)
+	n, err := unix.Lgetxattr(path, overlayOpaqueXattr, buf)
+	return err == nil && n == 1 && buf[0] == 'y'
+}
+
+// 将 tar 中的 setuid/setgid/sticky 位转换为 os.FileMode
+func modeBits(mode int64) os.FileMode {
+	var m os.FileMode
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
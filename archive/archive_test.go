@@ -0,0 +1,248 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// 构造只包含给定条目的 tar 包
+func buildTar(t *testing.T, headers ...*tar.Header) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, hdr := range headers {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			if _, err := tw.Write(bytes.Repeat([]byte("x"), int(hdr.Size))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestUntarRejectsTraversal(t *testing.T) {
+	root := t.TempDir()
+	dst := filepath.Join(root, "dst")
+	outside := filepath.Join(root, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+	}{
+		{"dot dot", []*tar.Header{
+			{Name: "../outside/evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		}},
+		{"nested dot dot", []*tar.Header{
+			{Name: "a/../../outside/evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		}},
+		{"absolute symlink", []*tar.Header{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside},
+			{Name: "link/evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		}},
+		{"relative symlink", []*tar.Header{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../outside"},
+			{Name: "link/evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		}},
+		{"hardlink", []*tar.Header{
+			{Name: "evil", Typeflag: tar.TypeLink, Linkname: "../outside/secret"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.RemoveAll(dst)
+			if err := Untar(buildTar(t, tt.headers...), dst); err == nil {
+				t.Fatal("expect error, got nil")
+			}
+			if _, err := os.Lstat(filepath.Join(outside, "evil")); !os.IsNotExist(err) {
+				t.Fatalf("file written outside of destination: %v", err)
+			}
+		})
+	}
+}
+
+func TestUntarAbsolutePath(t *testing.T) {
+	dst := t.TempDir()
+	buf := buildTar(t, &tar.Header{Name: "/etc/hostname", Typeflag: tar.TypeReg, Mode: 0644, Size: 3})
+	if err := Untar(buf, dst); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "etc/hostname")); err != nil {
+		t.Fatalf("absolute path not extracted under destination: %v", err)
+	}
+}
+
+func TestTarRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	mustWrite(t, filepath.Join(src, "dir/file"), "hello", 04755)
+	if err := os.Link(filepath.Join(src, "dir/file"), filepath.Join(src, "hardlink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/dir/file", filepath.Join(src, "symlink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Mkfifo(filepath.Join(src, "fifo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// 中间有空洞的稀疏文件
+	sparse, err := os.Create(filepath.Join(src, "sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sparse.WriteAt([]byte("end"), 1<<20)
+	sparse.Close()
+	xattrSupported := unix.Lsetxattr(filepath.Join(src, "dir/file"), "user.test", []byte("value"), 0) == nil
+	devSupported := unix.Mknod(filepath.Join(src, "null"), unix.S_IFCHR|0666, int(unix.Mkdev(1, 3))) == nil
+
+	for _, compression := range []Compression{Uncompressed, Gzip, Zstd} {
+		t.Run(compression.String(), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			w, err := CompressStream(buf, compression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Tar(src, w); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := DetectCompression(buf.Bytes()); got != compression {
+				t.Fatalf("detect compression: expect %v, got %v", compression, got)
+			}
+			r, err := DecompressStream(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			dst := t.TempDir()
+			if err := Untar(r, dst); err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := os.ReadFile(filepath.Join(dst, "dir/file"))
+			if err != nil || string(content) != "hello" {
+				t.Fatalf("read file: %q, %v", content, err)
+			}
+			info, _ := os.Stat(filepath.Join(dst, "dir/file"))
+			if info.Mode() != os.ModeSetuid|0755 {
+				t.Fatalf("file mode: expect %v, got %v", os.ModeSetuid|0755, info.Mode())
+			}
+			if !sameInode(t, filepath.Join(dst, "dir/file"), filepath.Join(dst, "hardlink")) {
+				t.Fatal("hardlink not preserved")
+			}
+			if link, err := os.Readlink(filepath.Join(dst, "symlink")); err != nil || link != "/dir/file" {
+				t.Fatalf("read symlink: %q, %v", link, err)
+			}
+			if info, err := os.Lstat(filepath.Join(dst, "fifo")); err != nil || info.Mode()&os.ModeNamedPipe == 0 {
+				t.Fatalf("fifo not preserved: %v", err)
+			}
+
+			var stat syscall.Stat_t
+			if err := syscall.Stat(filepath.Join(dst, "sparse"), &stat); err != nil {
+				t.Fatal(err)
+			}
+			if stat.Size != 1<<20+3 || stat.Blocks*512 >= stat.Size {
+				t.Fatalf("sparse file: size %d, allocated %d", stat.Size, stat.Blocks*512)
+			}
+
+			if xattrSupported {
+				value := make([]byte, 16)
+				n, err := unix.Lgetxattr(filepath.Join(dst, "dir/file"), "user.test", value)
+				if err != nil || string(value[:n]) != "value" {
+					t.Fatalf("xattr: %q, %v", value[:n], err)
+				}
+			}
+			if devSupported {
+				var devStat syscall.Stat_t
+				if err := syscall.Stat(filepath.Join(dst, "null"), &devStat); err != nil {
+					t.Fatal(err)
+				}
+				if devStat.Rdev != unix.Mkdev(1, 3) {
+					t.Fatalf("device number: expect %d, got %d", unix.Mkdev(1, 3), devStat.Rdev)
+				}
+			}
+		})
+	}
+}
+
+func TestLayerWhiteouts(t *testing.T) {
+	buf := buildTar(t,
+		&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "dir/.wh.removed", Typeflag: tar.TypeReg, Mode: 0600},
+	)
+	dst := t.TempDir()
+	if err := UntarLayer(buf, dst); err != nil {
+		if os.IsPermission(err) || bytes.Contains([]byte(err.Error()), []byte("operation not permitted")) {
+			t.Skipf("creating whiteouts needs privilege: %v", err)
+		}
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(filepath.Join(dst, "dir/removed"))
+	if err != nil || !isOverlayWhiteout(info) {
+		t.Fatalf("whiteout not created: %v", err)
+	}
+
+	// 再次打包时还原为 .wh. 文件
+	out := &bytes.Buffer{}
+	if err := TarLayer(dst, out); err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(out)
+	found := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == "dir/.wh.removed" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("whiteout not converted back to .wh. file")
+	}
+}
+
+func mustWrite(t *testing.T, path, content string, mode os.FileMode) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode.Perm()|modeBits(int64(mode))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sameInode(t *testing.T, a, b string) bool {
+	t.Helper()
+	infoA, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return os.SameFile(infoA, infoB)
+}
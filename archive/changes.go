@@ -0,0 +1,258 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ChangeType 文件变化的类型
+type ChangeType int
+
+const (
+	ChangeModify ChangeType = iota // 修改
+	ChangeAdd                      // 新增
+	ChangeDelete                   // 删除
+)
+
+func (c ChangeType) String() string {
+	switch c {
+	case ChangeAdd:
+		return "A"
+	case ChangeDelete:
+		return "D"
+	default:
+		return "C"
+	}
+}
+
+// Change 可写层中的一处文件变化，Path 为容器内的绝对路径
+type Change struct {
+	Path string
+	Kind ChangeType
+}
+
+func (c Change) String() string {
+	return c.Kind.String() + " " + c.Path
+}
+
+/*
+Changes 比较 overlay 的 upper 目录与 lower 目录，得到按路径排序的文件变化
+lowerDirs 按从上到下的顺序排列，与 overlay 的 lowerdir 选项一致
+1.upper 中的删除标记(0/0字符设备)为删除
+2.upper 中的其他文件若在 lower 中可见则为修改(包括因子文件变化而被复制上来的目录)，否则为新增
+3.不透明目录屏蔽了 lower 中的同名目录，其中 lower 有而 upper 没有的文件为删除，upper 中的文件均为新增
+*/
+func Changes(upperDir string, lowerDirs []string) ([]Change, error) {
+	var changes []Change
+	if err := walkChanges(upperDir, "/", lowerDirs, &changes); err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// 比较 upper 中的目录 dir，lowerDirs 为空表示 lower 中的内容被不透明目录屏蔽
+func walkChanges(upperDir, dir string, lowerDirs []string, changes *[]Change) error {
+	entries, err := os.ReadDir(filepath.Join(upperDir, dir))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if isOverlayWhiteout(info) {
+			*changes = append(*changes, Change{Path: path, Kind: ChangeDelete})
+			continue
+		}
+		kind := ChangeAdd
+		if existsInLayers(lowerDirs, path) {
+			kind = ChangeModify
+		}
+		*changes = append(*changes, Change{Path: path, Kind: kind})
+		if !info.IsDir() {
+			continue
+		}
+
+		childLowers := lowerDirs
+		if isOverlayOpaque(filepath.Join(upperDir, path)) {
+			// lower 中未被 upper 覆盖的文件都已删除
+			if kind == ChangeModify {
+				for _, name := range listLayers(lowerDirs, path) {
+					if _, err := os.Lstat(filepath.Join(upperDir, path, name)); os.IsNotExist(err) {
+						*changes = append(*changes, Change{Path: filepath.Join(path, name), Kind: ChangeDelete})
+					}
+				}
+			}
+			childLowers = nil
+		}
+		if err := walkChanges(upperDir, path, childLowers, changes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 判断 path 在叠加后的 layers 中是否可见
+func existsInLayers(layers []string, path string) bool {
+	return findInLayers(layers, path) != ""
+}
+
+// 得到 path 在叠加后的 layers 中可见的文件，处理各层中的删除标记、不透明目录以及覆盖了目录的文件
+// @return 最上层中该文件的路径，不可见时为空
+func findInLayers(layers []string, path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for _, layer := range layers {
+		current := layer
+		opaque := false
+		for i, part := range parts {
+			current = filepath.Join(current, part)
+			info, err := os.Lstat(current)
+			if err != nil {
+				break
+			}
+			if isOverlayWhiteout(info) {
+				return ""
+			}
+			if i == len(parts)-1 {
+				return current
+			}
+			if !info.IsDir() {
+				return ""
+			}
+			if isOverlayOpaque(current) {
+				opaque = true
+			}
+		}
+		// 该层中 path 的父目录不透明，更下层的内容不可见
+		if opaque {
+			return ""
+		}
+	}
+	return ""
+}
+
+// 列出目录 dir 在叠加后的 layers 中可见的文件名
+func listLayers(layers []string, dir string) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, layer := range layers {
+		entries, err := os.ReadDir(filepath.Join(layer, dir))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if existsInLayers(layers, filepath.Join(dir, name)) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+ChangesDirs 比较完整的根文件系统目录 dir 与叠加后的 lowerDirs，得到按路径排序的文件变化，用于不使用 overlay 的快照
+1.dir 中的文件在 lower 中不可见为新增，类型、权限、属主、大小、修改时间或链接目标不同为修改
+2.lower 中可见而 dir 中不存在的文件为删除，删除的目录只列出目录本身
+*/
+func ChangesDirs(dir string, lowerDirs []string) ([]Change, error) {
+	var changes []Change
+	if err := walkChangesDirs(dir, "/", lowerDirs, &changes); err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// 比较 dir 中的目录 path, lower 中没有该目录时其下的文件都是新增
+func walkChangesDirs(dir, path string, lowerDirs []string, changes *[]Change) error {
+	entries, err := os.ReadDir(filepath.Join(dir, path))
+	if err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, entry := range entries {
+		names[entry.Name()] = true
+		child := filepath.Join(path, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		lower := findInLayers(lowerDirs, child)
+		switch {
+		case lower == "":
+			*changes = append(*changes, Change{Path: child, Kind: ChangeAdd})
+		case fileChanged(filepath.Join(dir, child), info, lower):
+			*changes = append(*changes, Change{Path: child, Kind: ChangeModify})
+		}
+		if !info.IsDir() {
+			continue
+		}
+		childLowers := lowerDirs
+		if lower == "" {
+			childLowers = nil
+		} else if lowerInfo, err := os.Lstat(lower); err != nil || !lowerInfo.IsDir() {
+			childLowers = nil
+		}
+		if err := walkChangesDirs(dir, child, childLowers, changes); err != nil {
+			return err
+		}
+	}
+	for _, name := range listLayers(lowerDirs, path) {
+		if !names[name] {
+			*changes = append(*changes, Change{Path: filepath.Join(path, name), Kind: ChangeDelete})
+		}
+	}
+	return nil
+}
+
+// 判断文件相对于 lower 中的同名文件是否被修改
+// 目录不比较大小(与 overlay 一致，目录中的文件变化时目录也视为修改)，符号链接只比较链接目标(解压时不还原其修改时间)
+func fileChanged(path string, info os.FileInfo, lower string) bool {
+	lowerInfo, err := os.Lstat(lower)
+	if err != nil {
+		return true
+	}
+	if info.Mode() != lowerInfo.Mode() {
+		return true
+	}
+	stat, ok1 := info.Sys().(*syscall.Stat_t)
+	lowerStat, ok2 := lowerInfo.Sys().(*syscall.Stat_t)
+	if ok1 && ok2 && (stat.Uid != lowerStat.Uid || stat.Gid != lowerStat.Gid || stat.Rdev != lowerStat.Rdev) {
+		return true
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, _ := os.Readlink(path)
+		lowerLink, _ := os.Readlink(lower)
+		return link != lowerLink
+	}
+	if !sameModTime(info.ModTime(), lowerInfo.ModTime()) {
+		return true
+	}
+	return !info.IsDir() && info.Size() != lowerInfo.Size()
+}
+
+// 比较修改时间，tar 包只保存到秒(取整或截断)，有一方没有纳秒部分时允许相差不到一秒
+func sameModTime(a, b time.Time) bool {
+	if a.Nanosecond() == 0 || b.Nanosecond() == 0 {
+		d := a.Sub(b)
+		return d > -time.Second && d < time.Second
+	}
+	return a.Equal(b)
+}
@@ -0,0 +1,135 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestChanges(t *testing.T) {
+	lower1, lower2, upper := t.TempDir(), t.TempDir(), t.TempDir()
+	// lower2 在下，lower1 在上, lower1 删除了 lower2 中的 etc/old
+	mustWrite(t, filepath.Join(lower2, "etc/old"), "old", 0644)
+	mustWrite(t, filepath.Join(lower2, "etc/passwd"), "root", 0644)
+	mustWrite(t, filepath.Join(lower2, "var/cache/a"), "a", 0644)
+	mustWrite(t, filepath.Join(lower2, "var/cache/b"), "b", 0644)
+	mustWrite(t, filepath.Join(lower1, "usr/bin/sh"), "sh", 0755)
+	mustWrite(t, filepath.Join(lower1, "etc/.keep"), "", 0644)
+	if err := unix.Mknod(filepath.Join(lower1, "etc/old"), unix.S_IFCHR, 0); err != nil {
+		t.Skipf("creating whiteouts needs privilege: %v", err)
+	}
+
+	mustWrite(t, filepath.Join(upper, "etc/passwd"), "root\nuser", 0644)
+	mustWrite(t, filepath.Join(upper, "etc/old"), "recreated", 0644)
+	mustWrite(t, filepath.Join(upper, "usr/bin/app"), "app", 0755)
+	if err := unix.Mknod(filepath.Join(upper, "usr/bin/sh"), unix.S_IFCHR, 0); err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(t, filepath.Join(upper, "var/cache/b"), "new b", 0644)
+	if err := unix.Lsetxattr(filepath.Join(upper, "var/cache"), overlayOpaqueXattr, []byte("y"), 0); err != nil {
+		t.Skipf("setting trusted xattr needs privilege: %v", err)
+	}
+
+	changes, err := Changes(upper, []string{lower1, lower2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{"/etc", ChangeModify},
+		{"/etc/old", ChangeAdd},
+		{"/etc/passwd", ChangeModify},
+		{"/usr", ChangeModify},
+		{"/usr/bin", ChangeModify},
+		{"/usr/bin/app", ChangeAdd},
+		{"/usr/bin/sh", ChangeDelete},
+		{"/var", ChangeModify},
+		{"/var/cache", ChangeModify},
+		{"/var/cache/a", ChangeDelete},
+		{"/var/cache/b", ChangeAdd},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("got changes %v, expect %v", changes, expected)
+	}
+}
+
+func TestChangesDirs(t *testing.T) {
+	lower1, lower2, dir := t.TempDir(), t.TempDir(), t.TempDir()
+	mustWrite(t, filepath.Join(lower2, "etc/old"), "old", 0644)
+	mustWrite(t, filepath.Join(lower2, "etc/passwd"), "root", 0644)
+	mustWrite(t, filepath.Join(lower2, "var/cache/a"), "a", 0644)
+	mustWrite(t, filepath.Join(lower1, "usr/bin/sh"), "sh", 0755)
+	mustWrite(t, filepath.Join(lower1, "var/cache/c"), "c", 0644)
+	if err := os.Mkdir(filepath.Join(lower1, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Mknod(filepath.Join(lower1, "etc/old"), unix.S_IFCHR, 0); err != nil {
+		t.Skipf("creating whiteouts needs privilege: %v", err)
+	}
+	if err := unix.Lsetxattr(filepath.Join(lower1, "var/cache"), overlayOpaqueXattr, []byte("y"), 0); err != nil {
+		t.Skipf("setting trusted xattr needs privilege: %v", err)
+	}
+
+	// 由下到上叠加各层，得到完整的根文件系统
+	for _, layer := range []string{lower2, lower1} {
+		buf := &bytes.Buffer{}
+		if err := TarLayer(layer, buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := ApplyLayer(buf, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, exist := range map[string]bool{"etc/old": false, "etc/passwd": true, "var/cache/a": false, "var/cache/c": true, "usr/bin/sh": true} {
+		if _, err := os.Lstat(filepath.Join(dir, path)); (err == nil) != exist {
+			t.Errorf("%s exists: %v, expect %v", path, err == nil, exist)
+		}
+	}
+	lowers := []string{lower1, lower2}
+	if changes, err := ChangesDirs(dir, lowers); err != nil || len(changes) != 0 {
+		t.Fatalf("expect no changes after applying layers, got %v, %v", changes, err)
+	}
+
+	mustWrite(t, filepath.Join(dir, "etc/passwd"), "root\nuser", 0644)
+	mustWrite(t, filepath.Join(dir, "usr/bin/app"), "app", 0755)
+	if err := os.Remove(filepath.Join(dir, "usr/bin/sh")); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := ChangesDirs(dir, lowers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{"/etc/passwd", ChangeModify},
+		{"/usr/bin", ChangeModify},
+		{"/usr/bin/app", ChangeAdd},
+		{"/usr/bin/sh", ChangeDelete},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("got changes %v, expect %v", changes, expected)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := TarChanges(dir, changes, buf); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if expect := []string{"etc/passwd", "usr/bin/", "usr/bin/app", "usr/bin/.wh.sh"}; !reflect.DeepEqual(names, expect) {
+		t.Errorf("got entries %v, expect %v", names, expect)
+	}
+}
@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+)
+
+// Compression tar 包的压缩格式
+type Compression int
+
+const (
+	Uncompressed Compression = iota
+	Gzip
+	Zstd
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// String 压缩格式的名字
+func (c Compression) String() string {
+	switch c {
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	default:
+		return "none"
+	}
+}
+
+// ParseCompression 根据名字得到压缩格式
+func ParseCompression(name string) (Compression, error) {
+	switch name {
+	case "", "none":
+		return Uncompressed, nil
+	case "gzip", "gz":
+		return Gzip, nil
+	case "zstd", "zst":
+		return Zstd, nil
+	default:
+		return Uncompressed, fmt.Errorf("unsupported compression: %s", name)
+	}
+}
+
+// DetectCompression 根据数据开头的魔数判断压缩格式
+func DetectCompression(header []byte) Compression {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return Zstd
+	default:
+		return Uncompressed
+	}
+}
+
+// DecompressStream 根据魔数判断压缩格式并返回解压后的数据流
+func DecompressStream(r io.Reader) (io.ReadCloser, error) {
+	buf := bufio.NewReader(r)
+	magic, err := buf.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("read compression header fails: %v", err)
+	}
+	switch DetectCompression(magic) {
+	case Gzip:
+		gzReader, err := gzip.NewReader(buf)
+		if err != nil {
+			return nil, fmt.Errorf("open gzip stream fails: %v", err)
+		}
+		return gzReader, nil
+	case Zstd:
+		zstdReader, err := zstd.NewReader(buf)
+		if err != nil {
+			return nil, fmt.Errorf("open zstd stream fails: %v", err)
+		}
+		return zstdReader.IOReadCloser(), nil
+	default:
+		return io.NopCloser(buf), nil
+	}
+}
+
+// CompressStream 返回按指定格式压缩数据的 Writer，Close 时写入压缩尾部但不关闭 w
+func CompressStream(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w)
+	case Uncompressed:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression: %v", c)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,113 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// CopyPath 复制文件或目录(递归)到 dst，保留权限、修改时间和符号链接
+// uid/gid 为 -1 时保留源文件的属主，否则统一修改为指定属主
+func CopyPath(src, dst string, uid, gid int) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.IsDir():
+		if err := os.MkdirAll(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := CopyPath(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()), uid, gid); err != nil {
+				return err
+			}
+		}
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		_ = os.Remove(dst)
+		if err := os.Symlink(link, dst); err != nil {
+			return err
+		}
+	case info.Mode().IsRegular():
+		if err := copyFile(src, dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("copy %s: unsupported file type %v", src, info.Mode().Type())
+	}
+	return copyMetadata(dst, info, uid, gid)
+}
+
+// 复制普通文件内容
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// 复制属主、权限和修改时间
+func copyMetadata(dst string, info os.FileInfo, uid, gid int) error {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if uid == -1 {
+			uid = int(stat.Uid)
+		}
+		if gid == -1 {
+			gid = int(stat.Gid)
+		}
+	}
+	if err := os.Lchown(dst, uid, gid); err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	// chown 会清除 setuid/setgid 位, 需在之后设置权限
+	if err := os.Chmod(dst, info.Mode().Perm()|info.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// DirSize 统计目录下所有文件占用的字节数，硬链接只统计一次
+func DirSize(dir string) (int64, error) {
+	var size int64
+	seen := map[inode]bool{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Nlink > 1 && !info.IsDir() {
+			key := inode{dev: uint64(stat.Dev), ino: stat.Ino}
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -2,6 +2,7 @@ package cgroups
 
 import (
 	"MiniDocker/cgroups/subsystem"
+	"fmt"
 	"github.com/sirupsen/logrus"
 )
 
@@ -27,29 +28,54 @@ func NewCgroupManager(path string) *CgroupManager {
 
 // Set 设置subsystem到cgroup中，如果cgroup路径不存在会新建
 // 这可能会创还能多个cgroups，如果他们不在同一个hierarchy中
+// 资源限制设置失败时只警告；设备规则设置失败时容器可以访问宿主机的所有设备，返回错误
+// rootless 模式下使用委派给当前用户的 cgroup v2 子树，见 Rootless
 func (cm *CgroupManager) Set(res *subsystem.ResourceConfig) error {
+	if Rootless {
+		return cm.setDelegated(res)
+	}
 	for _, subs := range subsystem.SubsystemsInstance {
 		if err := subs.Set(cm.Path, res); err != nil {
+			if isDevices(subs) {
+				return fmt.Errorf("set device rules fail: %v", err)
+			}
 			logrus.Warnf("set resource fail: %v", err)
 		}
 	}
 	return nil
 }
 
+// AddProcess 将进程加入各个 subsystem 中的 cgroup，与 Set 一致，只有加入 devices 失败时返回错误
 func (cm *CgroupManager) AddProcess(pid int) error {
+	if Rootless {
+		return cm.addProcessDelegated(pid)
+	}
 	for _, subs := range subsystem.SubsystemsInstance {
 		if err := subs.AddProcess(cm.Path, pid); err != nil {
+			if isDevices(subs) {
+				return fmt.Errorf("add process to devices cgroup fail: %v", err)
+			}
 			logrus.Warnf("add process fail: %v", err)
 		}
 	}
 	return nil
 }
 
+// Remove 删除各个 subsystem 中的 cgroup，某个 subsystem 删除失败时继续删除其余的
 func (cm *CgroupManager) Remove() error {
+	if Rootless {
+		return cm.removeDelegated()
+	}
 	for _, subs := range subsystem.SubsystemsInstance {
 		if err := subs.RemoveCgroup(cm.Path); err != nil {
-			return err
+			logrus.Warnf("remove cgroup fail: %v", err)
 		}
 	}
 	return nil
 }
+
+// 设备默认拒绝的规则是容器隔离的一部分，不能像资源限制一样忽略
+func isDevices(subs subsystem.Subsystem) bool {
+	_, ok := subs.(*subsystem.DevicesSubSystem)
+	return ok
+}
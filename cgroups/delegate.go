@@ -0,0 +1,166 @@
+package cgroups
+
+import (
+	"MiniDocker/cgroups/subsystem"
+	"bufio"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+/*
+Rootless rootless 模式下没有权限写 cgroup v1 的各个 hierarchy，只能使用 cgroup v2 中委派给当前用户的子树
+(如 systemd 为 user@<uid>.service 设置的 Delegate=yes)，不可用时不限制资源
+*/
+var Rootless = false
+
+// 在委派的 cgroup 中创建容器的 cgroup，并设置 memory.max、cpu.weight、cpuset.cpus
+func (cm *CgroupManager) setDelegated(res *subsystem.ResourceConfig) error {
+	cgroupDir, err := delegatedCgroup(cm.Path, true)
+	if err != nil {
+		if res.MemoryLimit != "" || res.CPUShare != "" || res.CPUSet != "" {
+			logrus.Warnf("resource limits are ignored in rootless mode: %v", err)
+		}
+		return nil
+	}
+	limits := []struct {
+		controller, file, value string
+	}{
+		{"memory", "memory.max", res.MemoryLimit},
+		{"cpu", "cpu.weight", cpuWeight(res.CPUShare)},
+		{"cpuset", "cpuset.cpus", res.CPUSet},
+	}
+	for _, limit := range limits {
+		if limit.value == "" {
+			continue
+		}
+		if !controllerEnabled(cgroupDir, limit.controller) {
+			logrus.Warnf("%s controller is not delegated to the current user, %s is ignored", limit.controller, limit.file)
+			continue
+		}
+		if err := os.WriteFile(path.Join(cgroupDir, limit.file), []byte(limit.value), 0644); err != nil {
+			logrus.Warnf("set %s fail: %v", limit.file, err)
+		}
+	}
+	return nil
+}
+
+func (cm *CgroupManager) addProcessDelegated(pid int) error {
+	cgroupDir, err := delegatedCgroup(cm.Path, false)
+	if err != nil {
+		return nil
+	}
+	if err := os.WriteFile(path.Join(cgroupDir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("cgroup add process fail: %v", err)
+	}
+	return nil
+}
+
+func (cm *CgroupManager) removeDelegated() error {
+	cgroupDir, err := delegatedCgroup(cm.Path, false)
+	if err != nil {
+		return nil
+	}
+	return os.Remove(cgroupDir)
+}
+
+/*
+得到委派给当前用户的 cgroup v2 子树中容器的 cgroup 目录，autoCreate 为 true 时不存在则新建
+委派的子树为当前进程所在 cgroup 的各级父目录中，属于当前用户的最上层目录
+*/
+func delegatedCgroup(cgroupPath string, autoCreate bool) (string, error) {
+	root := subsystem.FindCgroupV2Mountpoint()
+	if root == "" {
+		return "", fmt.Errorf("cgroup v2 is not mounted")
+	}
+	own, err := ownCgroup()
+	if err != nil {
+		return "", err
+	}
+	delegated := ""
+	for dir := path.Join(root, own); strings.HasPrefix(dir, root+"/"); dir = path.Dir(dir) {
+		if !ownedByCurrentUser(dir) {
+			break
+		}
+		delegated = dir
+	}
+	if delegated == "" {
+		return "", fmt.Errorf("no cgroup is delegated to the current user")
+	}
+	cgroupDir := path.Join(delegated, cgroupPath)
+	if _, err := os.Stat(cgroupDir); os.IsNotExist(err) && autoCreate {
+		// 在子 cgroup 中启用委派的控制器, 已启用或未委派时忽略错误
+		for _, controller := range []string{"memory", "cpu", "cpuset"} {
+			_ = os.WriteFile(path.Join(delegated, "cgroup.subtree_control"), []byte("+"+controller), 0644)
+		}
+		if err := os.Mkdir(cgroupDir, 0755); err != nil {
+			return "", fmt.Errorf("error when create cgroup: %v", err)
+		}
+	} else if err != nil {
+		return "", err
+	}
+	return cgroupDir, nil
+}
+
+// 当前进程在 cgroup v2 层级中的路径，即 /proc/self/cgroup 中 0:: 开头的行
+func ownCgroup() (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if own, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return own, nil
+		}
+	}
+	return "", fmt.Errorf("process is not in a cgroup v2 hierarchy")
+}
+
+// 目录及其 cgroup.procs 是否属于当前用户(用户命名空间中为映射后的 root)
+func ownedByCurrentUser(dir string) bool {
+	for _, file := range []string{dir, path.Join(dir, "cgroup.procs")} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Geteuid() {
+			return false
+		}
+	}
+	return true
+}
+
+// 判断 cgroup 中是否可以使用控制器
+func controllerEnabled(cgroupDir, controller string) bool {
+	content, err := os.ReadFile(path.Join(cgroupDir, "cgroup.controllers"))
+	if err != nil {
+		return false
+	}
+	for _, c := range strings.Fields(string(content)) {
+		if c == controller {
+			return true
+		}
+	}
+	return false
+}
+
+// cpu.shares(2~262144) 转换为 cgroup v2 的 cpu.weight(1~10000)，与 runc 的换算一致
+func cpuWeight(shares string) string {
+	if shares == "" {
+		return ""
+	}
+	value, err := strconv.ParseUint(shares, 10, 64)
+	if err != nil || value < 2 {
+		return shares
+	}
+	if value > 262144 {
+		value = 262144
+	}
+	return strconv.FormatUint(1+((value-2)*9999)/262142, 10)
+}
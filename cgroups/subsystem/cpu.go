@@ -12,7 +12,7 @@ type CPUSubSystem struct {
 }
 
 func (c *CPUSubSystem) Name() string {
-	return "CPUShare"
+	return "cpu"
 }
 
 // Set 对cgroup设置cpu时间片
@@ -22,7 +22,7 @@ func (c *CPUSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	} else {
 		if res.CPUShare != "" {
 			//	设置cgroup的CPU限制即将限制条件写入cgroupPath对应虚拟文件系统目录中的"cpu.shares"文件
-			if err = os.WriteFile(path.Join(subsystemCgroupPath, "cpu.shares"), []byte(res.MemoryLimit), 0644); err != nil {
+			if err = os.WriteFile(path.Join(subsystemCgroupPath, "cpu.shares"), []byte(res.CPUShare), 0644); err != nil {
 				return fmt.Errorf("set cgroup CPU share fail: %v", err)
 			}
 		}
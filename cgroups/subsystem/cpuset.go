@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 )
 
 // CPUSetSubSystem 限制CPU核心数的subsystem
@@ -12,7 +13,7 @@ type CPUSetSubSystem struct {
 }
 
 func (c *CPUSetSubSystem) Name() string {
-	return "CPUSet"
+	return "cpuset"
 }
 
 // Set 对cgroup设置CPU核心数限制
@@ -20,11 +21,17 @@ func (c *CPUSetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	if subsystemCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, true); err != nil {
 		return err
 	} else {
-		if res.CPUSet != "" {
-			//	设置cgroup内存限制即将限制条件写入cgroupPath对应虚拟文件系统目录中的“cpuset.cpus”文件
-			if err = os.WriteFile(path.Join(subsystemCgroupPath, "cpuset.cpus"), []byte(res.MemoryLimit), 0644); err != nil {
-				return fmt.Errorf("set cgroup CPUSet fail: %v", err)
-			}
+		cpus := res.CPUSet
+		if cpus == "" {
+			cpus = readParentCpuset(subsystemCgroupPath, "cpuset.cpus")
+		}
+		//	设置cgroup内存限制即将限制条件写入cgroupPath对应虚拟文件系统目录中的“cpuset.cpus”文件
+		if err = os.WriteFile(path.Join(subsystemCgroupPath, "cpuset.cpus"), []byte(cpus), 0644); err != nil {
+			return fmt.Errorf("set cgroup CPUSet fail: %v", err)
+		}
+		// 新建的 cpuset cgroup 的 cpuset.mems 为空，此时无法加入进程，继承父 cgroup 的配置
+		if err = os.WriteFile(path.Join(subsystemCgroupPath, "cpuset.mems"), []byte(readParentCpuset(subsystemCgroupPath, "cpuset.mems")), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpuset.mems fail: %v", err)
 		}
 		return nil
 	}
@@ -51,3 +58,9 @@ func (c *CPUSetSubSystem) RemoveCgroup(cgroupPath string) error {
 		return os.Remove(subsystemCgroupPath)
 	}
 }
+
+// 读取父 cgroup 中 cpuset 的配置
+func readParentCpuset(cgroupPath, file string) string {
+	data, _ := os.ReadFile(path.Join(path.Dir(cgroupPath), file))
+	return strings.TrimSpace(string(data))
+}
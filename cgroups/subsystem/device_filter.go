@@ -0,0 +1,196 @@
+package subsystem
+
+import (
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"strings"
+	"unsafe"
+)
+
+/*
+cgroup v2 的设备过滤程序
+程序的输入为 struct bpf_cgroup_dev_ctx { u32 access_type; u32 major; u32 minor; }
+access_type 的低 16 位为设备类型(BPF_DEVCG_DEV_BLOCK/CHAR)，高 16 位为访问类型(BPF_DEVCG_ACC_MKNOD/READ/WRITE)
+按顺序匹配规则，命中则返回 1 允许访问，都不命中返回 0 拒绝访问
+*/
+
+// bpf_cgroup_dev_ctx 中的设备类型和访问类型
+const (
+	bpfDevcgDevBlock = 1
+	bpfDevcgDevChar  = 2
+	bpfDevcgAccMknod = 1
+	bpfDevcgAccRead  = 2
+	bpfDevcgAccWrite = 4
+)
+
+// 用到的 eBPF 指令操作码
+const (
+	bpfLdxMemW  = 0x61 // dst = *(u32 *)(src + off)
+	bpfAndImm   = 0x57 // dst &= imm
+	bpfRshImm   = 0x77 // dst >>= imm
+	bpfMovReg   = 0xbf // dst = src
+	bpfMovImm   = 0xb7 // dst = imm
+	bpfJneImm   = 0x55 // if dst != imm goto pc + off
+	bpfJneReg   = 0x5d // if dst != src goto pc + off
+	bpfExit     = 0x95 // return r0
+	bpfInsnSize = 8
+)
+
+// eBPF 指令
+type bpfInsn struct {
+	op  uint8
+	dst uint8
+	src uint8
+	off int16
+	imm int32
+}
+
+func (insn bpfInsn) encode() []byte {
+	buf := make([]byte, bpfInsnSize)
+	buf[0] = insn.op
+	buf[1] = insn.src<<4 | insn.dst
+	binary.LittleEndian.PutUint16(buf[2:], uint16(insn.off))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(insn.imm))
+	return buf
+}
+
+// buildDeviceFilter 将设备规则编译为 eBPF 程序
+func buildDeviceFilter(rules []DeviceRule) ([]bpfInsn, error) {
+	// r2 = 设备类型, r3 = 访问类型, r4 = 主设备号, r5 = 次设备号
+	insns := []bpfInsn{
+		{op: bpfLdxMemW, dst: 2, src: 1, off: 0},
+		{op: bpfAndImm, dst: 2, imm: 0xffff},
+		{op: bpfLdxMemW, dst: 3, src: 1, off: 0},
+		{op: bpfRshImm, dst: 3, imm: 16},
+		{op: bpfLdxMemW, dst: 4, src: 1, off: 4},
+		{op: bpfLdxMemW, dst: 5, src: 1, off: 8},
+	}
+	for _, rule := range rules {
+		var block []bpfInsn
+		switch rule.Type {
+		case DeviceTypeAll:
+		case DeviceTypeChar:
+			block = append(block, bpfInsn{op: bpfJneImm, dst: 2, imm: bpfDevcgDevChar})
+		case DeviceTypeBlock:
+			block = append(block, bpfInsn{op: bpfJneImm, dst: 2, imm: bpfDevcgDevBlock})
+		default:
+			return nil, fmt.Errorf("invalid device type %q", rule.Type)
+		}
+		var access int32
+		for _, p := range rule.Permissions {
+			switch p {
+			case 'r':
+				access |= bpfDevcgAccRead
+			case 'w':
+				access |= bpfDevcgAccWrite
+			case 'm':
+				access |= bpfDevcgAccMknod
+			default:
+				return nil, fmt.Errorf("invalid device permission %q", p)
+			}
+		}
+		// 请求的访问类型必须是规则允许的子集: (r3 & access) == r3
+		if rule.Type != DeviceTypeAll && access != bpfDevcgAccRead|bpfDevcgAccWrite|bpfDevcgAccMknod {
+			block = append(block,
+				bpfInsn{op: bpfMovReg, dst: 1, src: 3},
+				bpfInsn{op: bpfAndImm, dst: 1, imm: access},
+				bpfInsn{op: bpfJneReg, dst: 1, src: 3})
+		}
+		if rule.Type != DeviceTypeAll && rule.Major != DeviceWildcard {
+			block = append(block, bpfInsn{op: bpfJneImm, dst: 4, imm: int32(rule.Major)})
+		}
+		if rule.Type != DeviceTypeAll && rule.Minor != DeviceWildcard {
+			block = append(block, bpfInsn{op: bpfJneImm, dst: 5, imm: int32(rule.Minor)})
+		}
+		// 跳转指令不匹配时跳过本条规则剩余的指令和 return 1
+		for i := range block {
+			if block[i].op == bpfJneImm || block[i].op == bpfJneReg {
+				block[i].off = int16(len(block) - i - 1 + 2)
+			}
+		}
+		block = append(block, bpfInsn{op: bpfMovImm, dst: 0, imm: 1}, bpfInsn{op: bpfExit})
+		insns = append(insns, block...)
+	}
+	insns = append(insns, bpfInsn{op: bpfMovImm, dst: 0, imm: 0}, bpfInsn{op: bpfExit})
+	return insns, nil
+}
+
+// bpf(BPF_PROG_LOAD) 的参数
+type bpfProgLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+}
+
+// bpf(BPF_PROG_ATTACH) 的参数
+type bpfProgAttachAttr struct {
+	targetFd    uint32
+	attachBpfFd uint32
+	attachType  uint32
+	attachFlags uint32
+}
+
+// 加载设备过滤程序并挂载到 cgroupDir 上
+func attachDeviceFilter(cgroupDir string, rules []DeviceRule) error {
+	insns, err := buildDeviceFilter(rules)
+	if err != nil {
+		return err
+	}
+	var code []byte
+	for _, insn := range insns {
+		code = append(code, insn.encode()...)
+	}
+	progFd, err := loadDeviceFilter(code, nil)
+	if err != nil {
+		// 加载失败时带上日志重新加载一次，输出校验器的错误信息
+		logBuf := make([]byte, 1<<20)
+		if _, err := loadDeviceFilter(code, logBuf); err != nil {
+			return fmt.Errorf("load device filter fails: %v: %s", err, strings.TrimRight(string(logBuf), "\x00"))
+		}
+		return fmt.Errorf("load device filter fails: %v", err)
+	}
+	defer unix.Close(progFd)
+
+	cgroupFd, err := unix.Open(cgroupDir, unix.O_DIRECTORY|unix.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open cgroup %s fails: %v", cgroupDir, err)
+	}
+	defer unix.Close(cgroupFd)
+	attachAttr := bpfProgAttachAttr{
+		targetFd:    uint32(cgroupFd),
+		attachBpfFd: uint32(progFd),
+		attachType:  unix.BPF_CGROUP_DEVICE,
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_ATTACH, uintptr(unsafe.Pointer(&attachAttr)), unsafe.Sizeof(attachAttr)); errno != 0 {
+		return fmt.Errorf("attach device filter to %s fails: %v", cgroupDir, errno)
+	}
+	return nil
+}
+
+// 调用 bpf(BPF_PROG_LOAD) 加载程序，logBuf 不为空时记录校验器日志
+func loadDeviceFilter(code []byte, logBuf []byte) (int, error) {
+	license := []byte("GPL\x00")
+	attr := bpfProgLoadAttr{
+		progType: unix.BPF_PROG_TYPE_CGROUP_DEVICE,
+		insnCnt:  uint32(len(code) / 8),
+		insns:    uint64(uintptr(unsafe.Pointer(&code[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+	}
+	if len(logBuf) > 0 {
+		attr.logLevel = 1
+		attr.logSize = uint32(len(logBuf))
+		attr.logBuf = uint64(uintptr(unsafe.Pointer(&logBuf[0])))
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
@@ -0,0 +1,87 @@
+package subsystem
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+// 解释执行设备过滤程序中用到的指令
+func runDeviceFilter(t *testing.T, insns []bpfInsn, devType, access, major, minor uint32) bool {
+	var regs [11]uint64
+	ctx := []uint32{access<<16 | devType, major, minor}
+	for pc := 0; pc < len(insns); pc++ {
+		insn := insns[pc]
+		switch insn.op {
+		case bpfLdxMemW:
+			regs[insn.dst] = uint64(ctx[insn.off/4])
+		case bpfAndImm:
+			regs[insn.dst] &= uint64(insn.imm)
+		case bpfRshImm:
+			regs[insn.dst] >>= uint64(insn.imm)
+		case bpfMovReg:
+			regs[insn.dst] = regs[insn.src]
+		case bpfMovImm:
+			regs[insn.dst] = uint64(insn.imm)
+		case bpfJneImm:
+			if regs[insn.dst] != uint64(insn.imm) {
+				pc += int(insn.off)
+			}
+		case bpfJneReg:
+			if regs[insn.dst] != regs[insn.src] {
+				pc += int(insn.off)
+			}
+		case bpfExit:
+			return regs[0] == 1
+		default:
+			t.Fatalf("unexpected op %#x", insn.op)
+		}
+	}
+	t.Fatal("program does not exit")
+	return false
+}
+
+func TestDeviceFilter(t *testing.T) {
+	fuse := DeviceRule{Type: DeviceTypeChar, Major: 10, Minor: 229, Permissions: "rw"}
+	insns, err := buildDeviceFilter(append(append([]DeviceRule{}, DefaultDeviceRules...), fuse))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		devType, access, major, minor uint32
+		allow                         bool
+	}{
+		{bpfDevcgDevChar, bpfDevcgAccRead | bpfDevcgAccWrite, 1, 3, true},  // /dev/null
+		{bpfDevcgDevChar, bpfDevcgAccWrite, 136, 5, true},                  // /dev/pts/5
+		{bpfDevcgDevChar, bpfDevcgAccMknod, 10, 229, true},                 // c *:* m
+		{bpfDevcgDevChar, bpfDevcgAccRead, 10, 229, true},                  // fuse
+		{bpfDevcgDevChar, bpfDevcgAccRead, 10, 230, false},                 // 次设备号不匹配
+		{bpfDevcgDevBlock, bpfDevcgAccRead, 8, 0, false},                   // /dev/sda
+		{bpfDevcgDevBlock, bpfDevcgAccMknod, 8, 0, true},                   // b *:* m
+		{bpfDevcgDevChar, bpfDevcgAccRead | bpfDevcgAccMknod, 1, 1, false}, // /dev/mem
+	} {
+		if got := runDeviceFilter(t, insns, tt.devType, tt.access, tt.major, tt.minor); got != tt.allow {
+			t.Errorf("device type %d access %d %d:%d: got %v, expect %v", tt.devType, tt.access, tt.major, tt.minor, got, tt.allow)
+		}
+	}
+
+	if _, err := buildDeviceFilter([]DeviceRule{{Type: DeviceTypeChar, Permissions: "x"}}); err == nil {
+		t.Error("expect error for invalid permission")
+	}
+}
+
+// 内核接受生成的程序，需要 root 权限和 cgroup2
+func TestAttachDeviceFilter(t *testing.T) {
+	root := FindCgroupV2Mountpoint()
+	if os.Geteuid() != 0 || root == "" {
+		t.Skip("need root and cgroup2")
+	}
+	dir := path.Join(root, "minidocker-device-filter-test")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Skipf("create cgroup: %v", err)
+	}
+	defer os.Remove(dir)
+	if err := attachDeviceFilter(dir, DefaultDeviceRules); err != nil {
+		t.Fatal(err)
+	}
+}
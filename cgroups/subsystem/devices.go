@@ -0,0 +1,130 @@
+package subsystem
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+)
+
+// 设备通配符
+const (
+	DeviceTypeAll   = 'a' // 所有设备
+	DeviceTypeChar  = 'c' // 字符设备
+	DeviceTypeBlock = 'b' // 块设备
+	DeviceWildcard  = -1  // 任意主/次设备号
+)
+
+// DeviceRule 设备访问规则，格式与 cgroup v1 的 devices.allow 一致, 如 "c 10:229 rwm"
+type DeviceRule struct {
+	Type        rune   // 设备类型 a|c|b
+	Major       int64  // 主设备号, DeviceWildcard 表示任意
+	Minor       int64  // 次设备号, DeviceWildcard 表示任意
+	Permissions string // r 读, w 写, m 创建设备节点
+}
+
+func (r DeviceRule) String() string {
+	if r.Type == DeviceTypeAll {
+		return "a"
+	}
+	return fmt.Sprintf("%c %s:%s %s", r.Type, deviceNumber(r.Major), deviceNumber(r.Minor), r.Permissions)
+}
+
+func deviceNumber(n int64) string {
+	if n == DeviceWildcard {
+		return "*"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// DefaultDeviceRules 容器默认允许访问的设备，与 docker 一致，其他设备默认拒绝
+var DefaultDeviceRules = []DeviceRule{
+	{Type: DeviceTypeChar, Major: DeviceWildcard, Minor: DeviceWildcard, Permissions: "m"},  // 允许创建任意设备节点, 但不能读写
+	{Type: DeviceTypeBlock, Major: DeviceWildcard, Minor: DeviceWildcard, Permissions: "m"}, // 同上
+	{Type: DeviceTypeChar, Major: 1, Minor: 3, Permissions: "rwm"},                          // /dev/null
+	{Type: DeviceTypeChar, Major: 1, Minor: 5, Permissions: "rwm"},                          // /dev/zero
+	{Type: DeviceTypeChar, Major: 1, Minor: 7, Permissions: "rwm"},                          // /dev/full
+	{Type: DeviceTypeChar, Major: 1, Minor: 8, Permissions: "rwm"},                          // /dev/random
+	{Type: DeviceTypeChar, Major: 1, Minor: 9, Permissions: "rwm"},                          // /dev/urandom
+	{Type: DeviceTypeChar, Major: 5, Minor: 0, Permissions: "rwm"},                          // /dev/tty
+	{Type: DeviceTypeChar, Major: 5, Minor: 1, Permissions: "rwm"},                          // /dev/console
+	{Type: DeviceTypeChar, Major: 5, Minor: 2, Permissions: "rwm"},                          // /dev/pts/ptmx
+	{Type: DeviceTypeChar, Major: 136, Minor: DeviceWildcard, Permissions: "rwm"},           // /dev/pts/*
+}
+
+// DevicesSubSystem 限制容器可以访问的设备
+// cgroup v1 使用 devices 子系统，只有 cgroup v2 时在 cgroup 上挂载 eBPF 设备过滤程序
+type DevicesSubSystem struct {
+}
+
+func (ds *DevicesSubSystem) Name() string {
+	return "devices"
+}
+
+// Set 默认拒绝所有设备，只允许 DefaultDeviceRules 和 res.Devices 中的设备
+func (ds *DevicesSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	rules := append(append([]DeviceRule{}, DefaultDeviceRules...), res.Devices...)
+	if FindCgroupMountpoint(ds.Name()) == "" {
+		return ds.setV2(cgroupPath, rules)
+	}
+	subsystemCgroupPath, err := GetCgroupPath(ds.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path.Join(subsystemCgroupPath, "devices.deny"), []byte("a"), 0644); err != nil {
+		return fmt.Errorf("set cgroup devices.deny fail: %v", err)
+	}
+	for _, rule := range rules {
+		if err := os.WriteFile(path.Join(subsystemCgroupPath, "devices.allow"), []byte(rule.String()), 0644); err != nil {
+			return fmt.Errorf("allow device %q fail: %v", rule, err)
+		}
+	}
+	return nil
+}
+
+// cgroup v2 没有 devices 接口文件，需要在 cgroup 目录上挂载 BPF_PROG_TYPE_CGROUP_DEVICE 程序
+func (ds *DevicesSubSystem) setV2(cgroupPath string, rules []DeviceRule) error {
+	cgroupDir, err := getCgroupV2Path(cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	return attachDeviceFilter(cgroupDir, rules)
+}
+
+// AddProcess 添加进程到该subsystem
+func (ds *DevicesSubSystem) AddProcess(cgroupPath string, pid int) error {
+	if FindCgroupMountpoint(ds.Name()) == "" {
+		cgroupDir, err := getCgroupV2Path(cgroupPath, false)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path.Join(cgroupDir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("cgroup add process fail: %v", err)
+		}
+		return nil
+	}
+	subsystemCgroupPath, err := GetCgroupPath(ds.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path.Join(subsystemCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("cgroup add process fail: %v", err)
+	}
+	return nil
+}
+
+// RemoveCgroup 删除 cgroup 目录，cgroup v2 上挂载的 BPF 程序随 cgroup 一起释放
+func (ds *DevicesSubSystem) RemoveCgroup(cgroupPath string) error {
+	if FindCgroupMountpoint(ds.Name()) == "" {
+		cgroupDir, err := getCgroupV2Path(cgroupPath, false)
+		if err != nil {
+			return err
+		}
+		return os.Remove(cgroupDir)
+	}
+	subsystemCgroupPath, err := GetCgroupPath(ds.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	return os.Remove(subsystemCgroupPath)
+}
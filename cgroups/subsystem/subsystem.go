@@ -2,9 +2,10 @@ package subsystem
 
 // ResourceConfig 传递资源限制
 type ResourceConfig struct {
-	MemoryLimit string // 内存限制
-	CPUShare    string // CPU时间片权重
-	CPUSet      string // CPU核心数
+	MemoryLimit string       // 内存限制
+	CPUShare    string       // CPU时间片权重
+	CPUSet      string       // CPU核心数
+	Devices     []DeviceRule // 除默认设备外允许访问的设备
 }
 
 // Subsystem 接口，每个subsystem都要实现
@@ -27,4 +28,5 @@ var SubsystemsInstance = []Subsystem{
 	&MemorySubSystem{},
 	&CPUSubSystem{},
 	&CPUSetSubSystem{},
+	&DevicesSubSystem{},
 }
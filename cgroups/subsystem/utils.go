@@ -56,3 +56,43 @@ func GetCgroupPath(subsystemName string, cgroupPath string, autoCreate bool) (st
 		return "", fmt.Errorf("cgroup path error: %v", err)
 	}
 }
+
+// FindCgroupV2Mountpoint 在 /proc/self/mountinfo 中查找 cgroup2 文件系统的挂载点
+func FindCgroupV2Mountpoint() string {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 可选字段之后以 "-" 分隔，其后第一个字段为文件系统类型
+		fields := strings.Split(scanner.Text(), " ")
+		for i, field := range fields {
+			if field == "-" && i+1 < len(fields) && fields[i+1] == "cgroup2" {
+				return fields[4]
+			}
+		}
+	}
+	return ""
+}
+
+// 获取 cgroup v2 层级中 cgroup 的路径，autoCreate 为 true 时不存在则新建
+func getCgroupV2Path(cgroupPath string, autoCreate bool) (string, error) {
+	root := FindCgroupV2Mountpoint()
+	if root == "" {
+		return "", fmt.Errorf("cgroup2 is not mounted")
+	}
+	expectedPath := path.Join(root, cgroupPath)
+	if _, err := os.Stat(expectedPath); err == nil || (autoCreate && os.IsNotExist(err)) {
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(expectedPath, 0755); err != nil {
+				return "", fmt.Errorf("error when create cgroup: %v", err)
+			}
+		}
+		return expectedPath, nil
+	} else {
+		return "", fmt.Errorf("cgroup path error: %v", err)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"MiniDocker/container"
 	"MiniDocker/dockerCommand"
 	"MiniDocker/network"
+	"MiniDocker/registry"
+	"MiniDocker/rootless"
 	"errors"
 	"fmt"
 	"os"
@@ -42,10 +44,78 @@ var runCommand = cli.Command{
 			Name:  "cpushare",
 			Usage: "limit the cpu share",
 		},
-		// 挂载数据卷
-		&cli.StringFlag{
+		// 挂载数据卷, 可指定多个
+		&cli.StringSliceFlag{
 			Name:  "v",
-			Usage: "set volume, user: -v [volumeDir]:[containerVolumeDir]",
+			Usage: "bind mount a volume, use: -v [volumeDir]:[containerVolumeDir][:ro|rw][,z][,rshared], volumeDir can be a volume name",
+		},
+		&cli.StringSliceFlag{
+			Name:  "mount",
+			Usage: "attach a filesystem mount, use: --mount type=bind|volume|tmpfs,src=[volumeDir],dst=[containerVolumeDir][,readonly][,bind-propagation=rshared][,volume-driver=local][,tmpfs-size=64m]",
+		},
+		// 只读根目录
+		&cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "mount the container's root filesystem as read only, volumes and tmpfs mounts stay writable",
+		},
+		// /dev/shm 大小
+		&cli.StringFlag{
+			Name:  "shm-size",
+			Value: container.DefaultShmSize,
+			Usage: "size of /dev/shm, use: --shm-size [number][k|m|g]",
+		},
+		// 挂载 tmpfs, 可指定多个
+		&cli.StringSliceFlag{
+			Name:  "tmpfs",
+			Usage: "mount a tmpfs, use: --tmpfs [containerDir][:size=64m,mode=1777]",
+		},
+		// 添加宿主机设备, 可指定多个
+		&cli.StringSliceFlag{
+			Name:  "device",
+			Usage: "add a host device to the container, use: --device hostPath[:containerPath][:rwm]",
+		},
+		// 容器进程的能力
+		&cli.StringSliceFlag{
+			Name:  "cap-add",
+			Usage: "add a linux capability to the default set, use: --cap-add NET_ADMIN|CAP_SYS_TIME|ALL",
+		},
+		&cli.StringSliceFlag{
+			Name:  "cap-drop",
+			Usage: "drop a linux capability from the default set, use: --cap-drop NET_RAW|ALL",
+		},
+		&cli.BoolFlag{
+			Name:  "privileged",
+			Usage: "give all capabilities to the container and allow it to access all devices",
+		},
+		// 可写层大小限制
+		&cli.StringSliceFlag{
+			Name:  "storage-opt",
+			Usage: "storage driver options, use: --storage-opt size=2G to limit the size of the container's writable layer",
+		},
+		&cli.StringFlag{
+			Name:  "volume-driver",
+			Usage: "volume driver for named volumes created by -v",
+		},
+		// 主机名和 DNS
+		&cli.StringFlag{
+			Name:  "hostname",
+			Usage: "container host name, default is the container id",
+		},
+		&cli.StringSliceFlag{
+			Name:  "add-host",
+			Usage: "add a custom host-to-IP mapping, use: --add-host name:ip",
+		},
+		&cli.StringSliceFlag{
+			Name:  "dns",
+			Usage: "set custom dns servers",
+		},
+		&cli.StringSliceFlag{
+			Name:  "dns-search",
+			Usage: "set custom dns search domains",
+		},
+		&cli.StringSliceFlag{
+			Name:  "dns-option",
+			Usage: "set dns options, use: --dns-option ndots:2",
 		},
 		// 指定容器名字
 		&cli.StringFlag{
@@ -60,13 +130,23 @@ var runCommand = cli.Command{
 		// 设置网络
 		&cli.StringFlag{
 			Name:  "net",
-			Usage: "set container network",
+			Usage: "set container network: a network created by network create, none (loopback only) or host",
 		},
 		// 设置端口映射
 		&cli.StringSliceFlag{
 			Name:  "p",
 			Usage: "set port mapping",
 		},
+		// 用户命名空间
+		&cli.StringFlag{
+			Name:  "userns-remap",
+			Usage: "run the container in a user namespace, use: --userns-remap default|user[:group]|host, ranges are read from /etc/subuid and /etc/subgid (default: userns-remap of the config file)",
+		},
+		// 校验镜像签名
+		&cli.BoolFlag{
+			Name:  "verify",
+			Usage: "refuse to run the image unless it has a valid signature from a trusted key",
+		},
 	},
 	/*
 		run 命令执行的函数
@@ -100,8 +180,73 @@ var runCommand = cli.Command{
 			CPUSet:      context.String("cpu"),
 		}
 
-		// 传递volume
-		volume := context.String("v")
+		// 解析设备, 并允许容器在 devices cgroup 中访问这些设备
+		devices, err := container.ParseDevices(context.StringSlice("device"))
+		if err != nil {
+			return err
+		}
+		for _, d := range devices {
+			resourceConfig.Devices = append(resourceConfig.Devices, subsystem.DeviceRule{
+				Type:        rune(d.Type[0]),
+				Major:       d.Major,
+				Minor:       d.Minor,
+				Permissions: d.Permissions,
+			})
+		}
+
+		// 在默认能力集合上增减能力, privileged 时拥有所有能力且可以访问所有设备
+		privileged := context.Bool("privileged")
+		capabilities, err := container.ParseCapabilities(context.StringSlice("cap-add"), context.StringSlice("cap-drop"), privileged)
+		if err != nil {
+			return err
+		}
+		if privileged {
+			resourceConfig.Devices = append(resourceConfig.Devices, subsystem.DeviceRule{
+				Type:        subsystem.DeviceTypeAll,
+				Major:       subsystem.DeviceWildcard,
+				Minor:       subsystem.DeviceWildcard,
+				Permissions: "rwm",
+			})
+		}
+
+		storageSize, err := container.ParseStorageOpts(context.StringSlice("storage-opt"))
+		if err != nil {
+			return err
+		}
+
+		// 未指定时使用配置文件中的映射, host 表示不使用用户命名空间
+		remap := container.UsernsRemap
+		if context.IsSet("userns-remap") {
+			remap = context.String("userns-remap")
+		}
+		idMappings, err := container.LoadIDMappings(remap)
+		if err != nil {
+			return err
+		}
+
+		// rootless 模式下已位于用户命名空间中，不能挂载 loop 设备，也不能创建网桥
+		if rootless.Enabled() {
+			if idMappings != nil {
+				return fmt.Errorf("--userns-remap is not supported in rootless mode, containers already run in the user namespace of the current user")
+			}
+			if storageSize > 0 {
+				return fmt.Errorf("--storage-opt size is not supported in rootless mode")
+			}
+			if err := network.CheckRootless(context.String("net"), context.StringSlice("p")); err != nil {
+				return err
+			}
+		}
+
+		// 解析数据卷
+		mounts, err := container.ParseMounts(context.StringSlice("v"), context.StringSlice("tmpfs"), context.StringSlice("mount"))
+		if err != nil {
+			return err
+		}
+		for i := range mounts {
+			if mounts[i].Type == container.MountTypeVolume && mounts[i].Driver == "" {
+				mounts[i].Driver = context.String("volume-driver")
+			}
+		}
 		// 容器名
 		containerName := context.String("name")
 
@@ -117,7 +262,31 @@ var runCommand = cli.Command{
 		portmapping := context.StringSlice("p")
 
 		// 启动函数
-		dockerCommand.Run(createTTY, containerCmd, &resourceConfig, volume, containerName, imageName, envSlice, network, portmapping)
+		initConfig := &container.InitConfig{
+			Cmd:          containerCmd,
+			Mounts:       mounts,
+			ReadOnly:     context.Bool("read-only"),
+			ShmSize:      context.String("shm-size"),
+			Devices:      devices,
+			Hostname:     context.String("hostname"),
+			Capabilities: capabilities,
+		}
+		if initConfig.Hostname != "" && !container.ValidHostname(initConfig.Hostname) {
+			return fmt.Errorf("invalid hostname %q", initConfig.Hostname)
+		}
+		etcConfig := &container.EtcConfig{
+			ExtraHosts: context.StringSlice("add-host"),
+			DNS:        context.StringSlice("dns"),
+			DNSSearch:  context.StringSlice("dns-search"),
+			DNSOptions: context.StringSlice("dns-option"),
+		}
+		if err := etcConfig.Validate(); err != nil {
+			return err
+		}
+		if !container.ValidShmSize(initConfig.ShmSize) {
+			return fmt.Errorf("invalid shm size %q, use: [number][k|m|g]", initConfig.ShmSize)
+		}
+		dockerCommand.Run(createTTY, initConfig, &resourceConfig, storageSize, containerName, imageName, envSlice, network, portmapping, etcConfig, context.Bool("verify"), idMappings)
 
 		return nil
 	},
@@ -148,8 +317,395 @@ var commitCommand = cli.Command{
 		}
 		containerName := context.Args().Get(0)
 		imageName := context.Args().Get(1)
-		dockerCommand.CommitContainer(containerName, imageName)
-		return nil
+		return dockerCommand.CommitContainer(containerName, imageName)
+	},
+}
+
+// 在宿主机和容器之间复制文件
+var cpCommand = cli.Command{
+	Name:  "cp",
+	Usage: "copy files between a container and the host; cp [containerName:]srcPath [containerName:]dstPath",
+	Action: func(context *cli.Context) error {
+		if context.Args().Len() != 2 {
+			return fmt.Errorf("missing source or destination, use: cp container:srcPath dstPath|-, cp srcPath|- container:dstPath")
+		}
+		return dockerCommand.CopyFiles(context.Args().Get(0), context.Args().Get(1))
+	},
+}
+
+// 列出容器文件系统的变化
+var diffCommand = cli.Command{
+	Name:  "diff",
+	Usage: "inspect changes to files on a container's filesystem; diff [containerName]",
+	Action: func(context *cli.Context) error {
+		if context.Args().Len() < 1 {
+			return fmt.Errorf("missing container name, use: diff [containerName]")
+		}
+		return dockerCommand.DiffContainer(context.Args().Get(0))
+	},
+}
+
+// 根据 Dockerfile 构建镜像命令
+var buildCommand = cli.Command{
+	Name:  "build",
+	Usage: "build an image from a Dockerfile; build -t [imageName] -f [Dockerfile] [context]",
+	Flags: []cli.Flag{
+		// 镜像名
+		&cli.StringFlag{
+			Name:  "t",
+			Usage: "name and optionally a tag in the 'name:tag' format",
+		},
+		// Dockerfile 路径，默认为 context/Dockerfile
+		&cli.StringFlag{
+			Name:  "f",
+			Usage: "name of the Dockerfile (default: context/Dockerfile)",
+		},
+		// 禁用构建缓存
+		&cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "do not use cache when building the image",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		contextDir := "."
+		if context.Args().Len() > 0 {
+			contextDir = context.Args().Get(0)
+		}
+		return dockerCommand.Build(contextDir, context.String("f"), context.String("t"), context.Bool("no-cache"))
+	},
+}
+
+// 访问镜像仓库的认证选项
+var registryFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "username",
+		Usage: "registry username",
+	},
+	&cli.StringFlag{
+		Name:  "password",
+		Usage: "registry password",
+	},
+	// 使用http访问镜像仓库
+	&cli.BoolFlag{
+		Name:  "insecure",
+		Usage: "access the registry over plain http",
+	},
+}
+
+// 从命令参数中得到访问镜像仓库的选项
+func registryOptions(context *cli.Context) registry.Options {
+	return registry.Options{
+		Username: context.String("username"),
+		Password: context.String("password"),
+		Insecure: context.Bool("insecure"),
+	}
+}
+
+// 从镜像仓库拉取镜像命令
+var pullCommand = cli.Command{
+	Name:  "pull",
+	Usage: "pull an image from a registry; pull [registry/]name[:tag|@digest]",
+	Flags: registryFlags,
+	Action: func(context *cli.Context) error {
+		if context.Args().Len() < 1 {
+			return fmt.Errorf("missing image name")
+		}
+		_, err := registry.Pull(context.Args().Get(0), registryOptions(context))
+		return err
+	},
+}
+
+// 推送镜像到镜像仓库命令
+var pushCommand = cli.Command{
+	Name:  "push",
+	Usage: "push an image to a registry; push [imageName] [registry/name:tag]",
+	Flags: registryFlags,
+	Action: func(context *cli.Context) error {
+		if context.Args().Len() < 1 {
+			return fmt.Errorf("missing image name")
+		}
+		return registry.Push(context.Args().Get(0), context.Args().Get(1), registryOptions(context))
+	},
+}
+
+// 导出镜像命令
+var saveCommand = cli.Command{
+	Name:  "save",
+	Usage: "save images to a tar archive; save -o [file.tar] [imageName...]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "o",
+			Usage: "write to a file, instead of STDOUT",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.Args().Len() < 1 {
+			return fmt.Errorf("missing image name")
+		}
+		return dockerCommand.SaveImages(context.Args().Slice(), context.String("o"))
+	},
+}
+
+// 查看镜像历史命令
+var historyCommand = cli.Command{
+	Name:  "history",
+	Usage: "show the history of an image; history [imageName]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "no-trunc",
+			Usage: "don't truncate output",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.Args().Len() < 1 {
+			return fmt.Errorf("missing image name")
+		}
+		return dockerCommand.ImageHistory(context.Args().Get(0), context.Bool("no-trunc"))
+	},
+}
+
+// 镜像管理命令
+var imageCommand = cli.Command{
+	Name:  "image",
+	Usage: "manage images",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "prune",
+			Usage: "remove unused images and unreferenced layers",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "all",
+					Aliases: []string{"a"},
+					Usage:   "remove all images not used by containers, not just untagged ones",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				return dockerCommand.PruneImages(context.Bool("all"))
+			},
+		},
+	},
+}
+
+// 容器管理命令
+var containerCommand = cli.Command{
+	Name:  "container",
+	Usage: "manage containers",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "prune",
+			Usage: "remove all exited containers and orphaned container directories",
+			Action: func(context *cli.Context) error {
+				return dockerCommand.PruneContainers()
+			},
+		},
+	},
+}
+
+// 系统管理命令
+var systemCommand = cli.Command{
+	Name:  "system",
+	Usage: "manage MiniDocker data",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "df",
+			Usage: "show disk usage of images, containers, volumes and logs",
+			Action: func(context *cli.Context) error {
+				return dockerCommand.SystemDf()
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "remove exited containers, unused images and unreferenced layers",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "all",
+					Aliases: []string{"a"},
+					Usage:   "remove all images not used by containers, not just untagged ones",
+				},
+				&cli.BoolFlag{
+					Name:  "volumes",
+					Usage: "also remove volumes not used by containers",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				return dockerCommand.SystemPrune(context.Bool("all"), context.Bool("volumes"))
+			},
+		},
+	},
+}
+
+// 数据卷管理命令
+var volumeCommand = cli.Command{
+	Name:  "volume",
+	Usage: "manage named volumes",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "create",
+			Usage: "create a volume; volume create [-d driver] [-o key=value] [--label key=value] [volumeName]",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "driver",
+					Aliases: []string{"d"},
+					Value:   "local",
+					Usage:   "volume driver, local or a plugin in /run/minidocker/plugins/ or /etc/minidocker/plugins/",
+				},
+				&cli.StringSliceFlag{
+					Name:    "opt",
+					Aliases: []string{"o"},
+					Usage:   "set driver specific options, use: -o key=value",
+				},
+				&cli.StringSliceFlag{
+					Name:  "label",
+					Usage: "set metadata on the volume, use: --label key=value",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				return dockerCommand.CreateVolume(context.Args().Get(0), context.String("driver"), context.StringSlice("opt"), context.StringSlice("label"))
+			},
+		},
+		{
+			Name:  "ls",
+			Usage: "list volumes",
+			Action: func(context *cli.Context) error {
+				return dockerCommand.ListVolumes()
+			},
+		},
+		{
+			Name:  "inspect",
+			Usage: "display detailed information of volumes; volume inspect [volumeName...]",
+			Action: func(context *cli.Context) error {
+				if context.Args().Len() < 1 {
+					return fmt.Errorf("missing volume name")
+				}
+				return dockerCommand.InspectVolumes(context.Args().Slice())
+			},
+		},
+		{
+			Name:  "rm",
+			Usage: "remove volumes not used by any container; volume rm [volumeName...]",
+			Action: func(context *cli.Context) error {
+				if context.Args().Len() < 1 {
+					return fmt.Errorf("missing volume name")
+				}
+				return dockerCommand.RemoveVolumes(context.Args().Slice())
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "remove all volumes not used by containers",
+			Action: func(context *cli.Context) error {
+				return dockerCommand.PruneVolumes()
+			},
+		},
+	},
+}
+
+// 镜像签名命令
+var trustCommand = cli.Command{
+	Name:  "trust",
+	Usage: "sign images and verify image signatures",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "key",
+			Usage: "generate a signing key pair; trust key [--type ed25519|ecdsa] [name]",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "type",
+					Value: "ed25519",
+					Usage: "key type, ed25519 or ecdsa",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if context.Args().Len() < 1 {
+					return fmt.Errorf("missing key name")
+				}
+				return dockerCommand.GenerateKey(context.String("type"), context.Args().Get(0))
+			},
+		},
+		{
+			Name:  "sign",
+			Usage: "sign an image with a local private key; trust sign --key [file.key] [imageName]",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "key",
+					Usage:    "private key file in PEM format",
+					Required: true,
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if context.Args().Len() < 1 {
+					return fmt.Errorf("missing image name")
+				}
+				return dockerCommand.SignImage(context.Args().Get(0), context.String("key"))
+			},
+		},
+		{
+			Name:  "verify",
+			Usage: "verify the signature of an image; trust verify [--key file.pub] [imageName]",
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:  "key",
+					Usage: "trusted public key file, defaults to the keys in the trust policy",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if context.Args().Len() < 1 {
+					return fmt.Errorf("missing image name")
+				}
+				return dockerCommand.VerifyImage(context.Args().Get(0), context.StringSlice("key"))
+			},
+		},
+	},
+}
+
+// 导入镜像命令
+var loadCommand = cli.Command{
+	Name:  "load",
+	Usage: "load images from a tar archive; load -i [file.tar]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "i",
+			Usage: "read from tar archive file, instead of STDIN",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return dockerCommand.LoadImages(context.String("i"))
+	},
+}
+
+// 导出容器文件系统命令
+var exportCommand = cli.Command{
+	Name:  "export",
+	Usage: "export a container's filesystem as a tar archive; export -o [file.tar] [containerName]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "o",
+			Usage: "write to a file, instead of STDOUT",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.Args().Len() < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return dockerCommand.ExportContainer(context.Args().Get(0), context.String("o"))
+	},
+}
+
+// 从文件系统 tar 包导入镜像命令
+var importCommand = cli.Command{
+	Name:  "import",
+	Usage: "import a filesystem tar archive as an image; import [file.tar|-] [imageName[:tag]]",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "change",
+			Usage: "apply Dockerfile instruction to the image, use: --change 'CMD [\"/bin/sh\"]'",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.Args().Len() < 1 {
+			return fmt.Errorf("missing tar archive, use: import [file.tar|-] [imageName[:tag]]")
+		}
+		return dockerCommand.ImportImage(context.Args().Get(0), context.Args().Get(1), context.StringSlice("change"))
 	},
 }
 
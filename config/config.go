@@ -0,0 +1,90 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// DefaultConfigFile 全局配置文件
+	DefaultConfigFile = "/etc/minidocker/config.json"
+	// DefaultRoot 默认的数据根目录，存放镜像、数据卷和容器的可写层
+	DefaultRoot = "/root"
+	// DefaultExecRoot 默认的运行时目录，存放容器信息、日志和网络配置
+	DefaultExecRoot = "/var/run/minidocker"
+)
+
+// Config 全局配置，字段名与 docker 的 daemon.json 一致
+type Config struct {
+	Root          string `json:"data-root,omitempty"`
+	ExecRoot      string `json:"exec-root,omitempty"`
+	StorageDriver string `json:"storage-driver,omitempty"` // 新建容器使用的存储驱动, 为空时自动选择
+	UsernsRemap   string `json:"userns-remap,omitempty"`   // 新建容器默认的用户命名空间映射, 为空时不使用用户命名空间
+}
+
+// Defaults 默认的配置文件和配置，root 用户使用 DefaultConfigFile、DefaultRoot 和 DefaultExecRoot
+func Defaults() (string, Config) {
+	return DefaultConfigFile, Config{Root: DefaultRoot, ExecRoot: DefaultExecRoot}
+}
+
+/*
+RootlessDefaults rootless 模式下默认的配置文件和配置，遵循 XDG 规范，uid 为宿主机上的用户 id
+1.配置文件为 $XDG_CONFIG_HOME/minidocker/config.json，默认为 ~/.config/minidocker/config.json
+2.数据根目录为 $XDG_DATA_HOME/minidocker，默认为 ~/.local/share/minidocker
+3.运行时目录为 $XDG_RUNTIME_DIR/minidocker，未设置时为 /tmp/minidocker-<uid>
+*/
+func RootlessDefaults(uid int) (string, Config) {
+	home := os.Getenv("HOME")
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(home, ".config")
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	execRoot := filepath.Join(os.TempDir(), fmt.Sprintf("minidocker-%d", uid))
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		execRoot = filepath.Join(runtimeDir, "minidocker")
+	}
+	return filepath.Join(configHome, "minidocker", "config.json"), Config{
+		Root:     filepath.Join(dataHome, "minidocker"),
+		ExecRoot: execRoot,
+	}
+}
+
+/*
+Load 读取配置文件，未配置的项使用 defaults 中的值
+required 为 false 时配置文件不存在不报错(默认的配置文件是可选的)
+*/
+func Load(path string, required bool, defaults Config) (*Config, error) {
+	c := &Config{}
+	content, err := os.ReadFile(path)
+	if err != nil && (required || !os.IsNotExist(err)) {
+		return nil, fmt.Errorf("read config file %s fails: %v", path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, c); err != nil {
+			return nil, fmt.Errorf("unmarshal config file %s fails: %v", path, err)
+		}
+	}
+	if c.Root == "" {
+		c.Root = defaults.Root
+	}
+	if c.ExecRoot == "" {
+		c.ExecRoot = defaults.ExecRoot
+	}
+	return c, nil
+}
+
+// Validate 检查配置项, 目录必须是绝对路径
+func (c *Config) Validate() error {
+	for name, dir := range map[string]string{"data-root": c.Root, "exec-root": c.ExecRoot} {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("%s %q must be an absolute path", name, dir)
+		}
+	}
+	return nil
+}
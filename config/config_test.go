@@ -0,0 +1,51 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.json")
+	_, defaults := Defaults()
+	c, err := Load(missing, false, defaults)
+	if err != nil || c.Root != DefaultRoot || c.ExecRoot != DefaultExecRoot {
+		t.Fatalf("load missing optional config: got %+v, %v", c, err)
+	}
+	if _, err := Load(missing, true, defaults); err == nil {
+		t.Errorf("expect error for missing required config")
+	}
+
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"data-root": "/data/minidocker"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err = Load(path, true, defaults)
+	if err != nil || c.Root != "/data/minidocker" || c.ExecRoot != DefaultExecRoot {
+		t.Fatalf("load config: got %+v, %v", c, err)
+	}
+
+	c.ExecRoot = "run/minidocker"
+	if err := c.Validate(); err == nil {
+		t.Errorf("expect error for relative exec-root")
+	}
+}
+
+func TestRootlessDefaults(t *testing.T) {
+	t.Setenv("HOME", "/home/alice")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	file, c := RootlessDefaults(1000)
+	if file != "/home/alice/.config/minidocker/config.json" || c.Root != "/home/alice/.local/share/minidocker" || c.ExecRoot != "/run/user/1000/minidocker" {
+		t.Errorf("got %s, %+v", file, c)
+	}
+
+	t.Setenv("XDG_DATA_HOME", "/data")
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	if _, c := RootlessDefaults(1000); c.Root != "/data/minidocker" || c.ExecRoot != filepath.Join(os.TempDir(), "minidocker-1000") {
+		t.Errorf("got %+v", c)
+	}
+}
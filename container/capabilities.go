@@ -0,0 +1,219 @@
+package container
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultCapabilities 容器进程默认保留的能力，与 docker 一致
+var DefaultCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// 能力名对应的编号
+var capabilities = map[string]int{
+	"CAP_CHOWN":              unix.CAP_CHOWN,
+	"CAP_DAC_OVERRIDE":       unix.CAP_DAC_OVERRIDE,
+	"CAP_DAC_READ_SEARCH":    unix.CAP_DAC_READ_SEARCH,
+	"CAP_FOWNER":             unix.CAP_FOWNER,
+	"CAP_FSETID":             unix.CAP_FSETID,
+	"CAP_KILL":               unix.CAP_KILL,
+	"CAP_SETGID":             unix.CAP_SETGID,
+	"CAP_SETUID":             unix.CAP_SETUID,
+	"CAP_SETPCAP":            unix.CAP_SETPCAP,
+	"CAP_LINUX_IMMUTABLE":    unix.CAP_LINUX_IMMUTABLE,
+	"CAP_NET_BIND_SERVICE":   unix.CAP_NET_BIND_SERVICE,
+	"CAP_NET_BROADCAST":      unix.CAP_NET_BROADCAST,
+	"CAP_NET_ADMIN":          unix.CAP_NET_ADMIN,
+	"CAP_NET_RAW":            unix.CAP_NET_RAW,
+	"CAP_IPC_LOCK":           unix.CAP_IPC_LOCK,
+	"CAP_IPC_OWNER":          unix.CAP_IPC_OWNER,
+	"CAP_SYS_MODULE":         unix.CAP_SYS_MODULE,
+	"CAP_SYS_RAWIO":          unix.CAP_SYS_RAWIO,
+	"CAP_SYS_CHROOT":         unix.CAP_SYS_CHROOT,
+	"CAP_SYS_PTRACE":         unix.CAP_SYS_PTRACE,
+	"CAP_SYS_PACCT":          unix.CAP_SYS_PACCT,
+	"CAP_SYS_ADMIN":          unix.CAP_SYS_ADMIN,
+	"CAP_SYS_BOOT":           unix.CAP_SYS_BOOT,
+	"CAP_SYS_NICE":           unix.CAP_SYS_NICE,
+	"CAP_SYS_RESOURCE":       unix.CAP_SYS_RESOURCE,
+	"CAP_SYS_TIME":           unix.CAP_SYS_TIME,
+	"CAP_SYS_TTY_CONFIG":     unix.CAP_SYS_TTY_CONFIG,
+	"CAP_MKNOD":              unix.CAP_MKNOD,
+	"CAP_LEASE":              unix.CAP_LEASE,
+	"CAP_AUDIT_WRITE":        unix.CAP_AUDIT_WRITE,
+	"CAP_AUDIT_CONTROL":      unix.CAP_AUDIT_CONTROL,
+	"CAP_SETFCAP":            unix.CAP_SETFCAP,
+	"CAP_MAC_OVERRIDE":       unix.CAP_MAC_OVERRIDE,
+	"CAP_MAC_ADMIN":          unix.CAP_MAC_ADMIN,
+	"CAP_SYSLOG":             unix.CAP_SYSLOG,
+	"CAP_WAKE_ALARM":         unix.CAP_WAKE_ALARM,
+	"CAP_BLOCK_SUSPEND":      unix.CAP_BLOCK_SUSPEND,
+	"CAP_AUDIT_READ":         unix.CAP_AUDIT_READ,
+	"CAP_PERFMON":            unix.CAP_PERFMON,
+	"CAP_BPF":                unix.CAP_BPF,
+	"CAP_CHECKPOINT_RESTORE": unix.CAP_CHECKPOINT_RESTORE,
+}
+
+// AllCapabilities 当前内核支持的所有能力，按编号排序
+func AllCapabilities() []string {
+	last := lastCap()
+	var all []string
+	for name, c := range capabilities {
+		if c <= last {
+			all = append(all, name)
+		}
+	}
+	sortCapabilities(all)
+	return all
+}
+
+// 能力名不区分大小写，可以省略 CAP_ 前缀
+func normalizeCapability(name string) (string, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if name == "ALL" {
+		return name, nil
+	}
+	if !strings.HasPrefix(name, "CAP_") {
+		name = "CAP_" + name
+	}
+	if _, ok := capabilities[name]; !ok {
+		return "", fmt.Errorf("unknown capability %q", name)
+	}
+	return name, nil
+}
+
+func normalizeCapabilities(names []string) ([]string, error) {
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		c, err := normalizeCapability(name)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+/*
+ParseCapabilities 得到容器进程的能力集合，与 docker 的规则一致
+1.privileged 或 --cap-add ALL 时拥有所有能力
+2.--cap-drop ALL 时从空集合开始，否则从 DefaultCapabilities 中去除 --cap-drop 的能力
+3.再加入 --cap-add 的能力，同时出现在 --cap-add 和 --cap-drop 中的能力保留
+*/
+func ParseCapabilities(add, drop []string, privileged bool) ([]string, error) {
+	add, err := normalizeCapabilities(add)
+	if err != nil {
+		return nil, err
+	}
+	drop, err = normalizeCapabilities(drop)
+	if err != nil {
+		return nil, err
+	}
+	if privileged || contains(add, "ALL") {
+		return AllCapabilities(), nil
+	}
+	result := []string{}
+	if !contains(drop, "ALL") {
+		for _, c := range DefaultCapabilities {
+			if !contains(drop, c) {
+				result = append(result, c)
+			}
+		}
+	}
+	for _, c := range add {
+		if !contains(result, c) {
+			result = append(result, c)
+		}
+	}
+	sortCapabilities(result)
+	return result, nil
+}
+
+func sortCapabilities(names []string) {
+	sort.Slice(names, func(i, j int) bool {
+		return capabilities[names[i]] < capabilities[names[j]]
+	})
+}
+
+// 内核支持的最大能力编号
+func lastCap() int {
+	content, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+	return last
+}
+
+// 能力集合对应的位图, 未识别的能力名忽略
+func capabilityMask(names []string) uint64 {
+	var mask uint64
+	for _, name := range names {
+		if c, ok := capabilities[name]; ok {
+			mask |= 1 << uint(c)
+		}
+	}
+	return mask
+}
+
+/*
+dropBoundingSet 从 bounding 集合中去除不在 mask 中的能力，之后 execve 得到的能力不会超出该集合
+需要 CAP_SETPCAP，因此要在切换到非 root 用户之前进行
+*/
+func dropBoundingSet(mask uint64) error {
+	for c := 0; c <= lastCap(); c++ {
+		if mask&(1<<uint(c)) != 0 {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil {
+			return fmt.Errorf("drop capability %d from bounding set fails: %v", c, err)
+		}
+	}
+	return nil
+}
+
+/*
+setCapabilities 设置当前进程的 effective、permitted、inheritable 和 ambient 集合，在切换用户之后进行
+1.effective、permitted 只保留 mask 中的能力，切换到非 root 用户后已为空
+2.与 docker 一致，inheritable 和 ambient 为空，非 root 用户执行命令后不具有任何能力，root 用户的能力由 bounding 集合决定
+*/
+func setCapabilities(mask uint64) error {
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil && err != unix.EINVAL {
+		return fmt.Errorf("clear ambient capabilities fails: %v", err)
+	}
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&header, &data[0]); err != nil {
+		return fmt.Errorf("get capabilities fails: %v", err)
+	}
+	for i := range data {
+		data[i].Permitted &= uint32(mask >> (32 * uint(i)))
+		data[i].Effective = data[i].Permitted
+		data[i].Inheritable = 0
+	}
+	if err := unix.Capset(&header, &data[0]); err != nil {
+		return fmt.Errorf("set capabilities fails: %v", err)
+	}
+	return nil
+}
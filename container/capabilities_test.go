@@ -0,0 +1,43 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	tests := []struct {
+		add, drop  []string
+		privileged bool
+		want       []string
+	}{
+		{nil, nil, false, []string{"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_FOWNER", "CAP_FSETID", "CAP_KILL", "CAP_SETGID", "CAP_SETUID", "CAP_SETPCAP", "CAP_NET_BIND_SERVICE", "CAP_NET_RAW", "CAP_SYS_CHROOT", "CAP_MKNOD", "CAP_AUDIT_WRITE", "CAP_SETFCAP"}},
+		{[]string{"net_admin"}, []string{"ALL"}, false, []string{"CAP_NET_ADMIN"}},
+		{[]string{"CAP_SYS_TIME"}, []string{"chown", "NET_RAW", "mknod", "setfcap", "setpcap", "audit_write", "kill", "sys_chroot", "net_bind_service", "setuid", "setgid", "fsetid", "fowner"}, false, []string{"CAP_DAC_OVERRIDE", "CAP_SYS_TIME"}},
+		{[]string{"kill"}, []string{"kill", "all"}, false, []string{"CAP_KILL"}},
+		{nil, []string{"ALL"}, false, []string{}},
+	}
+	for _, test := range tests {
+		got, err := ParseCapabilities(test.add, test.drop, test.privileged)
+		if err != nil {
+			t.Errorf("add %v drop %v: %v", test.add, test.drop, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("add %v drop %v: got %v, want %v", test.add, test.drop, got, test.want)
+		}
+	}
+
+	all := AllCapabilities()
+	for _, args := range [][2][]string{{{"ALL"}, nil}, {nil, {"ALL"}}} {
+		privileged := args[0] == nil
+		got, err := ParseCapabilities(args[0], args[1], privileged)
+		if err != nil || !reflect.DeepEqual(got, all) {
+			t.Errorf("add %v privileged %v: got %v, %v, want all capabilities", args[0], privileged, got, err)
+		}
+	}
+
+	if _, err := ParseCapabilities([]string{"bogus"}, nil, false); err == nil {
+		t.Errorf("unknown capability: expected error")
+	}
+}
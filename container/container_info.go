@@ -22,37 +22,60 @@ var (
 	MntUrl              = "/root/mnt/%s"
 	WriteLayerUrl       = "/root/writeLayer/%s"
 	WorkLayerUrl        = "/root/.tmpWork/%s"
+	StorageUrl          = "/root/.storage/%s" // 限制了大小的可写层所在的 ext4 镜像文件
 )
 
+// SetRoot 设置数据根目录 root 和运行时目录 execRoot，需在创建或读取容器之前调用
+// root 下存放挂载点、可写层和 overlay 工作目录，execRoot 下存放容器信息和日志
+func SetRoot(root, execRoot string) {
+	RootUrl = filepath.Clean(root)
+	MntUrl = filepath.Join(root, "mnt", "%s")
+	WriteLayerUrl = filepath.Join(root, "writeLayer", "%s")
+	WorkLayerUrl = filepath.Join(root, ".tmpWork", "%s")
+	StorageUrl = filepath.Join(root, ".storage", "%s")
+	DefaultInfoLocation = filepath.Join(execRoot, "%s") + "/"
+}
+
 // ContainerInfo 容器的基本信息, 默认存储在'/var/run/minidocker/${containerName}/config.json'
 type ContainerInfo struct {
-	Pid         string   `json:"pid"`          // 容器的init进程在主机上的pid
-	Id          string   `json:"id"`           // 容器ID
-	Name        string   `json:"name"`         // 容器名
-	Command     string   `json:"command"`      // 容器内init进程运行的命令
-	CreatedTime string   `json:"createdTime"`  // 创建时间
-	Status      string   `json:"status"`       // 容器状态
-	Volume      string   `json:"volume"`       // 挂载数据卷
-	PortMapping []string `json:"port_mapping"` // 端口映射
+	Pid           string      `json:"pid"`                     // 容器的init进程在主机上的pid
+	Id            string      `json:"id"`                      // 容器ID
+	Name          string      `json:"name"`                    // 容器名
+	Command       string      `json:"command"`                 // 容器内init进程运行的命令
+	CreatedTime   string      `json:"createdTime"`             // 创建时间
+	Status        string      `json:"status"`                  // 容器状态
+	Image         string      `json:"image"`                   // 创建容器时指定的镜像名，用于显示
+	ImageID       string      `json:"imageId,omitempty"`       // 本地镜像仓库中镜像的ID, 为空时为/root/下的tar镜像或之前版本记录的容器
+	Mounts        []Mount     `json:"mounts"`                  // 挂载的数据卷
+	PortMapping   []string    `json:"port_mapping"`            // 端口映射
+	StorageDriver string      `json:"storageDriver,omitempty"` // 创建容器时使用的存储驱动, 为空时为 overlay
+	IDMappings    *IDMappings `json:"idMappings,omitempty"`    // 用户命名空间的 uid、gid 映射, 为空时不使用用户命名空间
+	Capabilities  []string    `json:"capabilities,omitempty"`  // 容器进程保留的能力
 }
 
-// RecordContainerInfo 记录容器信息
+// RecordContainerInfo 记录容器信息, imageID 为创建容器时镜像名解析得到的镜像ID，镜像名之后被重新指向其他镜像也不受影响
+// idMappings 为容器使用的用户命名空间映射, capabilities 为容器进程保留的能力
 // @return 容器名 或 错误信息
-func RecordContainerInfo(containerPID int, containerCmd []string, containerName string, containerID string, volume string) (string, error) {
+func RecordContainerInfo(containerPID int, containerCmd []string, containerName string, containerID string, mounts []Mount, imageName, imageID string, idMappings *IDMappings, capabilities []string) (string, error) {
 
 	// 记录当前容器创建时间和初始命令
 	createTime := time.Now().Format("2006-01-02 15:04:05")
-	command := strings.Join(containerCmd, "")
+	command := strings.Join(containerCmd, " ")
 
 	// 生成容器信息结构体实例
 	containerInfo := &ContainerInfo{
-		Pid:         strconv.Itoa(containerPID),
-		Id:          containerID,
-		Name:        containerName,
-		Command:     command,
-		CreatedTime: createTime,
-		Status:      RUNNING,
-		Volume:      volume,
+		Pid:           strconv.Itoa(containerPID),
+		Id:            containerID,
+		Name:          containerName,
+		Command:       command,
+		CreatedTime:   createTime,
+		Status:        RUNNING,
+		Image:         imageName,
+		ImageID:       imageID,
+		Mounts:        mounts,
+		StorageDriver: StorageDriver, // 与 NewWorkSpace 使用的存储驱动一致
+		IDMappings:    idMappings,
+		Capabilities:  capabilities,
 	}
 	// 将容器信息转为json字符串
 	jsonByte, err := json.Marshal(containerInfo)
@@ -64,7 +87,7 @@ func RecordContainerInfo(containerPID int, containerCmd []string, containerName
 
 	// 拼凑存储容器信息的路径，并确保存在
 	dirUrl := fmt.Sprintf(DefaultInfoLocation, containerName)
-	if err := os.MkdirAll(dirUrl, 0622); err != nil {
+	if err := os.MkdirAll(dirUrl, 0755); err != nil {
 		logrus.Errorf("Mkdir dir: %v fails: %v", dirUrl, err)
 		return "", err
 	}
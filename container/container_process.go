@@ -2,20 +2,47 @@ package container
 
 import (
 	"fmt"
-	"github.com/sirupsen/logrus"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"syscall"
 )
 
+// InitConfig 父进程通过管道传递给容器 init 进程的启动配置
+type InitConfig struct {
+	Cmd          []string `json:"cmd"`          // 容器内执行的命令
+	WorkDir      string   `json:"workDir"`      // 命令执行的工作目录
+	User         string   `json:"user"`         // 执行命令的用户, 格式为 user[:group]
+	Mounts       []Mount  `json:"mounts"`       // 绑定挂载到容器内的数据卷
+	ReadOnly     bool     `json:"readOnly"`     // 以只读方式挂载容器的根目录
+	ShmSize      string   `json:"shmSize"`      // /dev/shm 的大小, 为空时为 64m
+	Devices      []Device `json:"devices"`      // --device 指定的设备
+	Hostname     string   `json:"hostname"`     // 容器的主机名
+	Capabilities []string `json:"capabilities"` // 容器进程保留的能力, 为 nil 时使用 DefaultCapabilities
+}
+
 // NewProcess 创建新容器进程并设置好隔离, 使用管道来传递多个命令行参数,read端传给容器进程，write端保留在父进程
-func NewProcess(tty bool, volume string, containerName string, imageName string, envSlice []string) (*exec.Cmd, *os.File) {
+// imageID 为本地镜像仓库中的镜像ID，为空时使用/root/下的tar镜像 imageName
+// storageSize 大于 0 时限制容器可写层的大小, idMappings 不为空时在新的用户命名空间中运行容器
+// hostNetwork 为 true 时容器使用宿主机的网络命名空间
+func NewProcess(tty bool, mounts []Mount, containerName, imageName, imageID string, envSlice []string, storageSize int64, idMappings *IDMappings, hostNetwork bool) (*exec.Cmd, *os.File, error) {
 	//args := []string{"init", containerCmd}
 	readPipe, writePipe, err := os.Pipe()
 	if err != nil {
-		logrus.Errorf("new pipe error: %v", err)
-		return nil, nil
+		return nil, nil, fmt.Errorf("new pipe error: %v", err)
+	}
+	// 出错时关闭管道，删除创建的日志文件，容器目录为空时一并删除
+	var stdLogFile *os.File
+	fail := func(err error) (*exec.Cmd, *os.File, error) {
+		readPipe.Close()
+		writePipe.Close()
+		if stdLogFile != nil {
+			stdLogFile.Close()
+			os.Remove(stdLogFile.Name())
+			os.Remove(filepath.Dir(stdLogFile.Name()))
+		}
+		return nil, nil, err
 	}
 
 	cmd := exec.Command("/proc/self/exe", "init")
@@ -25,6 +52,20 @@ func NewProcess(tty bool, volume string, containerName string, imageName string,
 		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWPID |
 			syscall.CLONE_NEWNS | syscall.CLONE_NEWNET,
 	}
+	if hostNetwork {
+		cmd.SysProcAttr.Cloneflags &^= syscall.CLONE_NEWNET
+	}
+	// 用户命名空间: 容器内的 root 映射为宿主机上的从属 id，init 进程以映射后的 root 身份运行
+	if idMappings != nil {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = sysProcIDMaps(idMappings.UIDs)
+		cmd.SysProcAttr.GidMappings = sysProcIDMaps(idMappings.GIDs)
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = true
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+		if err := makeSearchable(containerName); err != nil {
+			return fail(fmt.Errorf("make dirs of container %v searchable fails: %v", containerName, err))
+		}
+	}
 
 	// 判断是否要新建终端，否则将日志重定向至'/var/run/minidocker/${containerName}/container.log'
 	if tty {
@@ -34,15 +75,12 @@ func NewProcess(tty bool, volume string, containerName string, imageName string,
 	} else {
 		// 后台容器需将日志重定向
 		logdir := fmt.Sprintf(DefaultInfoLocation, containerName)
-		if err := os.MkdirAll(logdir, 0622); err != nil {
-			logrus.Errorf("mkdir log dir: %v fails: %v", logdir, err)
-			return nil, nil
+		if err := os.MkdirAll(logdir, 0755); err != nil {
+			return fail(fmt.Errorf("mkdir log dir: %v fails: %v", logdir, err))
 		}
 		stdLogFilePath := filepath.Join(logdir, ContainerLogFile)
-		stdLogFile, err := os.Create(stdLogFilePath)
-		if err != nil {
-			logrus.Errorf("create file %v fails: %v", stdLogFilePath, err)
-			return nil, nil
+		if stdLogFile, err = os.Create(stdLogFilePath); err != nil {
+			return fail(fmt.Errorf("create file %v fails: %v", stdLogFilePath, err))
 		}
 		cmd.Stdout = stdLogFile
 	}
@@ -50,10 +88,32 @@ func NewProcess(tty bool, volume string, containerName string, imageName string,
 	// 传递Pipe
 	cmd.ExtraFiles = []*os.File{readPipe}
 	// 传递环境变量
-	cmd.Env = append(os.Environ(), envSlice...)
+	cmd.Env = mergeEnv(os.Environ(), envSlice)
 
-	NewWorkSpace(imageName, containerName, volume)
+	if err := NewWorkSpace(imageID, imageName, containerName, mounts, storageSize, idMappings); err != nil {
+		return fail(fmt.Errorf("create workspace of container %v fails: %v", containerName, err))
+	}
 	cmd.Dir = fmt.Sprintf(MntUrl, containerName)
 
-	return cmd, writePipe
+	return cmd, writePipe, nil
+}
+
+// 合并环境变量，后面的同名变量覆盖前面的
+func mergeEnv(base []string, envs []string) []string {
+	merged := append([]string{}, base...)
+	for _, env := range envs {
+		key := strings.SplitN(env, "=", 2)[0]
+		replaced := false
+		for i := range merged {
+			if strings.SplitN(merged[i], "=", 2)[0] == key {
+				merged[i] = env
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, env)
+		}
+	}
+	return merged
 }
@@ -0,0 +1,240 @@
+package container
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// DefaultShmSize /dev/shm 的默认大小，与 docker 一致
+const DefaultShmSize = "64m"
+
+// tmpfs size 选项的格式, 字节数或带 k/m/g 单位
+var shmSizeRegexp = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+
+// Device 容器内的设备节点, 由 --device 指定或默认创建
+type Device struct {
+	PathOnHost      string      `json:"pathOnHost"`      // 宿主机上的设备路径
+	PathInContainer string      `json:"pathInContainer"` // 容器内的设备路径
+	Type            string      `json:"type"`            // 设备类型, c 字符设备, b 块设备
+	Major           int64       `json:"major"`           // 主设备号
+	Minor           int64       `json:"minor"`           // 次设备号
+	FileMode        os.FileMode `json:"fileMode"`        // 设备节点的权限
+	Uid             uint32      `json:"uid"`             // 设备节点的属主
+	Gid             uint32      `json:"gid"`             // 设备节点的属组
+	Permissions     string      `json:"permissions"`     // cgroup 访问权限, r 读, w 写, m 创建设备节点
+}
+
+// 容器内默认创建的设备节点，与 runc 一致
+var defaultDevices = []Device{
+	{PathOnHost: "/dev/null", PathInContainer: "/dev/null", Type: "c", Major: 1, Minor: 3, FileMode: 0666},
+	{PathOnHost: "/dev/zero", PathInContainer: "/dev/zero", Type: "c", Major: 1, Minor: 5, FileMode: 0666},
+	{PathOnHost: "/dev/full", PathInContainer: "/dev/full", Type: "c", Major: 1, Minor: 7, FileMode: 0666},
+	{PathOnHost: "/dev/random", PathInContainer: "/dev/random", Type: "c", Major: 1, Minor: 8, FileMode: 0666},
+	{PathOnHost: "/dev/urandom", PathInContainer: "/dev/urandom", Type: "c", Major: 1, Minor: 9, FileMode: 0666},
+	{PathOnHost: "/dev/tty", PathInContainer: "/dev/tty", Type: "c", Major: 5, Minor: 0, FileMode: 0666},
+}
+
+// /dev 下默认的符号链接
+var defaultDevSymlinks = [][2]string{
+	{"/proc/self/fd", "/dev/fd"},
+	{"/proc/self/fd/0", "/dev/stdin"},
+	{"/proc/self/fd/1", "/dev/stdout"},
+	{"/proc/self/fd/2", "/dev/stderr"},
+	{"/proc/kcore", "/dev/core"},
+	{"pts/ptmx", "/dev/ptmx"},
+}
+
+// ValidShmSize 检查 --shm-size 的格式
+func ValidShmSize(size string) bool {
+	return shmSizeRegexp.MatchString(size)
+}
+
+/*
+ParseDevice 解析 --device 参数, 格式为 hostPath[:containerPath][:permissions]
+1.containerPath 缺省时与 hostPath 相同, permissions 缺省时为 rwm
+2.读取宿主机上设备的类型、设备号、权限和属主，只能是字符设备或块设备
+*/
+func ParseDevice(spec string) (Device, error) {
+	parts := strings.Split(spec, ":")
+	d := Device{PathOnHost: parts[0], Permissions: "rwm"}
+	switch len(parts) {
+	case 1:
+	case 2:
+		// 第二段是合法的权限时视为 hostPath:permissions
+		if validDevicePermissions(parts[1]) {
+			d.Permissions = parts[1]
+		} else {
+			d.PathInContainer = parts[1]
+		}
+	case 3:
+		d.PathInContainer, d.Permissions = parts[1], parts[2]
+	default:
+		return d, fmt.Errorf("invalid device specification %q, use: --device hostPath[:containerPath][:rwm]", spec)
+	}
+	if d.PathInContainer == "" {
+		d.PathInContainer = d.PathOnHost
+	}
+	if !filepath.IsAbs(d.PathOnHost) || !filepath.IsAbs(d.PathInContainer) {
+		return d, fmt.Errorf("invalid device %q: path must be absolute", spec)
+	}
+	if !validDevicePermissions(d.Permissions) {
+		return d, fmt.Errorf("invalid device permissions %q, use a combination of r, w and m", d.Permissions)
+	}
+	d.PathInContainer = filepath.Clean(d.PathInContainer)
+
+	var stat unix.Stat_t
+	if err := unix.Stat(d.PathOnHost, &stat); err != nil {
+		return d, fmt.Errorf("stat device %s fails: %v", d.PathOnHost, err)
+	}
+	switch stat.Mode & unix.S_IFMT {
+	case unix.S_IFCHR:
+		d.Type = "c"
+	case unix.S_IFBLK:
+		d.Type = "b"
+	default:
+		return d, fmt.Errorf("%s is not a device", d.PathOnHost)
+	}
+	d.Major = int64(unix.Major(stat.Rdev))
+	d.Minor = int64(unix.Minor(stat.Rdev))
+	d.FileMode = os.FileMode(stat.Mode & 07777)
+	d.Uid, d.Gid = stat.Uid, stat.Gid
+	return d, nil
+}
+
+// ParseDevices 解析所有 --device 参数
+func ParseDevices(specs []string) ([]Device, error) {
+	var devices []Device
+	for _, spec := range specs {
+		d, err := ParseDevice(spec)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+// 权限由 r、w、m 组成且不能重复
+func validDevicePermissions(permissions string) bool {
+	if permissions == "" || len(permissions) > 3 {
+		return false
+	}
+	for i, c := range permissions {
+		if !strings.ContainsRune("rwm", c) || strings.ContainsRune(permissions[i+1:], c) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+setUpDev 在 rootfs/dev 上挂载 tmpfs 并填充标准设备，需在 pivot_root 之前进行，此时可以绑定挂载宿主机的设备
+1.创建 null、zero、full、random、urandom、tty 以及 --device 指定的设备节点，没有 mknod 权限时绑定挂载宿主机的设备
+2.创建 fd、stdin、stdout、stderr、ptmx 等符号链接
+3.挂载独立的 devpts 实例，容器内的伪终端与宿主机隔离
+4.挂载 /dev/shm 和 /dev/mqueue，IPC 命名空间隔离了 POSIX 消息队列
+*/
+func setUpDev(rootfs, shmSize string, devices []Device) error {
+	dev := filepath.Join(rootfs, "dev")
+	if err := os.MkdirAll(dev, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", dev, "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755,size=65536k"); err != nil {
+		return fmt.Errorf("mount /dev fails: %v", err)
+	}
+	// 创建设备时不受 umask 影响
+	oldMask := syscall.Umask(0)
+	defer syscall.Umask(oldMask)
+	for _, d := range append(append([]Device{}, defaultDevices...), devices...) {
+		if err := createDevice(rootfs, d); err != nil {
+			return err
+		}
+	}
+	for _, link := range defaultDevSymlinks {
+		if err := os.Symlink(link[0], filepath.Join(rootfs, link[1])); err != nil {
+			return fmt.Errorf("symlink %s fails: %v", link[1], err)
+		}
+	}
+
+	// devpts: newinstance 使容器拥有独立的 pts 编号空间，gid=5 为 tty 组
+	pts := filepath.Join(dev, "pts")
+	if err := os.Mkdir(pts, 0755); err != nil {
+		return err
+	}
+	// 用户命名空间中 tty 组可能没有映射(如 rootless 模式只映射了当前用户)，此时不指定 gid
+	ptsOptions := "newinstance,ptmxmode=0666,mode=0620"
+	if gidMapped(5) {
+		ptsOptions += ",gid=5"
+	}
+	if err := syscall.Mount("devpts", pts, "devpts", syscall.MS_NOSUID|syscall.MS_NOEXEC, ptsOptions); err != nil {
+		return fmt.Errorf("mount /dev/pts fails: %v", err)
+	}
+
+	if shmSize == "" {
+		shmSize = DefaultShmSize
+	}
+	shm := filepath.Join(dev, "shm")
+	if err := os.Mkdir(shm, 01777); err != nil {
+		return err
+	}
+	if err := syscall.Mount("shm", shm, "tmpfs", defaultTmpfsFlags, "mode=1777,size="+shmSize); err != nil {
+		return fmt.Errorf("mount /dev/shm fails: %v", err)
+	}
+
+	mqueue := filepath.Join(dev, "mqueue")
+	if err := os.Mkdir(mqueue, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("mqueue", mqueue, "mqueue", defaultTmpfsFlags, ""); err != nil {
+		return fmt.Errorf("mount /dev/mqueue fails: %v", err)
+	}
+	return nil
+}
+
+// 判断 gid 在当前进程的用户命名空间中是否有映射，读取不到 gid_map 时视为有映射
+func gidMapped(gid int64) bool {
+	content, err := os.ReadFile("/proc/self/gid_map")
+	if err != nil {
+		return true
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		var inside, outside, count int64
+		if n, _ := fmt.Sscan(line, &inside, &outside, &count); n == 3 && gid >= inside && gid < inside+count {
+			return true
+		}
+	}
+	return false
+}
+
+// 在 rootfs 中创建设备节点，mknod 失败(如没有 CAP_MKNOD 或位于 nodev 的文件系统)时绑定挂载宿主机上的设备
+func createDevice(rootfs string, d Device) error {
+	target, err := SecureJoin(rootfs, d.PathInContainer)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	fileType := uint32(unix.S_IFCHR)
+	if d.Type == "b" {
+		fileType = unix.S_IFBLK
+	}
+	err = unix.Mknod(target, fileType|uint32(d.FileMode), int(unix.Mkdev(uint32(d.Major), uint32(d.Minor))))
+	if err == nil {
+		return os.Lchown(target, int(d.Uid), int(d.Gid))
+	}
+	logrus.Infof("mknod %s fails: %v, bind mount from host", d.PathInContainer, err)
+	if err := createMountTarget(target, false); err != nil {
+		return err
+	}
+	if err := syscall.Mount(d.PathOnHost, target, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind mount device %s fails: %v", d.PathOnHost, err)
+	}
+	return nil
+}
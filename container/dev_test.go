@@ -0,0 +1,30 @@
+package container
+
+import "testing"
+
+func TestParseDevice(t *testing.T) {
+	for _, tt := range []struct {
+		spec            string
+		pathInContainer string
+		permissions     string
+	}{
+		{"/dev/null", "/dev/null", "rwm"},
+		{"/dev/null:r", "/dev/null", "r"},
+		{"/dev/null:/dev/sink", "/dev/sink", "rwm"},
+		{"/dev/null:/dev/sink/../void:rw", "/dev/void", "rw"},
+	} {
+		d, err := ParseDevice(tt.spec)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.spec, err)
+		}
+		if d.PathOnHost != "/dev/null" || d.PathInContainer != tt.pathInContainer || d.Permissions != tt.permissions ||
+			d.Type != "c" || d.Major != 1 || d.Minor != 3 {
+			t.Errorf("parse %q: got %+v", tt.spec, d)
+		}
+	}
+	for _, spec := range []string{"/dev/null:/dev/x:rwx", "/dev/null:/dev/x:rr", "dev/null", "/dev/null:a:b:c", "/"} {
+		if _, err := ParseDevice(spec); err == nil {
+			t.Errorf("expect error for %q", spec)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// 容器目录中生成的文件，绑定挂载到容器的 /etc 下
+const (
+	HostsFile      = "hosts"
+	HostnameFile   = "hostname"
+	ResolvConfFile = "resolv.conf"
+)
+
+// 宿主机的 resolv.conf
+var hostResolvConf = "/etc/resolv.conf"
+
+// 没有可用的 DNS 服务器时使用的默认值，与 docker 一致
+var defaultDNS = []string{"8.8.8.8", "8.8.4.4"}
+
+// 主机名由字母、数字和 - 组成的标签以 . 连接
+var hostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+// EtcConfig 容器的 hosts 和 DNS 配置
+type EtcConfig struct {
+	ExtraHosts []string // --add-host 添加的记录, 格式为 name:ip
+	DNS        []string // DNS 服务器, 为空时使用宿主机的配置
+	DNSSearch  []string // DNS 搜索域
+	DNSOptions []string // resolv.conf 的 options
+}
+
+// ValidHostname 检查 --hostname 的格式
+func ValidHostname(hostname string) bool {
+	return len(hostname) <= 255 && hostnameRegexp.MatchString(hostname)
+}
+
+// Validate 检查 --add-host 和 --dns 的格式
+func (c *EtcConfig) Validate() error {
+	for _, host := range c.ExtraHosts {
+		if _, _, err := parseExtraHost(host); err != nil {
+			return err
+		}
+	}
+	for _, dns := range c.DNS {
+		if net.ParseIP(dns) == nil {
+			return fmt.Errorf("invalid dns server %q, use an ip address", dns)
+		}
+	}
+	return nil
+}
+
+// 解析 name:ip 格式的 hosts 记录, ip 可以是包含 : 的 IPv6 地址
+func parseExtraHost(host string) (string, net.IP, error) {
+	name, addr, ok := strings.Cut(host, ":")
+	ip := net.ParseIP(addr)
+	if !ok || name == "" || ip == nil {
+		return "", nil, fmt.Errorf("invalid host %q, use: --add-host name:ip", host)
+	}
+	return name, ip, nil
+}
+
+/*
+SetUpEtcFiles 在容器目录中生成 hosts、hostname 和 resolv.conf
+1.hosts 包含 localhost、--add-host 添加的记录，以及容器 ip 对应的主机名(ip 为空时不添加)
+2.resolv.conf 未指定 --dns 时使用宿主机的 DNS 服务器，127.0.0.0/8 和 ::1 在容器的网络命名空间中不可达，会被过滤掉
+3.hostNetwork 为 true 时容器与宿主机共用回环接口，本地的 DNS 服务器(如 systemd-resolved 的 127.0.0.53)可以访问，不过滤
+@return 绑定挂载这些文件到容器内的挂载点
+*/
+func SetUpEtcFiles(containerName, hostname string, ip net.IP, c *EtcConfig, hostNetwork bool) ([]Mount, error) {
+	dir := fmt.Sprintf(DefaultInfoLocation, containerName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var hosts bytes.Buffer
+	hosts.WriteString("127.0.0.1\tlocalhost\n")
+	hosts.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	hosts.WriteString("fe00::0\tip6-localnet\n")
+	hosts.WriteString("ff00::0\tip6-mcastprefix\n")
+	hosts.WriteString("ff02::1\tip6-allnodes\n")
+	hosts.WriteString("ff02::2\tip6-allrouters\n")
+	for _, host := range c.ExtraHosts {
+		name, hostIP, err := parseExtraHost(host)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&hosts, "%s\t%s\n", hostIP, name)
+	}
+	if ip != nil {
+		fmt.Fprintf(&hosts, "%s\t%s\n", ip, hostname)
+	}
+
+	resolvConf, err := buildResolvConf(c, hostNetwork)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name, dest string
+		data       []byte
+	}{
+		{HostsFile, "/etc/hosts", hosts.Bytes()},
+		{HostnameFile, "/etc/hostname", []byte(hostname + "\n")},
+		{ResolvConfFile, "/etc/resolv.conf", resolvConf},
+	}
+	var mounts []Mount
+	for _, f := range files {
+		source := filepath.Join(dir, f.name)
+		if err := os.WriteFile(source, f.data, 0644); err != nil {
+			return nil, fmt.Errorf("write %s fails: %v", source, err)
+		}
+		mounts = append(mounts, Mount{Type: MountTypeBind, Source: source, Destination: f.dest})
+	}
+	return mounts, nil
+}
+
+// 生成 resolv.conf，用户指定的 nameserver、search、options 替换宿主机上对应的配置
+// hostNetwork 为 true 且没有指定 DNS 配置时直接使用宿主机的 resolv.conf
+func buildResolvConf(c *EtcConfig, hostNetwork bool) ([]byte, error) {
+	var nameservers, search, options, others []string
+	f, err := os.Open(hostResolvConf)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil && hostNetwork && len(c.DNS) == 0 && len(c.DNSSearch) == 0 && len(c.DNSOptions) == 0 {
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+				continue
+			}
+			switch fields[0] {
+			case "nameserver":
+				if len(fields) > 1 {
+					if ip := net.ParseIP(fields[1]); ip != nil && (hostNetwork || !ip.IsLoopback()) {
+						nameservers = append(nameservers, fields[1])
+					}
+				}
+			case "search", "domain":
+				search = fields[1:]
+			case "options":
+				options = append(options, fields[1:]...)
+			default:
+				others = append(others, scanner.Text())
+			}
+		}
+	}
+	if len(c.DNS) > 0 {
+		nameservers = c.DNS
+	} else if len(nameservers) == 0 {
+		nameservers = defaultDNS
+	}
+	if len(c.DNSSearch) > 0 {
+		search = c.DNSSearch
+	}
+	if len(c.DNSOptions) > 0 {
+		options = c.DNSOptions
+	}
+
+	var buf bytes.Buffer
+	for _, ns := range nameservers {
+		fmt.Fprintf(&buf, "nameserver %s\n", ns)
+	}
+	// 搜索域为 . 时表示不使用搜索域
+	if len(search) > 0 && !(len(search) == 1 && search[0] == ".") {
+		fmt.Fprintf(&buf, "search %s\n", strings.Join(search, " "))
+	}
+	if len(options) > 0 {
+		fmt.Fprintf(&buf, "options %s\n", strings.Join(options, " "))
+	}
+	for _, line := range others {
+		buf.WriteString(line + "\n")
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,42 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildResolvConf(t *testing.T) {
+	hostResolvConf = filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(hostResolvConf, []byte("# generated\nnameserver 127.0.0.53\nnameserver 10.0.0.2\nsearch corp.local\noptions edns0 trust-ad\nsortlist 10.0.0.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		config   EtcConfig
+		expected string
+	}{
+		{EtcConfig{}, "nameserver 10.0.0.2\nsearch corp.local\noptions edns0 trust-ad\nsortlist 10.0.0.0\n"},
+		{EtcConfig{DNS: []string{"1.1.1.1"}, DNSSearch: []string{"."}, DNSOptions: []string{"ndots:2"}}, "nameserver 1.1.1.1\noptions ndots:2\nsortlist 10.0.0.0\n"},
+	} {
+		data, err := buildResolvConf(&tt.config, false)
+		if err != nil || string(data) != tt.expected {
+			t.Errorf("build resolv.conf with %+v: got %q %v, expect %q", tt.config, data, err, tt.expected)
+		}
+	}
+
+	// 宿主机只有本地 DNS 时使用默认 DNS
+	if err := os.WriteFile(hostResolvConf, []byte("nameserver 127.0.0.53\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := buildResolvConf(&EtcConfig{}, false); string(data) != "nameserver 8.8.8.8\nnameserver 8.8.4.4\n" {
+		t.Errorf("expect default dns, got %q", data)
+	}
+
+	// 使用宿主机网络时可以访问本地 DNS，原样使用宿主机的配置
+	if data, _ := buildResolvConf(&EtcConfig{}, true); string(data) != "nameserver 127.0.0.53\n" {
+		t.Errorf("expect host resolv.conf with host network, got %q", data)
+	}
+	if data, _ := buildResolvConf(&EtcConfig{DNSSearch: []string{"corp.local"}}, true); string(data) != "nameserver 127.0.0.53\nsearch corp.local\n" {
+		t.Errorf("expect local dns kept with host network, got %q", data)
+	}
+}
@@ -1,13 +1,13 @@
 package container
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"syscall"
 )
 
@@ -22,23 +22,76 @@ func InitProcess() error {
 		return err
 	}
 
-	// 获取命令参数
-	containerCmd := readCommand()
-	if containerCmd == nil || len(containerCmd) == 0 {
+	// 获取启动配置
+	initConfig := readInitConfig()
+	if initConfig == nil || len(initConfig.Cmd) == 0 {
 		return fmt.Errorf("init process fails, containerCmd is nil")
 	}
+	containerCmd := initConfig.Cmd
 
-	if err := setUpMount(); err != nil {
+	// 设置主机名, 容器位于独立的 UTS 命名空间中, 不影响宿主机
+	if initConfig.Hostname != "" {
+		if err := syscall.Sethostname([]byte(initConfig.Hostname)); err != nil {
+			logrus.Errorf("set hostname fails: %v", err)
+			return err
+		}
+	}
+
+	if err := setUpMount(initConfig); err != nil {
 		logrus.Errorf("initProcess setUpMount fails: %v", err)
 		return err
 	}
 	//argv := []string{containerCmd}
 
+	// 切换到镜像或用户指定的工作目录
+	if initConfig.WorkDir != "" {
+		if err := os.MkdirAll(initConfig.WorkDir, 0755); err != nil {
+			logrus.Errorf("mkdir work dir %v fails: %v", initConfig.WorkDir, err)
+			return err
+		}
+		if err := syscall.Chdir(initConfig.WorkDir); err != nil {
+			logrus.Errorf("chdir to %v fails: %v", initConfig.WorkDir, err)
+			return err
+		}
+	}
+
+	// 只读根目录，需在 pivot_root 和创建工作目录之后进行
+	// pivot_root 前根目录已绑定挂载到自身，重新挂载只影响根挂载点，数据卷、tmpfs、/proc、/dev 仍然可写
+	if initConfig.ReadOnly {
+		if err := remountReadOnly("/"); err != nil {
+			logrus.Errorf("%v", err)
+			return err
+		}
+	}
+
 	// LookPath 查到参数命令的绝对路径
 	path, err := exec.LookPath(containerCmd[0])
 	if err != nil {
 		logrus.Errorf("initProcess look path fails: %v", err)
-		return nil
+		return err
+	}
+
+	// 去除容器不需要的能力, bounding 集合需在切换用户之前设置
+	caps := initConfig.Capabilities
+	if caps == nil {
+		caps = DefaultCapabilities
+	}
+	capMask := capabilityMask(caps)
+	if err := dropBoundingSet(capMask); err != nil {
+		logrus.Errorf("%v", err)
+		return err
+	}
+
+	// 切换到指定用户, 需在查找命令路径之后进行, 此后进程不再具有root权限
+	if initConfig.User != "" {
+		if err := setUser(initConfig.User); err != nil {
+			logrus.Errorf("set user %v fails: %v", initConfig.User, err)
+			return err
+		}
+	}
+	if err := setCapabilities(capMask); err != nil {
+		logrus.Errorf("%v", err)
+		return err
 	}
 	logrus.Infof("Find path: %v", path)
 
@@ -56,7 +109,8 @@ func InitProcess() error {
 }
 
 // setUpMount init 挂载点
-func setUpMount() error {
+func setUpMount(initConfig *InitConfig) error {
+	mounts := initConfig.Mounts
 	// 获取当前路径
 	pwd, err := os.Getwd()
 	if err != nil {
@@ -74,25 +128,33 @@ func setUpMount() error {
 	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
 
 	// mount, 将挂载命名空间的传播模式设置为 MS_PRIVATE，阻止挂载事件传播到宿主机
-	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+	// 数据卷要求 shared/slave 传播时设置为 MS_SLAVE，宿主机的挂载事件仍能传播到容器内
+	if err := syscall.Mount("", "/", "", uintptr(rootPropagation(mounts)), ""); err != nil {
 		logrus.Errorf("mount / fails: %v", err)
 		return err
 	}
 
-	if err := pivotRoot(pwd); err != nil {
-		logrus.Errorf("pivot root fails: %v", err)
+	// 填充 /dev，需在挂载数据卷之前进行，避免挂载到 /dev 下的数据卷被 tmpfs 覆盖
+	if err := setUpDev(pwd, initConfig.ShmSize, initConfig.Devices); err != nil {
+		logrus.Errorf("set up /dev fails: %v", err)
+		return err
+	}
+
+	// 绑定挂载数据卷，需在 pivot_root 之前进行，此时宿主机目录仍然可见
+	if err := mountVolumes(pwd, mounts); err != nil {
+		logrus.Errorf("mount volumes fails: %v", err)
 		return err
 	}
 
 	// mount proc, 将proc文件系统类型的proc文件系统挂载到/proc目录
-	err = syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
+	// 需在 pivot_root 之前进行: 在用户命名空间中，只有宿主机的 /proc 仍然可见时内核才允许挂载新的 proc
+	err = syscall.Mount("proc", filepath.Join(pwd, "proc"), "proc", uintptr(defaultMountFlags), "")
 	if err != nil {
 		logrus.Errorf("mount /proc fails: %v", err)
 	}
 
-	// tmpfs 是一种基于内存的文件系统，用 RAM 或 swap 分区来存储, 提供临时设备文件存储
-	if err := syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755"); err != nil {
-		logrus.Errorf("mount /dev failed: %v", err)
+	if err := pivotRoot(pwd); err != nil {
+		logrus.Errorf("pivot root fails: %v", err)
 		return err
 	}
 	return nil
@@ -149,7 +211,7 @@ func pivotRoot(root string) error {
 	return os.Remove(pivotDir)
 }
 
-func readCommand() []string {
+func readInitConfig() *InitConfig {
 	// 在新建进程时除了3个标准io操作，将管道作为额外的第四个文件传入，因此管道的fd为3\
 	// 如果父进程没有传入数据则会阻塞等待
 	pipe := os.NewFile(uintptr(3), "pipe")
@@ -158,5 +220,11 @@ func readCommand() []string {
 		logrus.Errorf("read pipe fails: %v", err)
 		return nil
 	}
-	return strings.Split(string(msg), " ")
+	// 启动配置以json格式传递，命令参数中可以包含空格
+	initConfig := &InitConfig{}
+	if err := json.Unmarshal(msg, initConfig); err != nil {
+		logrus.Errorf("unmarshal init config fails: %v", err)
+		return nil
+	}
+	return initConfig
 }
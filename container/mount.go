@@ -0,0 +1,545 @@
+package container
+
+import (
+	"MiniDocker/volume"
+	"bufio"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 挂载类型
+const (
+	MountTypeBind   = "bind"
+	MountTypeVolume = "volume" // 命名数据卷, 见 volume 包
+	MountTypeTmpfs  = "tmpfs"  // 内存文件系统, 容器退出后数据丢失
+)
+
+// tmpfs 默认的挂载标志，与 docker 一致
+const defaultTmpfsFlags = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC
+
+// tmpfs 选项中对应 mount flags 的选项, 值为 true 时设置标志，false 时清除标志
+var tmpfsFlagOptions = map[string]struct {
+	set  bool
+	flag int
+}{
+	"suid":   {false, syscall.MS_NOSUID},
+	"nosuid": {true, syscall.MS_NOSUID},
+	"dev":    {false, syscall.MS_NODEV},
+	"nodev":  {true, syscall.MS_NODEV},
+	"exec":   {false, syscall.MS_NOEXEC},
+	"noexec": {true, syscall.MS_NOEXEC},
+}
+
+// 挂载传播模式对应的 mount flags，默认为 rprivate
+var propagationFlags = map[string]int{
+	"private":  syscall.MS_PRIVATE,
+	"rprivate": syscall.MS_PRIVATE | syscall.MS_REC,
+	"shared":   syscall.MS_SHARED,
+	"rshared":  syscall.MS_SHARED | syscall.MS_REC,
+	"slave":    syscall.MS_SLAVE,
+	"rslave":   syscall.MS_SLAVE | syscall.MS_REC,
+}
+
+// 解析符号链接的最大次数，与内核的 MAXSYMLINKS 一致
+const maxSymlinks = 255
+
+// SELinux 启用时 z/Z 选项使用的标签
+const selinuxContainerLabel = "system_u:object_r:container_file_t:s0"
+
+// Mount 容器的一个挂载点
+type Mount struct {
+	Type          string            `json:"type"`                    // 挂载类型
+	Name          string            `json:"name,omitempty"`          // 命名数据卷的名字
+	Driver        string            `json:"driver,omitempty"`        // 命名数据卷的驱动, 为空时为 local
+	VolumeOptions map[string]string `json:"volumeOptions,omitempty"` // 创建命名数据卷时传给驱动的选项
+	Source        string            `json:"source"`                  // 宿主机上的路径, 命名数据卷在创建工作空间时解析
+	Destination   string            `json:"destination"`             // 容器内的路径
+	ReadOnly      bool              `json:"readOnly"`                // 是否只读
+	Relabel       string            `json:"relabel,omitempty"`       // SELinux 重新标记选项, z 或 Z
+	Propagation   string            `json:"propagation,omitempty"`   // 挂载传播模式, 为空时为 rprivate
+	Options       string            `json:"options,omitempty"`       // tmpfs 的挂载选项, 如 size=64m,mode=1777
+}
+
+// String 以 -v 的格式表示挂载点
+func (m Mount) String() string {
+	options := []string{"rw"}
+	if m.ReadOnly {
+		options[0] = "ro"
+	}
+	if m.Relabel != "" {
+		options = append(options, m.Relabel)
+	}
+	if m.Propagation != "" {
+		options = append(options, m.Propagation)
+	}
+	source := m.Source
+	switch m.Type {
+	case MountTypeVolume:
+		source = m.Name
+	case MountTypeTmpfs:
+		source = MountTypeTmpfs
+		if m.Options != "" {
+			options = append(options, m.Options)
+		}
+	}
+	return fmt.Sprintf("%s:%s:%s", source, m.Destination, strings.Join(options, ","))
+}
+
+// ParseVolume 解析 -v 参数, 格式为 host:container[:ro|rw][,z|Z][,propagation]
+// host 不是绝对路径而是合法的数据卷名时，挂载同名的命名数据卷
+func ParseVolume(spec string) (Mount, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Mount{}, fmt.Errorf("invalid volume %q, use: host:container[:ro|rw][,z][,rshared]", spec)
+	}
+	mount := Mount{Type: MountTypeBind, Source: parts[0], Destination: parts[1]}
+	if !filepath.IsAbs(parts[0]) && volume.ValidName(parts[0]) {
+		mount = Mount{Type: MountTypeVolume, Name: parts[0], Destination: parts[1]}
+	}
+	if len(parts) == 3 {
+		for _, option := range strings.Split(parts[2], ",") {
+			switch option {
+			case "ro":
+				mount.ReadOnly = true
+			case "rw":
+				mount.ReadOnly = false
+			case "z", "Z":
+				mount.Relabel = option
+			case "private", "rprivate", "shared", "rshared", "slave", "rslave":
+				mount.Propagation = option
+			default:
+				return Mount{}, fmt.Errorf("invalid option %q in volume %q", option, spec)
+			}
+		}
+	}
+	return mount, mount.validate()
+}
+
+// ParseMount 解析 --mount 参数, 格式为 type=bind,src=<host>,dst=<container>[,readonly][,bind-propagation=rshared]
+// 或 type=tmpfs,dst=<container>[,tmpfs-size=64m][,tmpfs-mode=1777]
+// 或 type=volume,src=<volume>,dst=<container>[,readonly][,volume-driver=<driver>][,volume-opt=key=value]
+func ParseMount(spec string) (Mount, error) {
+	mount := Mount{Type: MountTypeBind}
+	for _, field := range strings.Split(spec, ",") {
+		key, value, _ := strings.Cut(field, "=")
+		switch strings.ToLower(key) {
+		case "type":
+			mount.Type = value
+		case "source", "src":
+			mount.Source = value
+		case "destination", "dst", "target":
+			mount.Destination = value
+		case "readonly", "ro":
+			switch strings.ToLower(value) {
+			case "", "true", "1":
+				mount.ReadOnly = true
+			case "false", "0":
+				mount.ReadOnly = false
+			default:
+				return Mount{}, fmt.Errorf("invalid value %q for readonly in mount %q", value, spec)
+			}
+		case "volume-driver":
+			mount.Driver = value
+		case "volume-opt":
+			optKey, optValue, _ := strings.Cut(value, "=")
+			if optKey == "" {
+				return Mount{}, fmt.Errorf("invalid volume-opt %q in mount %q, use: volume-opt=key=value", value, spec)
+			}
+			if mount.VolumeOptions == nil {
+				mount.VolumeOptions = map[string]string{}
+			}
+			mount.VolumeOptions[optKey] = optValue
+		case "tmpfs-size":
+			mount.Options = strings.TrimPrefix(mount.Options+",size="+value, ",")
+		case "tmpfs-mode":
+			mount.Options = strings.TrimPrefix(mount.Options+",mode="+value, ",")
+		case "bind-propagation":
+			if _, ok := propagationFlags[value]; !ok {
+				return Mount{}, fmt.Errorf("invalid bind-propagation %q in mount %q", value, spec)
+			}
+			mount.Propagation = value
+		default:
+			return Mount{}, fmt.Errorf("unknown option %q in mount %q", key, spec)
+		}
+	}
+	if mount.Type == MountTypeTmpfs {
+		if mount.Source != "" || mount.Driver != "" || mount.VolumeOptions != nil || mount.Propagation != "" {
+			return Mount{}, fmt.Errorf("only dst, readonly, tmpfs-size and tmpfs-mode are supported for tmpfs mount %q", spec)
+		}
+		if mount.Destination == "" {
+			return Mount{}, fmt.Errorf("invalid mount %q, dst is required", spec)
+		}
+		if _, _, err := tmpfsMountOptions(mount.Options); err != nil {
+			return Mount{}, fmt.Errorf("invalid mount %q: %v", spec, err)
+		}
+		return mount, mount.validate()
+	}
+	if mount.Options != "" {
+		return Mount{}, fmt.Errorf("tmpfs-size and tmpfs-mode are only supported for tmpfs mount %q", spec)
+	}
+	if mount.Source == "" || mount.Destination == "" {
+		return Mount{}, fmt.Errorf("invalid mount %q, both src and dst are required", spec)
+	}
+	switch mount.Type {
+	case MountTypeBind:
+		if mount.Driver != "" || mount.VolumeOptions != nil {
+			return Mount{}, fmt.Errorf("volume-driver and volume-opt are only supported for volume mount %q", spec)
+		}
+	case MountTypeVolume:
+		if mount.Propagation != "" {
+			return Mount{}, fmt.Errorf("bind-propagation is not supported for volume mount %q", spec)
+		}
+		if mount.Driver != "" && !volume.ValidName(mount.Driver) {
+			return Mount{}, fmt.Errorf("invalid volume driver %q", mount.Driver)
+		}
+		if !volume.ValidName(mount.Source) {
+			return Mount{}, fmt.Errorf("invalid volume name %q", mount.Source)
+		}
+		mount.Name, mount.Source = mount.Source, ""
+		return mount, mount.validate()
+	default:
+		return Mount{}, fmt.Errorf("unsupported mount type %q", mount.Type)
+	}
+	if err := mount.validate(); err != nil {
+		return Mount{}, err
+	}
+	// 与 docker 一致，--mount 不会自动创建宿主机目录
+	if _, err := os.Stat(mount.Source); err != nil {
+		return Mount{}, fmt.Errorf("bind source path %s does not exist", mount.Source)
+	}
+	return mount, nil
+}
+
+// ParseTmpfs 解析 --tmpfs 参数, 格式为 container[:size=64m,mode=1777,uid=0,gid=0,nr_inodes=1k,ro,exec,suid,dev]
+func ParseTmpfs(spec string) (Mount, error) {
+	destination, options, _ := strings.Cut(spec, ":")
+	mount := Mount{Type: MountTypeTmpfs, Destination: destination}
+	var data []string
+	for _, option := range strings.Split(options, ",") {
+		switch option {
+		case "":
+		case "ro":
+			mount.ReadOnly = true
+		case "rw":
+			mount.ReadOnly = false
+		default:
+			data = append(data, option)
+		}
+	}
+	mount.Options = strings.Join(data, ",")
+	if _, _, err := tmpfsMountOptions(mount.Options); err != nil {
+		return Mount{}, fmt.Errorf("invalid tmpfs %q: %v", spec, err)
+	}
+	return mount, mount.validate()
+}
+
+// 将 tmpfs 选项转换为 mount flags 和传给内核的 data
+func tmpfsMountOptions(options string) (int, string, error) {
+	flags := defaultTmpfsFlags
+	var data []string
+	for _, option := range strings.Split(options, ",") {
+		if option == "" {
+			continue
+		}
+		if flagOption, ok := tmpfsFlagOptions[option]; ok {
+			if flagOption.set {
+				flags |= flagOption.flag
+			} else {
+				flags &^= flagOption.flag
+			}
+			continue
+		}
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "size", "nr_inodes", "uid", "gid":
+			if value == "" {
+				return 0, "", fmt.Errorf("option %s requires a value", key)
+			}
+		case "mode":
+			if _, err := strconv.ParseUint(value, 8, 32); err != nil {
+				return 0, "", fmt.Errorf("invalid mode %q", value)
+			}
+		default:
+			return 0, "", fmt.Errorf("unknown option %q", option)
+		}
+		data = append(data, option)
+	}
+	return flags, strings.Join(data, ","), nil
+}
+
+// 检查挂载点路径
+func (m *Mount) validate() error {
+	if m.Type == MountTypeBind && !filepath.IsAbs(m.Source) {
+		return fmt.Errorf("bind source %q must be an absolute path", m.Source)
+	}
+	if !filepath.IsAbs(m.Destination) {
+		return fmt.Errorf("mount destination %q must be an absolute path", m.Destination)
+	}
+	if m.Type == MountTypeBind {
+		m.Source = filepath.Clean(m.Source)
+	}
+	m.Destination = filepath.Clean(m.Destination)
+	if m.Destination == "/" {
+		return fmt.Errorf("can not mount over the container root")
+	}
+	return nil
+}
+
+// ParseMounts 解析所有 -v、--tmpfs 和 --mount 参数，同一个容器路径只能挂载一次
+func ParseMounts(volumes, tmpfs, mountSpecs []string) ([]Mount, error) {
+	var mounts []Mount
+	seen := map[string]bool{}
+	add := func(mount Mount) error {
+		if seen[mount.Destination] {
+			return fmt.Errorf("duplicate mount point %s", mount.Destination)
+		}
+		seen[mount.Destination] = true
+		mounts = append(mounts, mount)
+		return nil
+	}
+	for _, spec := range volumes {
+		mount, err := ParseVolume(spec)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(mount); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range tmpfs {
+		mount, err := ParseTmpfs(spec)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(mount); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range mountSpecs {
+		mount, err := ParseMount(spec)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(mount); err != nil {
+			return nil, err
+		}
+	}
+	// 按容器路径排序，保证父目录先于子目录挂载
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(strings.Split(mounts[i].Destination, "/")) < len(strings.Split(mounts[j].Destination, "/"))
+	})
+	return mounts, nil
+}
+
+// 容器根挂载点的传播模式，有数据卷要求 shared/slave 传播时为 rslave，否则为 rprivate
+// 容器内的挂载事件都不会传播到宿主机
+func rootPropagation(mounts []Mount) int {
+	for _, mount := range mounts {
+		switch mount.Propagation {
+		case "shared", "rshared", "slave", "rslave":
+			return syscall.MS_SLAVE | syscall.MS_REC
+		}
+	}
+	return syscall.MS_PRIVATE | syscall.MS_REC
+}
+
+/*
+mountVolumes 在容器的挂载命名空间中将数据卷绑定挂载到 rootfs 下
+1.在 rootfs 内解析容器路径，符号链接不能指向 rootfs 之外
+2.创建挂载点，源为文件时创建空文件
+3.MS_BIND|MS_REC 绑定挂载，只读时以 MS_RDONLY 重新挂载
+4.设置挂载传播模式
+tmpfs 直接挂载到挂载点
+*/
+func mountVolumes(rootfs string, mounts []Mount) error {
+	for _, mount := range mounts {
+		target, err := SecureJoin(rootfs, mount.Destination)
+		if err != nil {
+			return err
+		}
+		if mount.Type == MountTypeTmpfs {
+			if err := mountTmpfs(target, mount); err != nil {
+				return err
+			}
+			continue
+		}
+		stat, err := os.Stat(mount.Source)
+		if err != nil {
+			return fmt.Errorf("stat volume source %s fails: %v", mount.Source, err)
+		}
+		if err := createMountTarget(target, stat.IsDir()); err != nil {
+			return err
+		}
+		if err := syscall.Mount(mount.Source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind mount %s to %s fails: %v", mount.Source, target, err)
+		}
+		if mount.ReadOnly {
+			if err := remountReadOnly(target); err != nil {
+				return err
+			}
+		}
+		propagation := mount.Propagation
+		if propagation == "" {
+			propagation = "rprivate"
+		}
+		if err := syscall.Mount("", target, "", uintptr(propagationFlags[propagation]), ""); err != nil {
+			return fmt.Errorf("set propagation %s of %s fails: %v", propagation, target, err)
+		}
+		logrus.Infof("mount volume %v", mount)
+	}
+	return nil
+}
+
+// 以只读方式重新挂载绑定挂载点 target，只影响 target 本身，不影响其下的子挂载点
+// 绑定挂载时 MS_RDONLY 会被忽略，需要重新挂载，并保留原挂载点的 nosuid/nodev/noexec 标志
+func remountReadOnly(target string) error {
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	var statfs unix.Statfs_t
+	if err := unix.Statfs(target, &statfs); err == nil {
+		flags |= uintptr(statfs.Flags) & (unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC)
+	}
+	if err := syscall.Mount("", target, "", flags, ""); err != nil {
+		return fmt.Errorf("remount %s read-only fails: %v", target, err)
+	}
+	return nil
+}
+
+// 在 target 挂载 tmpfs
+func mountTmpfs(target string, mount Mount) error {
+	flags, data, err := tmpfsMountOptions(mount.Options)
+	if err != nil {
+		return err
+	}
+	if mount.ReadOnly {
+		flags |= syscall.MS_RDONLY
+	}
+	if err := createMountTarget(target, true); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", target, "tmpfs", uintptr(flags), data); err != nil {
+		return fmt.Errorf("mount tmpfs to %s fails: %v", target, err)
+	}
+	logrus.Infof("mount tmpfs %v", mount)
+	return nil
+}
+
+// 创建挂载点，已存在时检查类型是否与源一致
+func createMountTarget(target string, dir bool) error {
+	if stat, err := os.Stat(target); err == nil {
+		if stat.IsDir() != dir {
+			return fmt.Errorf("mount point %s type does not match the source", target)
+		}
+		return nil
+	}
+	if dir {
+		return os.MkdirAll(target, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// SecureJoin 将容器内的路径拼接到 root 下，路径中的符号链接以 root 为根解析，结果不会超出 root
+func SecureJoin(root, path string) (string, error) {
+	resolved := ""
+	remaining := filepath.Clean("/" + path)
+	for links := 0; remaining != ""; {
+		var part string
+		part, remaining, _ = strings.Cut(strings.TrimPrefix(remaining, "/"), "/")
+		if remaining != "" {
+			remaining = "/" + remaining
+		}
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if i := strings.LastIndex(resolved, "/"); i >= 0 {
+				resolved = resolved[:i]
+			}
+			continue
+		}
+		next := resolved + "/" + part
+		stat, err := os.Lstat(filepath.Join(root, next))
+		if err != nil || stat.Mode()&os.ModeSymlink == 0 {
+			// 不存在的部分由调用者创建
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many symlinks in %s", path)
+		}
+		dest, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(dest) {
+			resolved = ""
+		}
+		remaining = "/" + dest + remaining
+	}
+	return filepath.Join(root, resolved), nil
+}
+
+// 为宿主机目录设置 SELinux 标签，使容器可以访问，未启用 SELinux 时忽略
+// z 与 Z 使用相同的标签，暂不支持按容器区分的 MCS 类别
+func relabel(path string) error {
+	if _, err := os.Stat("/sys/fs/selinux/enforce"); err != nil {
+		return nil
+	}
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return unix.Lsetxattr(p, "security.selinux", []byte(selinuxContainerLabel), 0)
+	})
+}
+
+// UnmountAll 卸载 dir 及其子目录上的所有挂载点，先卸载最深的
+func UnmountAll(dir string) error {
+	mounts, err := mountPointsUnder(dir)
+	if err != nil {
+		return err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(mounts)))
+	for _, mnt := range mounts {
+		if err := syscall.Unmount(mnt, syscall.MNT_DETACH); err != nil {
+			return fmt.Errorf("umount %s fails: %v", mnt, err)
+		}
+		logrus.Infof("umount %s", mnt)
+	}
+	return nil
+}
+
+// 从 /proc/self/mounts 中找出位于 dir 及其子目录的挂载点
+func mountPointsUnder(dir string) ([]string, error) {
+	file, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	dir = filepath.Clean(dir)
+	var mounts []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if fields[1] == dir || strings.HasPrefix(fields[1], dir+"/") {
+			mounts = append(mounts, fields[1])
+		}
+	}
+	return mounts, scanner.Err()
+}
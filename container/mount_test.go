@@ -0,0 +1,92 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseMounts(t *testing.T) {
+	src := t.TempDir()
+	mounts, err := ParseMounts(
+		[]string{"/host/data:/data/sub:ro,z", "/host/logs:/logs:rw,rshared", "db:/var/lib/db"},
+		[]string{"/run:size=64m,mode=1777,exec", "/tmp:ro"},
+		[]string{"type=tmpfs,dst=/cache/tmp,tmpfs-size=1g,tmpfs-mode=700", "type=bind,src=" + src + ",dst=/data,readonly,bind-propagation=rslave", "type=volume,src=cache,dst=/cache,volume-driver=capped,volume-opt=size=64m"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Mount{
+		{Type: MountTypeBind, Source: "/host/logs", Destination: "/logs", Propagation: "rshared"},
+		{Type: MountTypeTmpfs, Destination: "/run", Options: "size=64m,mode=1777,exec"},
+		{Type: MountTypeTmpfs, Destination: "/tmp", ReadOnly: true},
+		{Type: MountTypeBind, Source: src, Destination: "/data", ReadOnly: true, Propagation: "rslave"},
+		{Type: MountTypeVolume, Name: "cache", Driver: "capped", VolumeOptions: map[string]string{"size": "64m"}, Destination: "/cache"},
+		{Type: MountTypeBind, Source: "/host/data", Destination: "/data/sub", ReadOnly: true, Relabel: "z"},
+		{Type: MountTypeTmpfs, Destination: "/cache/tmp", Options: "size=1g,mode=700"},
+		{Type: MountTypeVolume, Name: "db", Destination: "/var/lib/db"},
+	}
+	if !reflect.DeepEqual(mounts, expected) {
+		t.Errorf("got %+v, expect %+v", mounts, expected)
+	}
+
+	for _, tt := range []struct {
+		volumes []string
+		tmpfs   []string
+		mounts  []string
+	}{
+		{tmpfs: []string{"run"}},
+		{tmpfs: []string{"/run:mode=999"}},
+		{tmpfs: []string{"/run:size"}},
+		{tmpfs: []string{"/run:uid=0,foo=bar"}},
+		{tmpfs: []string{"/data"}, volumes: []string{"/host:/data"}},
+		{mounts: []string{"type=tmpfs,src=/host,dst=/data"}},
+		{mounts: []string{"type=bind,src=" + src + ",dst=/data,tmpfs-size=1m"}},
+		{volumes: []string{"/host"}},
+		{volumes: []string{"./relative:/data"}},
+		{mounts: []string{"type=volume,src=/abs,dst=/data"}},
+		{mounts: []string{"type=bind,src=" + src + ",dst=/data,volume-driver=capped"}},
+		{volumes: []string{"/host:/data:rx"}},
+		{volumes: []string{"/a:/data", "/b:/data/"}},
+		{mounts: []string{"type=bind,src=/does/not/exist,dst=/data"}},
+		{mounts: []string{"type=bind,src=" + src}},
+		{mounts: []string{"type=nfs,src=" + src + ",dst=/data"}},
+		{mounts: []string{"type=bind,src=" + src + ",dst=/data,bind-propagation=up"}},
+	} {
+		if _, err := ParseMounts(tt.volumes, tt.tmpfs, tt.mounts); err == nil {
+			t.Errorf("expect error for %v %v %v", tt.volumes, tt.tmpfs, tt.mounts)
+		}
+	}
+}
+
+func TestSecureJoin(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "usr/lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{"lib": "usr/lib", "abs": "/usr", "escape": "../../..", "loop": "loop"} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, expected := range map[string]string{
+		"/data":          "/data",
+		"/lib/x":         "/usr/lib/x",
+		"/abs/lib":       "/usr/lib",
+		"/escape/etc":    "/etc",
+		"/../../etc":     "/etc",
+		"/usr/../lib/.x": "/usr/lib/.x",
+	} {
+		got, err := SecureJoin(root, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != filepath.Join(root, expected) {
+			t.Errorf("SecureJoin(%s) = %s, expect %s", path, got, filepath.Join(root, expected))
+		}
+	}
+	if _, err := SecureJoin(root, "/loop/x"); err == nil {
+		t.Error("expect error for symlink loop")
+	}
+}
@@ -0,0 +1,180 @@
+package container
+
+import (
+	"MiniDocker/archive"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+/*
+GetRootfs 得到在宿主机上访问容器文件系统的根目录
+1.运行中的容器通过 /proc/<pid>/root 访问，可以看到容器挂载命名空间中的数据卷和 tmpfs
+2.已停止的容器使用宿主机上的 overlay 挂载点，见 MountRootfs
+*/
+func GetRootfs(info *ContainerInfo) (string, error) {
+	if info.Status == RUNNING {
+		if pid, err := strconv.Atoi(info.Pid); err == nil && syscall.Kill(pid, 0) == nil {
+			return fmt.Sprintf("/proc/%d/root", pid), nil
+		}
+	}
+	return MountRootfs(info)
+}
+
+// MountRootfs 得到宿主机上镜像层与可写层合并后的挂载点 /root/mnt/<name>，不包含数据卷等容器内的挂载
+// 未挂载时(如宿主机重启后)由创建容器时使用的存储驱动重新挂载
+func MountRootfs(info *ContainerInfo) (string, error) {
+	mntURL := fmt.Sprintf(MntUrl, info.Name)
+	if mounted, _ := isMountPoint(mntURL); mounted {
+		return mntURL, nil
+	}
+	if _, err := os.Stat(fmt.Sprintf(WriteLayerUrl, info.Name)); err != nil {
+		return "", fmt.Errorf("write layer of container %s fails: %v", info.Name, err)
+	}
+	snapshotter, err := GetSnapshotter(info.StorageDriver)
+	if err != nil {
+		return "", err
+	}
+	if err := mountStorage(info.Name); err != nil {
+		return "", err
+	}
+	layers, err := containerParents(info)
+	if err != nil {
+		return "", err
+	}
+	snapshotMounts, err := snapshotter.Mounts(info.Name, layers)
+	if err != nil {
+		return "", err
+	}
+	if err := CreateMountPoint(info.Name, snapshotMounts); err != nil {
+		return "", fmt.Errorf("mount rootfs of container %s fails: %v", info.Name, err)
+	}
+	return mntURL, nil
+}
+
+// ResolvePath 将容器内的路径解析为宿主机上 root 下的路径，followLink 为 false 时不跟随最后一级符号链接
+func ResolvePath(root, path string, followLink bool) (string, error) {
+	path = filepath.Clean("/" + path)
+	if followLink || path == "/" {
+		return SecureJoin(root, path)
+	}
+	parent, err := SecureJoin(root, filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, filepath.Base(path)), nil
+}
+
+// CommitChanges 由存储驱动将容器相对于镜像的修改打包为镜像层 tar 流写入 w
+// 使用用户命名空间的容器，文件属主转换回容器内的 id
+func CommitChanges(info *ContainerInfo, w io.Writer) error {
+	snapshotter, err := GetSnapshotter(info.StorageDriver)
+	if err != nil {
+		return err
+	}
+	parents, err := containerParents(info)
+	if err != nil {
+		return err
+	}
+	if info.IDMappings == nil {
+		return snapshotter.Commit(info.Name, parents, w)
+	}
+	return mapToContainer(info.IDMappings, w, func(w io.Writer) error {
+		return snapshotter.Commit(info.Name, parents, w)
+	})
+}
+
+// ExportRootfs 将容器镜像层与可写层合并后的文件系统打包为 tar 流写入 w，不包含数据卷等容器内的挂载
+// 使用用户命名空间的容器，文件属主转换回容器内的 id
+func ExportRootfs(info *ContainerInfo, w io.Writer) error {
+	root, err := MountRootfs(info)
+	if err != nil {
+		return err
+	}
+	if info.IDMappings == nil {
+		return archive.Tar(root, w)
+	}
+	return mapToContainer(info.IDMappings, w, func(w io.Writer) error {
+		return archive.Tar(root, w)
+	})
+}
+
+// 将 tarFunc 生成的 tar 流中宿主机上的属主转换为容器内的 id 后写入 w
+func mapToContainer(maps *IDMappings, w io.Writer, tarFunc func(w io.Writer) error) error {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(tarFunc(writer))
+	}()
+	mapped := archive.MapIDs(reader, maps.ToContainer)
+	defer reader.Close()
+	defer mapped.Close()
+	_, err := io.Copy(w, mapped)
+	return err
+}
+
+/*
+GetChanges 得到容器可写层相对于镜像的文件变化
+运行时为挂载 hosts 等文件和数据卷而创建的挂载点不属于容器的修改，不予列出，仅因此被创建或复制到可写层的父目录也一并忽略
+*/
+func GetChanges(info *ContainerInfo) ([]archive.Change, error) {
+	snapshotter, err := GetSnapshotter(info.StorageDriver)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := containerParents(info)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := snapshotter.Changes(info.Name, layers)
+	if err != nil {
+		return nil, err
+	}
+	mountPoints := map[string]bool{"/etc/hosts": true, "/etc/hostname": true, "/etc/resolv.conf": true}
+	for _, mount := range info.Mounts {
+		mountPoints[filepath.Clean(mount.Destination)] = true
+	}
+	// 挂载点的父目录，若其下没有其他变化则忽略
+	parents := map[string]bool{}
+	for path := range mountPoints {
+		for dir := filepath.Dir(path); dir != "/"; dir = filepath.Dir(dir) {
+			parents[dir] = true
+		}
+	}
+	var result []archive.Change
+	for _, change := range changes {
+		if mountPoints[change.Path] {
+			continue
+		}
+		if change.Kind != archive.ChangeDelete && parents[change.Path] && !hasOtherChanges(changes, change.Path, mountPoints) {
+			continue
+		}
+		result = append(result, change)
+	}
+	return result, nil
+}
+
+// 判断 dir 下是否有挂载点以外的变化
+func hasOtherChanges(changes []archive.Change, dir string, mountPoints map[string]bool) bool {
+	for _, change := range changes {
+		if strings.HasPrefix(change.Path, dir+"/") && !mountPoints[change.Path] {
+			return true
+		}
+	}
+	return false
+}
+
+// 得到容器创建时使用的镜像的只读层目录，未记录镜像的旧容器没有只读层
+func containerParents(info *ContainerInfo) ([]string, error) {
+	if info.Image == "" {
+		return nil, nil
+	}
+	layers, err := imageLayers(info.ImageID, info.Image)
+	if err != nil {
+		return nil, err
+	}
+	return remapLayers(layers, info.IDMappings)
+}
@@ -0,0 +1,73 @@
+package container
+
+import (
+	"MiniDocker/archive"
+	"fmt"
+	"io"
+	"os"
+)
+
+/*
+native 存储驱动，将镜像各层依次复制到容器的可写层目录中，得到完整的根文件系统后绑定挂载
+用于不能使用 overlay 的文件系统(如 overlay 上再挂载 overlay)，每个容器都占用一份镜像大小的空间
+*/
+type nativeSnapshotter struct{}
+
+func (s *nativeSnapshotter) Name() string {
+	return StorageDriverNative
+}
+
+func (s *nativeSnapshotter) Prepare(key string, parents []string) ([]SnapshotMount, error) {
+	if err := s.copyLayers(key, parents); err != nil {
+		return nil, err
+	}
+	return s.Mounts(key, parents)
+}
+
+func (s *nativeSnapshotter) View(key string, parents []string) ([]SnapshotMount, error) {
+	if err := s.copyLayers(key, parents); err != nil {
+		return nil, err
+	}
+	return []SnapshotMount{{Type: "bind", Source: fmt.Sprintf(WriteLayerUrl, key), Options: []string{"ro"}}}, nil
+}
+
+func (s *nativeSnapshotter) Mounts(key string, parents []string) ([]SnapshotMount, error) {
+	return []SnapshotMount{{Type: "bind", Source: fmt.Sprintf(WriteLayerUrl, key)}}, nil
+}
+
+func (s *nativeSnapshotter) Changes(key string, parents []string) ([]archive.Change, error) {
+	return archive.ChangesDirs(fmt.Sprintf(WriteLayerUrl, key), parents)
+}
+
+// Commit 比较快照与镜像各层得到变化，只打包变化的文件
+func (s *nativeSnapshotter) Commit(key string, parents []string, w io.Writer) error {
+	changes, err := s.Changes(key, parents)
+	if err != nil {
+		return err
+	}
+	return archive.TarChanges(fmt.Sprintf(WriteLayerUrl, key), changes, w)
+}
+
+func (s *nativeSnapshotter) Remove(key string) error {
+	return removeSnapshot(key)
+}
+
+// 由下到上将镜像各层叠加到快照目录中，处理各层中的删除标记和不透明目录
+func (s *nativeSnapshotter) copyLayers(key string, parents []string) error {
+	dir := fmt.Sprintf(WriteLayerUrl, key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		reader, writer := io.Pipe()
+		go func(layer string) {
+			writer.CloseWithError(archive.TarLayer(layer, writer))
+		}(parents[i])
+		err := archive.ApplyLayer(reader, dir)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("copy layer %s fails: %v", parents[i], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package container
+
+import (
+	"MiniDocker/archive"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// overlay 存储驱动，镜像各层作为 lowerdir，容器的可写层作为 upperdir，不需要复制镜像的内容
+type overlaySnapshotter struct{}
+
+func (s *overlaySnapshotter) Name() string {
+	return StorageDriverOverlay
+}
+
+func (s *overlaySnapshotter) Prepare(key string, parents []string) ([]SnapshotMount, error) {
+	upper, work := overlayDirs(key)
+	for _, dir := range []string{upper, work} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return s.Mounts(key, parents)
+}
+
+// View 没有 upperdir 的 overlay 是只读的，但至少需要两层 lowerdir，只有一层时只读绑定挂载该层
+func (s *overlaySnapshotter) View(key string, parents []string) ([]SnapshotMount, error) {
+	if len(parents) == 0 {
+		return nil, fmt.Errorf("view %s: no parent layer", key)
+	}
+	if len(parents) == 1 {
+		return []SnapshotMount{{Type: "bind", Source: parents[0], Options: []string{"ro"}}}, nil
+	}
+	return []SnapshotMount{{
+		Type:    "overlay",
+		Source:  "overlay",
+		Options: overlayOptions("lowerdir=" + strings.Join(parents, ":")),
+	}}, nil
+}
+
+func (s *overlaySnapshotter) Mounts(key string, parents []string) ([]SnapshotMount, error) {
+	upper, work := overlayDirs(key)
+	return []SnapshotMount{{
+		Type:   "overlay",
+		Source: "overlay",
+		Options: overlayOptions(
+			"lowerdir="+strings.Join(parents, ":"),
+			"upperdir="+upper,
+			"workdir="+work,
+		),
+	}}, nil
+}
+
+// rootless 模式下 overlay 使用 user.overlay.* xattr
+func overlayOptions(options ...string) []string {
+	if Rootless {
+		options = append(options, "userxattr")
+	}
+	return options
+}
+
+func (s *overlaySnapshotter) Changes(key string, parents []string) ([]archive.Change, error) {
+	return archive.Changes(fmt.Sprintf(WriteLayerUrl, key), parents)
+}
+
+// Commit upperdir 即为容器的修改，删除标记转换为 .wh. 文件
+func (s *overlaySnapshotter) Commit(key string, parents []string, w io.Writer) error {
+	return archive.TarLayer(fmt.Sprintf(WriteLayerUrl, key), w)
+}
+
+func (s *overlaySnapshotter) Remove(key string) error {
+	return removeSnapshot(key)
+}
@@ -0,0 +1,184 @@
+package container
+
+import (
+	"MiniDocker/archive"
+	"bufio"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 内置的存储驱动
+const (
+	StorageDriverOverlay = "overlay"
+	StorageDriverNative  = "native"
+)
+
+// StorageDriver 新建容器使用的存储驱动，由 --storage-driver 或配置文件指定，见 SetStorageDriver
+var StorageDriver = StorageDriverOverlay
+
+/*
+Snapshotter 存储驱动，为容器准备根文件系统
+快照以容器名为 key, 数据位于 WriteLayerUrl/<key>，parents 为镜像的只读层目录(由上到下，与 overlay 的 lowerdir 一致)
+*/
+type Snapshotter interface {
+	// Name 驱动名，即 --storage-driver 的值
+	Name() string
+	// Prepare 基于 parents 创建可写的快照，返回挂载快照所需的挂载
+	Prepare(key string, parents []string) ([]SnapshotMount, error)
+	// View 基于 parents 创建只读的快照，返回挂载快照所需的挂载
+	View(key string, parents []string) ([]SnapshotMount, error)
+	// Mounts 返回已创建的可写快照的挂载，用于重新挂载(如宿主机重启后)
+	Mounts(key string, parents []string) ([]SnapshotMount, error)
+	// Changes 快照相对于 parents 的文件变化
+	Changes(key string, parents []string) ([]archive.Change, error)
+	// Commit 将快照相对于 parents 的变化打包为镜像层 tar 流写入 w
+	Commit(key string, parents []string, w io.Writer) error
+	// Remove 删除快照
+	Remove(key string) error
+}
+
+// SnapshotMount 挂载快照的一次挂载, 依次挂载到根文件系统的挂载点上
+type SnapshotMount struct {
+	Type    string   // 文件系统类型, 绑定挂载为 bind
+	Source  string   // 挂载源
+	Options []string // 挂载选项, 如 ro、lowerdir=...
+}
+
+var snapshotters = map[string]Snapshotter{
+	StorageDriverOverlay: &overlaySnapshotter{},
+	StorageDriverNative:  &nativeSnapshotter{},
+}
+
+// GetSnapshotter 根据驱动名得到存储驱动，未记录驱动的旧容器使用 overlay
+func GetSnapshotter(name string) (Snapshotter, error) {
+	if name == "" {
+		name = StorageDriverOverlay
+	}
+	s, ok := snapshotters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage driver %q, supported: %s", name, strings.Join(StorageDrivers(), ", "))
+	}
+	return s, nil
+}
+
+// StorageDrivers 支持的存储驱动
+func StorageDrivers() []string {
+	var names []string
+	for name := range snapshotters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+SetStorageDriver 设置新建容器使用的存储驱动，name 为空时自动选择
+内核不支持 overlay, 或数据根目录本身位于 overlay 上(如嵌套在容器中的 CI)时无法使用 overlay，选择 native
+*/
+func SetStorageDriver(name string) error {
+	if name == "" {
+		name = StorageDriverOverlay
+		if err := overlaySupported(RootUrl); err != nil {
+			logrus.Debugf("%v, use %s storage driver", err, StorageDriverNative)
+			name = StorageDriverNative
+		}
+	}
+	if _, err := GetSnapshotter(name); err != nil {
+		return err
+	}
+	StorageDriver = name
+	return nil
+}
+
+// 判断能否在 root 下使用 overlay 作为容器的根文件系统
+func overlaySupported(root string) error {
+	f, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	supported := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 && fields[len(fields)-1] == "overlay" {
+			supported = true
+		}
+	}
+	if !supported {
+		return fmt.Errorf("overlay is not supported by the kernel")
+	}
+	// root 可能尚未创建，检查已存在的最近一级目录
+	for dir := root; ; dir = filepath.Dir(dir) {
+		var stat unix.Statfs_t
+		if err := unix.Statfs(dir, &stat); err == nil {
+			if stat.Type == unix.OVERLAYFS_SUPER_MAGIC {
+				return fmt.Errorf("%s is on overlay", root)
+			}
+			return nil
+		}
+		if dir == "/" {
+			return nil
+		}
+	}
+}
+
+// 将快照挂载到 target
+func mountSnapshot(mounts []SnapshotMount, target string) error {
+	for _, m := range mounts {
+		var flags uintptr
+		var data []string
+		for _, option := range m.Options {
+			switch option {
+			case "ro":
+				flags |= unix.MS_RDONLY
+			case "rw":
+			default:
+				data = append(data, option)
+			}
+		}
+		if m.Type == "bind" {
+			if err := unix.Mount(m.Source, target, "", unix.MS_BIND, ""); err != nil {
+				return fmt.Errorf("bind mount %s to %s fails: %v", m.Source, target, err)
+			}
+			// 绑定挂载需重新挂载才能设为只读
+			if flags&unix.MS_RDONLY != 0 {
+				if err := unix.Mount("", target, "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY, ""); err != nil {
+					return fmt.Errorf("remount %s read only fails: %v", target, err)
+				}
+			}
+			continue
+		}
+		if err := unix.Mount(m.Source, target, m.Type, flags, strings.Join(data, ",")); err != nil {
+			return fmt.Errorf("mount %s %s to %s fails: %v", m.Type, m.Source, target, err)
+		}
+	}
+	return nil
+}
+
+// 删除快照的可写层、工作目录以及限制大小的镜像文件，先卸载其中的挂载
+func removeSnapshot(key string) error {
+	writeURL := fmt.Sprintf(WriteLayerUrl, key)
+	workURL := fmt.Sprintf(WorkLayerUrl, key)
+	for _, dir := range []string{writeURL, workURL} {
+		if err := UnmountAll(dir); err != nil {
+			return fmt.Errorf("umount %v fails: %v", dir, err)
+		}
+	}
+	if err := os.RemoveAll(writeURL); err != nil {
+		return fmt.Errorf("remove writeLayer dir: %v fails: %v", writeURL, err)
+	}
+	if err := os.RemoveAll(workURL); err != nil {
+		return fmt.Errorf("remove tmpWork dir: %v fails: %v", workURL, err)
+	}
+	storage := fmt.Sprintf(StorageUrl, key)
+	if err := os.Remove(storage); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove storage %v fails: %v", storage, err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package container
+
+import (
+	"MiniDocker/archive"
+	"MiniDocker/image"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNativeSnapshotter(t *testing.T) {
+	root, lower := t.TempDir(), t.TempDir()
+	// 测试结束后恢复默认的目录
+	defer SetRoot(RootUrl, filepath.Dir(filepath.Clean(DefaultInfoLocation)))
+	SetRoot(root, root)
+	for name, content := range map[string]string{"etc/passwd": "root", "bin/sh": "sh"} {
+		path := filepath.Join(lower, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := GetSnapshotter(StorageDriverNative)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mounts, err := s.Prepare("c1", []string{lower})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, "writeLayer", "c1")
+	if expect := []SnapshotMount{{Type: "bind", Source: dir}}; !reflect.DeepEqual(mounts, expect) {
+		t.Errorf("got mounts %+v, expect %+v", mounts, expect)
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "etc/passwd")); err != nil || string(content) != "root" {
+		t.Fatalf("layer not copied: %q, %v", content, err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "bin/sh")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app"), []byte("app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := s.Changes("c1", []string{lower})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []archive.Change{{Path: "/app", Kind: archive.ChangeAdd}, {Path: "/bin", Kind: archive.ChangeModify}, {Path: "/bin/sh", Kind: archive.ChangeDelete}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("got changes %v, expect %v", changes, expected)
+	}
+
+	if err := s.Remove("c1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("snapshot not removed: %v", err)
+	}
+}
+
+func TestOverlayView(t *testing.T) {
+	s, err := GetSnapshotter("")
+	if err != nil || s.Name() != StorageDriverOverlay {
+		t.Fatalf("default snapshotter: %v, %v", s, err)
+	}
+	mounts, err := s.View("v1", []string{"/layers/a"})
+	if err != nil || !reflect.DeepEqual(mounts, []SnapshotMount{{Type: "bind", Source: "/layers/a", Options: []string{"ro"}}}) {
+		t.Errorf("view of one layer: got %+v, %v", mounts, err)
+	}
+	mounts, err = s.View("v1", []string{"/layers/b", "/layers/a"})
+	if err != nil || !reflect.DeepEqual(mounts, []SnapshotMount{{Type: "overlay", Source: "overlay", Options: []string{"lowerdir=/layers/b:/layers/a"}}}) {
+		t.Errorf("view of two layers: got %+v, %v", mounts, err)
+	}
+	if _, err := GetSnapshotter("aufs"); err == nil {
+		t.Errorf("expect error for unknown storage driver")
+	}
+}
+
+func TestContainerParentsUseRecordedImage(t *testing.T) {
+	root := t.TempDir()
+	defer SetRoot(RootUrl, filepath.Dir(filepath.Clean(DefaultInfoLocation)))
+	SetRoot(root, root)
+	oldImageRoot := image.ImageRootUrl
+	defer func() { image.ImageRootUrl = oldImageRoot }()
+	image.ImageRootUrl = filepath.Join(root, "image") + "/"
+
+	// 创建容器后镜像名被重新指向另一个镜像
+	var images []*image.Image
+	for _, content := range []string{"old", "new"} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "data"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		desc, diffID, err := image.StoreLayerFromDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := image.NewImageConfig()
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+		img, err := image.CreateImage(config, []image.Descriptor{desc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := image.TagImage(img.ID, "app:v1"); err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, img)
+	}
+	expect, err := image.LayerDirs(images[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := containerParents(&ContainerInfo{Name: "c1", Image: "app:v1", ImageID: images[0].ID})
+	if err != nil || !reflect.DeepEqual(layers, expect) {
+		t.Errorf("got layers %v, %v, expect %v", layers, err, expect)
+	}
+
+	// tar 镜像使用/root/下解压的目录
+	if err := os.Mkdir(filepath.Join(root, "busybox"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	layers, err = containerParents(&ContainerInfo{Name: "c2", Image: "busybox"})
+	if err != nil || !reflect.DeepEqual(layers, []string{filepath.Join(root, "busybox")}) {
+		t.Errorf("got layers %v, %v of tar image", layers, err)
+	}
+}
@@ -0,0 +1,126 @@
+package container
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 大小的格式, 字节数或带 k/m/g/t 单位, 如 2G
+var storageSizeRegexp = regexp.MustCompile(`^([0-9]+)([kKmMgGtT]?)[bB]?$`)
+
+// ParseStorageOpts 解析 --storage-opt 参数，目前只支持 size=[number][k|m|g|t]
+// @return 可写层的大小限制(字节)，未指定时为 0
+func ParseStorageOpts(opts []string) (int64, error) {
+	var size int64
+	for _, opt := range opts {
+		key, value, _ := strings.Cut(opt, "=")
+		if key != "size" {
+			return 0, fmt.Errorf("unsupported storage option %q, use: --storage-opt size=[number][k|m|g|t]", opt)
+		}
+		match := storageSizeRegexp.FindStringSubmatch(value)
+		if match == nil {
+			return 0, fmt.Errorf("invalid storage size %q, use: --storage-opt size=[number][k|m|g|t]", value)
+		}
+		n, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || n == 0 {
+			return 0, fmt.Errorf("invalid storage size %q", value)
+		}
+		shift := map[string]uint{"": 0, "k": 10, "m": 20, "g": 30, "t": 40}[strings.ToLower(match[2])]
+		if n > (1<<63-1)>>shift {
+			return 0, fmt.Errorf("storage size %q is too large", value)
+		}
+		size = n << shift
+	}
+	return size, nil
+}
+
+/*
+createStorage 为容器创建大小为 size 的 ext4 镜像文件作为可写层的存储，容器写满后写入会返回 ENOSPC，不会占满宿主机磁盘
+镜像文件是稀疏文件，只占用实际写入的空间
+*/
+func createStorage(containerName string, size int64) error {
+	imagePath := fmt.Sprintf(StorageUrl, containerName)
+	if err := os.MkdirAll(filepath.Dir(imagePath), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(imagePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("create storage of container %s fails: %v", containerName, err)
+	}
+	err = file.Truncate(size)
+	file.Close()
+	if err != nil {
+		os.Remove(imagePath)
+		return fmt.Errorf("create storage of container %s fails: %v", containerName, err)
+	}
+	// -m 0 不保留 root 专用的块，容器可以使用全部空间
+	if out, err := exec.Command("mkfs.ext4", "-q", "-F", "-m", "0", imagePath).CombinedOutput(); err != nil {
+		os.Remove(imagePath)
+		return fmt.Errorf("mkfs.ext4 %s fails: %v: %s", imagePath, err, strings.TrimSpace(string(out)))
+	}
+	return mountStorage(containerName)
+}
+
+/*
+mountStorage 挂载容器可写层的 ext4 镜像文件，没有镜像文件或已挂载时直接返回
+overlay 要求 upperdir 与 workdir 位于同一挂载点，因此镜像文件挂载到 WorkLayerUrl，其中的 upper 目录再绑定挂载到 WriteLayerUrl
+*/
+func mountStorage(containerName string) error {
+	imagePath := fmt.Sprintf(StorageUrl, containerName)
+	if exist, _ := PathExists(imagePath); !exist {
+		return nil
+	}
+	workURL := fmt.Sprintf(WorkLayerUrl, containerName)
+	if mounted, _ := isMountPoint(workURL); mounted {
+		return nil
+	}
+	if err := os.MkdirAll(workURL, 0700); err != nil {
+		return err
+	}
+	if out, err := exec.Command("mount", "-o", "loop", imagePath, workURL).CombinedOutput(); err != nil {
+		return fmt.Errorf("mount storage %s fails: %v: %s", imagePath, err, strings.TrimSpace(string(out)))
+	}
+	if err := bindStorage(containerName); err != nil {
+		// 卸载镜像文件，否则调用者无法删除 WorkLayerUrl
+		if unmountErr := syscall.Unmount(workURL, syscall.MNT_DETACH); unmountErr != nil {
+			logrus.Warnf("umount %s fails: %v", workURL, unmountErr)
+		}
+		return err
+	}
+	logrus.Infof("mount storage %s of container %s", imagePath, containerName)
+	return nil
+}
+
+// 在挂载的镜像文件中创建 upper、work 目录，并将 upper 绑定挂载到 WriteLayerUrl
+func bindStorage(containerName string) error {
+	upper, work := overlayDirs(containerName)
+	for _, dir := range []string{upper, work} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
+	if err := os.MkdirAll(writeURL, 0777); err != nil {
+		return err
+	}
+	if err := syscall.Mount(upper, writeURL, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind mount %s fails: %v", upper, err)
+	}
+	return nil
+}
+
+// 得到 overlay 的 upperdir 和 workdir，限制了大小的可写层位于挂载的镜像文件中
+func overlayDirs(containerName string) (string, string) {
+	workURL := fmt.Sprintf(WorkLayerUrl, containerName)
+	if exist, _ := PathExists(fmt.Sprintf(StorageUrl, containerName)); exist {
+		return filepath.Join(workURL, "upper"), filepath.Join(workURL, "work")
+	}
+	return fmt.Sprintf(WriteLayerUrl, containerName), workURL
+}
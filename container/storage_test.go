@@ -0,0 +1,26 @@
+package container
+
+import "testing"
+
+func TestParseStorageOpts(t *testing.T) {
+	for _, tt := range []struct {
+		opts []string
+		size int64
+	}{
+		{nil, 0},
+		{[]string{"size=1024"}, 1024},
+		{[]string{"size=64m"}, 64 << 20},
+		{[]string{"size=2G"}, 2 << 30},
+		{[]string{"size=1gb"}, 1 << 30},
+	} {
+		size, err := ParseStorageOpts(tt.opts)
+		if err != nil || size != tt.size {
+			t.Errorf("parse %v: got %d, %v, want %d", tt.opts, size, err, tt.size)
+		}
+	}
+	for _, opt := range []string{"size=", "size=0", "size=2x", "size=-1g", "quota=1g", "size=99999999999t"} {
+		if _, err := ParseStorageOpts([]string{opt}); err == nil {
+			t.Errorf("expect error for %q", opt)
+		}
+	}
+}
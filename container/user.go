@@ -0,0 +1,113 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	passwdFile = "/etc/passwd"
+	groupFile  = "/etc/group"
+)
+
+// setUser 在容器内切换为指定用户, user 格式为 name|uid[:group|gid]
+// 用户名和组名通过容器内的 /etc/passwd 和 /etc/group 解析
+func setUser(user string) error {
+	uid, gid, err := lookupUser(user)
+	if err != nil {
+		return err
+	}
+	// 附加组设为用户所在的组
+	groups := lookupSupplementaryGroups(user, gid)
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups fails: %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid fails: %v", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid fails: %v", err)
+	}
+	return nil
+}
+
+// 解析用户字符串得到 uid 和 gid
+func lookupUser(user string) (int, int, error) {
+	parts := strings.SplitN(user, ":", 2)
+	uid, gid := -1, -1
+
+	// 用户部分既可以是用户名也可以是数字uid
+	entry := findEntry(passwdFile, parts[0])
+	if entry != nil {
+		uid, _ = strconv.Atoi(entry[2])
+		gid, _ = strconv.Atoi(entry[3])
+	} else if id, err := strconv.Atoi(parts[0]); err == nil {
+		uid, gid = id, id
+	} else {
+		return -1, -1, fmt.Errorf("unable to find user %s", parts[0])
+	}
+
+	if len(parts) == 2 {
+		if entry := findEntry(groupFile, parts[1]); entry != nil {
+			gid, _ = strconv.Atoi(entry[2])
+		} else if id, err := strconv.Atoi(parts[1]); err == nil {
+			gid = id
+		} else {
+			return -1, -1, fmt.Errorf("unable to find group %s", parts[1])
+		}
+	}
+	return uid, gid, nil
+}
+
+// 查找用户所属的附加组
+func lookupSupplementaryGroups(user string, gid int) []int {
+	groups := []int{gid}
+	name := strings.SplitN(user, ":", 2)[0]
+	file, err := os.Open(groupFile)
+	if err != nil {
+		return groups
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 4 {
+			continue
+		}
+		for _, member := range strings.Split(fields[3], ",") {
+			if member == name {
+				if id, err := strconv.Atoi(fields[2]); err == nil && id != gid {
+					groups = append(groups, id)
+				}
+			}
+		}
+	}
+	return groups
+}
+
+// 在 passwd/group 格式的文件中按名字或id查找对应的记录
+func findEntry(path, nameOrID string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 {
+			continue
+		}
+		if fields[0] == nameOrID || fields[2] == nameOrID {
+			if path == passwdFile && len(fields) < 4 {
+				continue
+			}
+			return fields
+		}
+	}
+	return nil
+}
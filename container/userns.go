@@ -0,0 +1,301 @@
+package container
+
+import (
+	"MiniDocker/archive"
+	"MiniDocker/image"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// DefaultRemapUser --userns-remap default 使用的用户, 其从属 id 范围需在 /etc/subuid 和 /etc/subgid 中配置
+	DefaultRemapUser = "minidocker"
+	// 容器内未映射的 id 显示为 nobody
+	overflowID = 65534
+)
+
+var (
+	// SubuidFile 和 SubgidFile 宿主机上用户的从属 uid、gid 范围
+	SubuidFile = "/etc/subuid"
+	SubgidFile = "/etc/subgid"
+	// UsernsRemap 未指定 --userns-remap 时新建容器使用的映射, 由配置文件的 userns-remap 指定, 为空时不使用用户命名空间
+	UsernsRemap = ""
+	// Rootless 以 rootless 模式运行, 当前进程位于非 root 用户创建的用户命名空间中, 见 SetRootless
+	Rootless = false
+)
+
+// SetRootless 设置为 rootless 模式，overlay 的不透明目录标记改用 user.overlay.* xattr
+func SetRootless() {
+	Rootless = true
+	archive.UseUserXattr()
+}
+
+// IDMap 将容器内从 ContainerID 开始的 Size 个 id 映射到宿主机上从 HostID 开始的 id，与 uid_map 的一行对应
+type IDMap struct {
+	ContainerID int `json:"containerID"`
+	HostID      int `json:"hostID"`
+	Size        int `json:"size"`
+}
+
+// IDMappings 使用用户命名空间的容器的 uid 和 gid 映射
+type IDMappings struct {
+	UIDs []IDMap `json:"uids"`
+	GIDs []IDMap `json:"gids"`
+}
+
+// RootPair 容器内的 root 在宿主机上对应的 uid 和 gid
+func (m *IDMappings) RootPair() (int, int) {
+	return m.ToHost(0, 0)
+}
+
+// ToHost 将容器内的 uid、gid 转换为宿主机上的 id，未映射的 id 转换为 nobody
+func (m *IDMappings) ToHost(uid, gid int) (int, int) {
+	return mapID(m.UIDs, uid, true), mapID(m.GIDs, gid, true)
+}
+
+// ToContainer 将宿主机上的 uid、gid 转换为容器内的 id，未映射的 id 转换为 nobody
+func (m *IDMappings) ToContainer(uid, gid int) (int, int) {
+	return mapID(m.UIDs, uid, false), mapID(m.GIDs, gid, false)
+}
+
+func mapID(maps []IDMap, id int, toHost bool) int {
+	for _, m := range maps {
+		from, to := m.ContainerID, m.HostID
+		if !toHost {
+			from, to = to, from
+		}
+		if id >= from && id < from+m.Size {
+			return to + id - from
+		}
+	}
+	return overflowID
+}
+
+func sysProcIDMaps(maps []IDMap) []syscall.SysProcIDMap {
+	result := make([]syscall.SysProcIDMap, 0, len(maps))
+	for _, m := range maps {
+		result = append(result, syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	return result
+}
+
+/*
+LoadIDMappings 解析 --userns-remap 的值得到 id 映射
+1.为空或 host 时不使用用户命名空间，返回 nil
+2.default 使用 minidocker 用户的从属 id 范围
+3.user[:group] 使用指定用户和组(默认与用户同名)的从属 id 范围，用户和组可以是名字或数字 id
+多个范围按在文件中的顺序依次映射为容器内从 0 开始的连续 id
+*/
+func LoadIDMappings(remap string) (*IDMappings, error) {
+	if remap == "" || remap == "host" {
+		return nil, nil
+	}
+	if remap == "default" {
+		remap = DefaultRemapUser
+	}
+	user, group, ok := strings.Cut(remap, ":")
+	if !ok {
+		group = user
+	}
+	if user == "" || group == "" {
+		return nil, fmt.Errorf("invalid userns-remap %q, use: default | user[:group]", remap)
+	}
+	uids, err := readSubIDs(SubuidFile, hostIDNames("/etc/passwd", user))
+	if err != nil {
+		return nil, err
+	}
+	gids, err := readSubIDs(SubgidFile, hostIDNames("/etc/group", group))
+	if err != nil {
+		return nil, err
+	}
+	if len(uids) == 0 {
+		return nil, fmt.Errorf("no subordinate uid range for %s in %s, add a line like \"%s:100000:65536\"", user, SubuidFile, user)
+	}
+	if len(gids) == 0 {
+		return nil, fmt.Errorf("no subordinate gid range for %s in %s, add a line like \"%s:100000:65536\"", group, SubgidFile, group)
+	}
+	return &IDMappings{UIDs: uids, GIDs: gids}, nil
+}
+
+// /etc/subuid 中的用户既可以写名字也可以写数字 id, 通过宿主机的 /etc/passwd 或 /etc/group 得到两种写法
+func hostIDNames(path, nameOrID string) []string {
+	names := []string{nameOrID}
+	if entry := findEntry(path, nameOrID); entry != nil {
+		for _, name := range []string{entry[0], entry[2]} {
+			if name != nameOrID {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// 读取 /etc/subuid 或 /etc/subgid 中属于 names 的 name:start:count 行
+func readSubIDs(path string, names []string) ([]IDMap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s fails: %v", path, err)
+	}
+	defer file.Close()
+	var maps []IDMap
+	containerID := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 || !contains(names, fields[0]) {
+			continue
+		}
+		start, err1 := strconv.Atoi(fields[1])
+		count, err2 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil || start < 0 || count <= 0 {
+			return nil, fmt.Errorf("invalid line %q in %s", line, path)
+		}
+		maps = append(maps, IDMap{ContainerID: containerID, HostID: start, Size: count})
+		containerID += count
+	}
+	return maps, scanner.Err()
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+remapLayers 使用用户命名空间时，容器内的 root 只能修改属主为映射后 id 的文件
+首次使用时复制一份镜像层并按映射转换属主(chown)，缓存在 image.RemapUrl/<uid>.<gid>/ 下，之后的容器直接使用
+*/
+func remapLayers(layers []string, maps *IDMappings) ([]string, error) {
+	if maps == nil {
+		return layers, nil
+	}
+	uid, gid := maps.RootPair()
+	dir := filepath.Join(image.RemapUrl, fmt.Sprintf("%d.%d", uid, gid))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	remapped := make([]string, 0, len(layers))
+	for _, layer := range layers {
+		key, err := remapCacheKey(layer)
+		if err != nil {
+			return nil, err
+		}
+		target := filepath.Join(dir, key)
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			if err := remapLayer(layer, target, maps); err != nil {
+				return nil, fmt.Errorf("remap layer %s fails: %v", layer, err)
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		remapped = append(remapped, target)
+	}
+	return remapped, nil
+}
+
+/*
+层副本在缓存中的名字
+1.本地镜像仓库中的层以 diffID 命名，层被回收后副本由 image.GarbageCollect 一并回收
+2.RootUrl 下解压的 tar 镜像没有 diffID，以目录的路径、inode 和 ctime 命名，重新解压后不会使用旧的副本
+*/
+func remapCacheKey(layer string) (string, error) {
+	if filepath.Dir(layer) == filepath.Dir(image.LayerDir("x")) && image.ValidDigest("sha256:"+filepath.Base(layer)) == nil {
+		return filepath.Base(layer), nil
+	}
+	info, err := os.Stat(layer)
+	if err != nil {
+		return "", err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("stat %s fails", layer)
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d.%d", filepath.Clean(layer), stat.Ino, stat.Ctim.Sec, stat.Ctim.Nsec)))
+	return "dir-" + hex.EncodeToString(sum[:]), nil
+}
+
+// 将镜像层复制到临时目录并转换属主，完成后再重命名为 target，避免中断时留下不完整的层
+func remapLayer(layer, target string, maps *IDMappings) error {
+	// 以 . 开头，中断时留下的临时目录由 image.GarbageCollect 回收
+	tmp, err := os.MkdirTemp(filepath.Dir(target), "."+filepath.Base(target)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(archive.TarLayer(layer, writer))
+	}()
+	mapped := archive.MapIDs(reader, maps.ToHost)
+	err = archive.UntarLayer(mapped, tmp)
+	mapped.Close()
+	reader.Close()
+	if err != nil {
+		return err
+	}
+	// tar 流中不包含层的根目录
+	info, err := os.Stat(layer)
+	if err != nil {
+		return err
+	}
+	if err := chownToRoot(tmp, maps); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		// 其他容器已同时创建了该层
+		if _, statErr := os.Stat(target); statErr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// 将宿主机上的文件属主设为容器内的 root
+func chownToRoot(path string, maps *IDMappings) error {
+	uid, gid := maps.RootPair()
+	return os.Lchown(path, uid, gid)
+}
+
+/*
+使用用户命名空间时，容器的 init 进程在宿主机上是普通用户，需要能进入 rootfs 挂载点、hosts 等文件所在的目录
+与 docker 一致，为数据根目录和运行时目录加上其他用户的执行权限，不授予读权限
+*/
+func makeSearchable(containerName string) error {
+	infoDir := filepath.Clean(fmt.Sprintf(DefaultInfoLocation, containerName))
+	if err := os.MkdirAll(infoDir, 0755); err != nil {
+		return err
+	}
+	dirs := []string{RootUrl, filepath.Dir(fmt.Sprintf(MntUrl, containerName)), filepath.Dir(infoDir), infoDir}
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if mode := info.Mode().Perm(); mode&0111 != 0111 {
+			if err := os.Chmod(dir, mode|0111); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package container
+
+import (
+	"MiniDocker/image"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadIDMappings(t *testing.T) {
+	dir := t.TempDir()
+	oldUID, oldGID := SubuidFile, SubgidFile
+	defer func() { SubuidFile, SubgidFile = oldUID, oldGID }()
+	SubuidFile = filepath.Join(dir, "subuid")
+	SubgidFile = filepath.Join(dir, "subgid")
+	if err := os.WriteFile(SubuidFile, []byte("# comment\nminidocker:100000:65536\nalice:200000:1000\nalice:300000:1000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(SubgidFile, []byte("minidocker:100000:65536\nstaff:400000:500\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, remap := range []string{"", "host"} {
+		if m, err := LoadIDMappings(remap); m != nil || err != nil {
+			t.Errorf("remap %q: got %v, %v, want no mapping", remap, m, err)
+		}
+	}
+
+	m, err := LoadIDMappings("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	if !reflect.DeepEqual(m.UIDs, want) || !reflect.DeepEqual(m.GIDs, want) {
+		t.Errorf("default: got %+v", m)
+	}
+	if uid, gid := m.RootPair(); uid != 100000 || gid != 100000 {
+		t.Errorf("root pair: got %d:%d", uid, gid)
+	}
+
+	// 多个范围依次映射为连续的容器内 id
+	m, err = LoadIDMappings("alice:staff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantUIDs := []IDMap{{ContainerID: 0, HostID: 200000, Size: 1000}, {ContainerID: 1000, HostID: 300000, Size: 1000}}
+	if !reflect.DeepEqual(m.UIDs, wantUIDs) {
+		t.Errorf("alice uids: got %+v", m.UIDs)
+	}
+	for _, tt := range []struct{ container, host int }{{0, 200000}, {999, 200999}, {1000, 300000}, {1500, 300500}} {
+		if uid, _ := m.ToHost(tt.container, 0); uid != tt.host {
+			t.Errorf("to host %d: got %d, want %d", tt.container, uid, tt.host)
+		}
+		if uid, _ := m.ToContainer(tt.host, 400000); uid != tt.container {
+			t.Errorf("to container %d: got %d, want %d", tt.host, uid, tt.container)
+		}
+	}
+	if uid, gid := m.ToContainer(0, 0); uid != overflowID || gid != overflowID {
+		t.Errorf("unmapped ids: got %d:%d", uid, gid)
+	}
+
+	// 组默认与用户同名
+	if _, err := LoadIDMappings("alice"); err == nil {
+		t.Errorf("expect error for alice without subordinate gids")
+	}
+	if _, err := LoadIDMappings("nobody-here"); err == nil {
+		t.Errorf("expect error for user without subordinate uids")
+	}
+}
+
+func TestRemapCacheKey(t *testing.T) {
+	oldRoot := image.ImageRootUrl
+	defer func() { image.ImageRootUrl = oldRoot }()
+	image.ImageRootUrl = t.TempDir()
+	diffID := "sha256:" + strings.Repeat("0a", 32)
+	if err := os.MkdirAll(image.LayerDir(diffID), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := remapCacheKey(image.LayerDir(diffID)); err != nil || key != strings.Repeat("0a", 32) {
+		t.Errorf("layer in image store: got %q, %v, want its diff id", key, err)
+	}
+
+	// 重新解压的 tar 镜像目录使用新的副本
+	legacy := filepath.Join(t.TempDir(), "busybox")
+	if err := os.Mkdir(legacy, 0755); err != nil {
+		t.Fatal(err)
+	}
+	first, err := remapCacheKey(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(legacy); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(legacy, 0755); err != nil {
+		t.Fatal(err)
+	}
+	second, err := remapCacheKey(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(first, "dir-") || first == second {
+		t.Errorf("legacy dir keys %q and %q, expect distinct dir- keys", first, second)
+	}
+}
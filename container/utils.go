@@ -55,6 +55,11 @@ func verifyMountNamespace() error {
 
 	// 获取父进程的挂载命名空间 ID
 	parentMountNs, err := os.Readlink("/proc/1/ns/mnt")
+	// rootless 模式下容器位于非 root 用户的用户命名空间中，无权读取宿主机 init 进程的命名空间
+	if os.IsPermission(err) {
+		logrus.Infof("挂载命名空间: %s, 无权读取宿主机的挂载命名空间", selfMountNs)
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("获取父进程挂载命名空间失败: %v", err)
 	}
@@ -1,37 +1,89 @@
 package container
 
 import (
+	"MiniDocker/archive"
+	"MiniDocker/image"
+	"MiniDocker/trust"
+	"MiniDocker/volume"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
+	"syscall"
 )
 
-// NewWorkSpace 创建容器文件系统
-func NewWorkSpace(imageName, containerName, volume string) {
+// NewWorkSpace 创建容器文件系统, storageSize 大于 0 时限制可写层的大小
+// imageID 为本地镜像仓库中的镜像ID，由调用者解析镜像名得到，为空时使用/root/下的tar镜像 imageName
+// 由 StorageDriver 指定的存储驱动基于镜像各层准备容器的根文件系统，idMappings 不为空时使用转换了属主的镜像层
+func NewWorkSpace(imageID, imageName, containerName string, mounts []Mount, storageSize int64, idMappings *IDMappings) error {
 	// 创建只读、读写层并挂载到/root/mnt
-	CreateReadOnlyLayer(imageName)
+	// 本地镜像仓库中的镜像各层已解压，只有/root/下的tar镜像需要解压
+	if imageID == "" {
+		if err := CreateReadOnlyLayer(imageName); err != nil {
+			return err
+		}
+	}
 	CreateWriteLayer(containerName)
-	CreateMountPoint(containerName, imageName)
+	if storageSize > 0 {
+		if err := createStorage(containerName, storageSize); err != nil {
+			DeleteWriteLayer(containerName, StorageDriver)
+			return err
+		}
+	}
+	snapshotter, err := GetSnapshotter(StorageDriver)
+	if err != nil {
+		DeleteWriteLayer(containerName, StorageDriver)
+		return err
+	}
+	layers, err := imageLayers(imageID, imageName)
+	if err == nil {
+		layers, err = remapLayers(layers, idMappings)
+	}
+	var snapshotMounts []SnapshotMount
+	if err == nil {
+		snapshotMounts, err = snapshotter.Prepare(containerName, layers)
+	}
+	// 可写层的根目录即容器的根目录，属主为容器内的 root
+	if err == nil && idMappings != nil {
+		err = chownToRoot(fmt.Sprintf(WriteLayerUrl, containerName), idMappings)
+	}
+	if err == nil {
+		err = CreateMountPoint(containerName, snapshotMounts)
+	}
+	if err != nil {
+		DeleteWorkSpace(nil, containerName, StorageDriver)
+		return fmt.Errorf("prepare rootfs with %s storage driver fails: %v", StorageDriver, err)
+	}
 
-	// 判断volume是否要挂载数据卷
-	if volume != "" {
-		volumeURLs := volumeUrlExtract(volume)
-		length := len(volumeURLs)
-		if length == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
-			MountVolume(volumeURLs, containerName)
-			logrus.Infof("%q", volumeURLs)
-		} else {
-			logrus.Infof("Volume parameter input is not correct.")
+	// 准备数据卷的宿主机目录，绑定挂载在容器的挂载命名空间中进行
+	// 命名数据卷解析为宿主机目录后写回 mounts，随容器信息一起记录
+	for i := range mounts {
+		if err := MountVolume(&mounts[i], containerName); err != nil {
+			DeleteWorkSpace(mounts[:i], containerName, StorageDriver)
+			return err
+		}
+		// 新建的命名数据卷属于宿主机的 root，交给容器内的 root
+		if idMappings != nil && mounts[i].Type == MountTypeVolume {
+			if err := chownVolume(mounts[i].Source, idMappings); err != nil {
+				DeleteWorkSpace(mounts[:i+1], containerName, StorageDriver)
+				return err
+			}
 		}
 	}
+	return nil
 }
 
 // CreateReadOnlyLayer 新建 busybox 文件夹，将 busybox.tar 解压到 busybox 目录下，作为容器的只读层
-// 解压tar格式的镜像文件作为只读层
-func CreateReadOnlyLayer(imageName string) {
+// 解压tar格式的镜像文件作为只读层, 支持gzip和zstd压缩
+// tar格式的镜像没有签名，签名策略要求校验签名时拒绝使用
+func CreateReadOnlyLayer(imageName string) error {
+	policy, err := trust.LoadPolicy()
+	if err != nil {
+		return err
+	}
+	if policy.RequireSignature {
+		return fmt.Errorf("image %v is an unsigned tar archive, policy %v requires signed images", imageName, trust.PolicyPath)
+	}
 	unTarFolderUrl := filepath.Join(RootUrl, imageName)
 	imageUrl := filepath.Join(RootUrl, imageName) + ".tar"
 	logrus.Infof("unTarfolder url: %v", unTarFolderUrl)
@@ -40,16 +92,30 @@ func CreateReadOnlyLayer(imageName string) {
 	if err != nil {
 		logrus.Infof("Fail to judge whether dir %v exists: %v", exist, err)
 	}
-	if exist == false {
-		// 新建 镜像只读 目录
-		if err := os.MkdirAll(unTarFolderUrl, 0777); err != nil {
-			logrus.Errorf("Mkdir dir %v fails: %v", unTarFolderUrl, err)
-		}
-		// 解压 [镜像].tar
-		if _, err := exec.Command("tar", "-xvf", imageUrl, "-C", unTarFolderUrl).CombinedOutput(); err != nil {
-			logrus.Errorf("unTar dit %v fails: %v", unTarFolderUrl, err)
-		}
+	if exist {
+		return nil
+	}
+	file, err := os.Open(imageUrl)
+	if err != nil {
+		return fmt.Errorf("open image %v fails: %v", imageUrl, err)
+	}
+	defer file.Close()
+	stream, err := archive.DecompressStream(file)
+	if err != nil {
+		return fmt.Errorf("read image %v fails: %v", imageUrl, err)
+	}
+	defer stream.Close()
+
+	// 新建 镜像只读 目录
+	if err := os.MkdirAll(unTarFolderUrl, 0777); err != nil {
+		return fmt.Errorf("mkdir dir %v fails: %v", unTarFolderUrl, err)
+	}
+	// 解压 [镜像].tar, 失败时删除解压了一半的目录, 避免下次被当作完整的镜像
+	if err := archive.Untar(stream, unTarFolderUrl); err != nil {
+		_ = os.RemoveAll(unTarFolderUrl)
+		return fmt.Errorf("untar image %v fails: %v", imageUrl, err)
 	}
+	return nil
 }
 
 // CreateWriteLayer 为容器创建 writeLayer 文件夹作为容器 唯一 可写层
@@ -60,67 +126,61 @@ func CreateWriteLayer(containerName string) {
 	}
 }
 
-// CreateMountPoint 新建 mnt 文件夹作为挂载点，并将 writeLayer 目录和 busybox(image) 目录 mount 到 mnt 目录下
-// ubuntu22.04 内核不支持AUFS，使用OverLay代替
-func CreateMountPoint(containerName, imageName string) {
+// CreateMountPoint 新建 mnt 文件夹作为挂载点，并将存储驱动准备好的快照挂载到 mnt 目录下
+func CreateMountPoint(containerName string, snapshotMounts []SnapshotMount) error {
 	// 创建mnt文件夹作为挂载点
 	mntUrl := fmt.Sprintf(MntUrl, containerName)
 	if err := os.MkdirAll(mntUrl, 0777); err != nil {
-		logrus.Errorf("Mkdir dir %v fails: %v", mntUrl, err)
+		return fmt.Errorf("mkdir dir %v fails: %v", mntUrl, err)
 	}
+	return mountSnapshot(snapshotMounts, mntUrl)
+}
 
-	// 创建临时工作文件夹
-	workURL := fmt.Sprintf(WorkLayerUrl, containerName)
-	if err := os.MkdirAll(workURL, 0777); err != nil {
-		logrus.Errorf("Mkdir dir %v fails: %v", workURL, err)
+/*
+imageLayers 得到镜像的只读层目录(由上到下)
+1.imageID 不为空时为本地镜像仓库中的镜像，由多个层叠加而成，按ID查找，不受镜像名被重新指向的影响
+2.否则为/root/下解压的tar镜像 imageName；之前版本记录的容器没有镜像ID，没有解压的目录时按镜像名在本地镜像仓库中查找
+*/
+func imageLayers(imageID, imageName string) ([]string, error) {
+	if imageID == "" {
+		dir := filepath.Join(RootUrl, imageName)
+		if exist, _ := PathExists(dir); exist {
+			return []string{dir}, nil
+		}
+		img, err := image.GetImage(imageName)
+		if err != nil {
+			return nil, fmt.Errorf("no such image: %s", imageName)
+		}
+		imageID = img.ID
 	}
-
-	// 将writeLayer目录和image目录mount到mnt目录下
-	// 改用OverLay
-	tmpWriteLayer := fmt.Sprintf(WriteLayerUrl, containerName)
-	tmpImageLocation := filepath.Join(RootUrl, imageName)
-	dirs := fmt.Sprintf(
-		"lowerdir=%s,upperdir=%s,workdir=%s",
-		tmpImageLocation,
-		tmpWriteLayer,
-		workURL,
-	)
-	cmd := exec.Command("mount", "-t", "overlay", "-o", dirs, "overlay", mntUrl)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	// 启动命令并阻塞等待
-	if err := cmd.Run(); err != nil {
-		logrus.Errorf("%v", err)
+	img, err := image.GetImageByID(imageID)
+	if err != nil {
+		return nil, fmt.Errorf("get image %s fails: %v", imageID, err)
 	}
+	return image.LayerDirs(img)
 }
 
 // DeleteWorkSpace Docker 删除容器时将容器对应的writeLayer和Container-initLayer删除，
 // 从而保留镜像所有内容，
 // 简化操作，在容器退出时便删除writeLayer和work
-func DeleteWorkSpace(volume, containerName string) {
-	if volume != "" {
-		volumeURLs := volumeUrlExtract(volume)
-		length := len(volumeURLs)
-		if length == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
-			DeleteMountPointWithVolume(volumeURLs, containerName)
-		} else {
-			DeleteMountPoint(containerName)
-		}
-	} else {
-		DeleteMountPoint(containerName)
+// 数据卷挂载在容器的挂载命名空间中，随容器进程退出自动卸载，只需通知命名数据卷的驱动
+// storageDriver 为创建容器时使用的存储驱动
+func DeleteWorkSpace(mounts []Mount, containerName, storageDriver string) {
+	for _, mount := range mounts {
+		UnmountVolume(mount, containerName)
 	}
-	DeleteWriteLayer(containerName)
+	DeleteMountPoint(containerName)
+	DeleteWriteLayer(containerName, storageDriver)
 }
 
-// DeleteMountPoint 删除为挂载数据卷的容器文件系统，先unmount mnt目录，后删除mnt目录
+// DeleteMountPoint 删除容器文件系统，先unmount mnt目录，后删除mnt目录
+// 未记录的挂载点(如旧版本在宿主机上挂载的数据卷)一并卸载, 卸载失败时不删除mnt目录，避免删除数据卷中的文件
 func DeleteMountPoint(containerName string) {
 	mntURL := fmt.Sprintf(MntUrl, containerName)
 	logrus.Infof("mntURL: %v", mntURL)
-	cmd := exec.Command("umount", mntURL)
-	//cmd.Stdout = os.Stdout
-	//cmd.Stderr = os.Stderr
-	if _, err := cmd.CombinedOutput(); err != nil {
+	if err := UnmountAll(mntURL); err != nil {
 		logrus.Errorf("umount mnt fails: %v", err)
+		return
 	}
 	// delete mnt/
 	if err := os.RemoveAll(mntURL); err != nil {
@@ -128,93 +188,100 @@ func DeleteMountPoint(containerName string) {
 	}
 }
 
-// DeleteMountPointWithVolume 删除有挂载 volume 数据卷的容器文件系统
-func DeleteMountPointWithVolume(volumeURLs []string, containerName string) {
-	// 卸载容器里volume挂载点的文件系统
-	mntURL := fmt.Sprintf(MntUrl, containerName)
-	containerUrl := filepath.Join(mntURL, volumeURLs[1])
-	cmd := exec.Command("umount", containerUrl)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		logrus.Errorf("umount volume dir: %v fails: %v", containerUrl, err)
-	}
-	// 卸载整个容器文件系统的挂载点
-	cmd = exec.Command("umount", mntURL)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		logrus.Errorf("remove mnt dir: %v fails: %v", mntURL, err)
-	}
-	// 删除容器文件系统的挂载点
-	if err := os.RemoveAll(mntURL); err != nil {
-		logrus.Errorf("remove mnt dir: %v fails: %v", mntURL, err)
+// DeleteWriteLayer 由存储驱动删除容器的快照，即抹去容器对文件系统的更改
+func DeleteWriteLayer(containerName, storageDriver string) {
+	snapshotter, err := GetSnapshotter(storageDriver)
+	if err != nil {
+		logrus.Errorf("%v", err)
+		return
 	}
-	// 删除 volume 工作的临时目录
-	tmpWorkDir := filepath.Join(volumeURLs[0], "..", ".volumeWork")
-	if err := os.RemoveAll(tmpWorkDir); err != nil {
-		logrus.Infof("remove volume tmpwork dir: %v fails: %v", mntURL, err)
+	if err := snapshotter.Remove(containerName); err != nil {
+		logrus.Errorf("remove snapshot of container %v fails: %v", containerName, err)
 	}
 }
 
-// DeleteWriteLayer 删除writeLayer目录和临时work目录，即抹去容器对文件系统的更改
-func DeleteWriteLayer(containerName string) {
-	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
-	if err := os.RemoveAll(writeURL); err != nil {
-		logrus.Errorf("remove writeLayer dir: %v fails: %v", writeURL, err)
+/*
+MountVolume 在宿主机上准备数据卷
+1.命名数据卷不存在时通过驱动创建，并由驱动挂载得到宿主机目录，数据卷为空时复制镜像中对应目录的内容
+2.创建宿主机文件目录 (-v 指定的目录不存在时自动创建)
+3.按 z/Z 选项设置 SELinux 标签
+绑定挂载由容器 init 进程在 pivot_root 前完成，见 mountVolumes
+*/
+func MountVolume(mount *Mount, containerName string) error {
+	// tmpfs 没有宿主机目录
+	if mount.Type == MountTypeTmpfs {
+		return nil
+	}
+	if mount.Type == MountTypeVolume {
+		vol, _, err := volume.Create(mount.Name, mount.Driver, mount.VolumeOptions, nil)
+		if err != nil {
+			return fmt.Errorf("create volume %v of container %v fails: %v", mount.Name, containerName, err)
+		}
+		mount.Driver = vol.Driver
+		if mount.Source, err = vol.Mount(containerName); err != nil {
+			return fmt.Errorf("mount volume %v of container %v fails: %v", mount.Name, containerName, err)
+		}
+		if err := copyImageContent(containerName, mount); err != nil {
+			return fmt.Errorf("copy image content to volume %v fails: %v", mount.Name, err)
+		}
 	}
-	workURL := fmt.Sprintf(WorkLayerUrl, containerName)
-	if err := os.RemoveAll(workURL); err != nil {
-		logrus.Errorf("remove tmpWork dir: %v fails: %v", workURL, err)
+	if _, err := os.Stat(mount.Source); os.IsNotExist(err) {
+		if err := os.MkdirAll(mount.Source, 0755); err != nil {
+			return fmt.Errorf("mkdir volume dir %v of container %v fails: %v", mount.Source, containerName, err)
+		}
 	}
+	if mount.Relabel != "" {
+		if err := relabel(mount.Source); err != nil {
+			return fmt.Errorf("relabel %v fails: %v", mount.Source, err)
+		}
+	}
+	return nil
 }
 
-// 解析 volume 字符串
-func volumeUrlExtract(volume string) []string {
-	return strings.Split(volume, ":")
+// 数据卷目录的属主在容器内没有映射(如宿主机的 root)时改为容器内的 root，已属于映射范围内用户的目录保持不变
+func chownVolume(source string, maps *IDMappings) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if uid, _ := maps.ToContainer(int(stat.Uid), int(stat.Gid)); uid != overflowID {
+		return nil
+	}
+	return chownToRoot(source, maps)
 }
 
-/*
-MountVolume 挂载数据卷进容器
-1.读取宿主机文件目录 URL，创建宿主机文件目录 (/root/${parentURL})
-2.读取容器挂载点 URL，在容器文件系统里创建挂载点 (/root/mnt/${containerURL})
-3.把宿主机文件目录挂载到容器挂载点
-*/
-func MountVolume(volumeURLs []string, containerName string) {
-	// create host file catalog
-	parentUrl := volumeURLs[0]
-	if err := os.MkdirAll(parentUrl, 0777); err != nil {
-		logrus.Infof("Mkdir parent dir: %v error: %v", parentUrl, err)
-	}
-	// create mount point in container file system
-	containerUrl := volumeURLs[1]
-	mntURL := fmt.Sprintf(MntUrl, containerName)
-	containerVolumeURL := filepath.Join(mntURL, containerUrl)
-	if err := os.MkdirAll(containerVolumeURL, 0777); err != nil {
-		logrus.Infof("Mkdir container dir: %v error: %v", containerVolumeURL, err)
+// UnmountVolume 容器不再使用命名数据卷时通知驱动，绑定挂载的宿主机目录不需要处理
+func UnmountVolume(mount Mount, containerName string) {
+	if mount.Type != MountTypeVolume {
+		return
 	}
-
-	// 为overlay挂载创建必须的lower和work目录，确保work目录为空
-	tmpWorkDir := filepath.Join(parentUrl, "..", ".volumeWork")
-	lowerDir := filepath.Join(tmpWorkDir, ".emptyLower")
-	logrus.Infof("lowerDir: %v", lowerDir)
-	workDir := filepath.Join(tmpWorkDir, ".work")
-	if err := os.MkdirAll(lowerDir, 0777); err != nil {
-		logrus.Errorf("Mkdir .lower dir: %v fails: %v", lowerDir, err)
-	}
-	// 确保work目录是空的
-	if err := os.RemoveAll(workDir); err == nil {
-		if err := os.MkdirAll(workDir, 0777); err != nil {
-			logrus.Errorf("Mkdir .work dir: %v fails :%v", workDir, err)
-		}
+	vol, err := volume.Get(mount.Name)
+	if err != nil {
+		logrus.Warnf("unmount volume %v fails: %v", mount.Name, err)
+		return
+	}
+	if err := vol.Unmount(containerName); err != nil {
+		logrus.Errorf("unmount volume %v fails: %v", mount.Name, err)
 	}
+}
 
-	// mount host file catalog to mount point in container
-	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lowerDir, parentUrl, workDir)
-	cmd := exec.Command("mount", "-t", "overlay", "-o", options, "overlay", containerVolumeURL)
-	//cmd.Stdout = os.Stdout
-	//cmd.Stderr = os.Stderr
-	if _, err := cmd.CombinedOutput(); err != nil {
-		logrus.Errorf("Mount volune fails: %v", err)
+// 与 docker 一致，命名数据卷为空时将镜像中挂载点目录的内容(包括属主和权限)复制到数据卷中
+func copyImageContent(containerName string, mount *Mount) error {
+	entries, err := os.ReadDir(mount.Source)
+	if err != nil || len(entries) > 0 {
+		return err
+	}
+	src, err := SecureJoin(fmt.Sprintf(MntUrl, containerName), mount.Destination)
+	if err != nil {
+		return err
+	}
+	if stat, err := os.Stat(src); err != nil || !stat.IsDir() {
+		return nil
 	}
+	logrus.Infof("copy %v to volume %v", mount.Destination, mount.Name)
+	return archive.CopyPath(src, mount.Source, -1, -1)
 }
@@ -0,0 +1,452 @@
+package dockerCommand
+
+import (
+	"MiniDocker/archive"
+	"MiniDocker/container"
+	"MiniDocker/image"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 构建过程的状态
+type builder struct {
+	contextDir string // 构建上下文目录，COPY/ADD 的源文件只能来自该目录
+	noCache    bool   // 是否禁用构建缓存
+	cmdSet     bool   // 本次构建中是否设置过CMD，用于决定 ENTRYPOINT 是否清空继承的CMD
+}
+
+// Build 根据 Dockerfile 构建镜像
+// 每条指令生成一个中间镜像，RUN 指令在临时容器中执行后将容器的可写层提交为新的一层
+func Build(contextDir, dockerfile, imageName string, noCache bool) error {
+	if dockerfile == "" {
+		dockerfile = filepath.Join(contextDir, "Dockerfile")
+	}
+	instructions, err := image.ParseDockerfileFromPath(dockerfile)
+	if err != nil {
+		return fmt.Errorf("parse dockerfile %s fails: %v", dockerfile, err)
+	}
+	contextDir, err = filepath.Abs(contextDir)
+	if err != nil {
+		return err
+	}
+	b := &builder{contextDir: contextDir, noCache: noCache}
+
+	var current *image.Image
+	for i, inst := range instructions {
+		fmt.Printf("Step %d/%d : %s\n", i+1, len(instructions), inst.Original)
+		if inst.Cmd == "FROM" {
+			if current, err = getBaseImage(inst.Args[0]); err != nil {
+				return err
+			}
+			fmt.Printf(" ---> %s\n", current.ShortID())
+			continue
+		}
+
+		// COPY/ADD 的缓存还需考虑源文件内容是否变化
+		contentDigest := ""
+		if inst.Cmd == "COPY" || inst.Cmd == "ADD" {
+			if contentDigest, err = b.hashSources(inst.Args[:len(inst.Args)-1], current.Config.Config.Env); err != nil {
+				return fmt.Errorf("step %d: %v", i+1, err)
+			}
+		}
+		key := image.BuildCacheKey(current.ID, inst.Original, contentDigest)
+		if !b.noCache {
+			if cached, ok := image.LookupBuildCache(key); ok {
+				if inst.Cmd == "CMD" {
+					b.cmdSet = true
+				}
+				fmt.Printf(" ---> Using cache\n ---> %s\n", cached.ShortID())
+				current = cached
+				continue
+			}
+		}
+
+		next, err := b.buildStep(current, inst)
+		if err != nil {
+			return fmt.Errorf("step %d (%s) fails: %v", i+1, inst.Original, err)
+		}
+		if err := image.StoreBuildCache(key, next.ID); err != nil {
+			logrus.Warnf("store build cache fails: %v", err)
+		}
+		current = next
+		fmt.Printf(" ---> %s\n", current.ShortID())
+	}
+
+	if imageName != "" {
+		if err := image.TagImage(current.ID, imageName); err != nil {
+			return fmt.Errorf("tag image %s fails: %v", imageName, err)
+		}
+	}
+	fmt.Printf("Successfully built %s\n", current.ShortID())
+	return nil
+}
+
+// 得到 FROM 指定的基础镜像
+func getBaseImage(name string) (*image.Image, error) {
+	// scratch 为不含任何层的空镜像，创建时间置空使其ID固定，以便缓存生效
+	if name == "scratch" {
+		config := image.NewImageConfig()
+		config.Created = ""
+		return image.CreateImage(config, nil)
+	}
+	return getOrImportImage(name)
+}
+
+// 执行一条指令，在父镜像的基础上生成新镜像
+func (b *builder) buildStep(parent *image.Image, inst *image.Instruction) (*image.Image, error) {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	config := parent.Config.Clone()
+	config.Created = now
+	layers := append([]image.Descriptor{}, parent.Manifest.Layers...)
+	history := image.History{Created: now, CreatedBy: inst.Original, EmptyLayer: true}
+
+	switch inst.Cmd {
+	case "RUN", "COPY", "ADD":
+		var desc image.Descriptor
+		var diffID string
+		var err error
+		if inst.Cmd == "RUN" {
+			desc, diffID, err = b.runContainer(parent, config, inst)
+		} else {
+			desc, diffID, err = b.copyFiles(parent, config, inst)
+		}
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, desc)
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+		history.EmptyLayer = false
+	default:
+		if err := applyConfigInstruction(config, inst, b.cmdSet); err != nil {
+			return nil, err
+		}
+		if inst.Cmd == "CMD" {
+			b.cmdSet = true
+		}
+	}
+
+	config.History = append(config.History, history)
+	return image.CreateImage(config, layers)
+}
+
+// 将不产生新层的指令应用到镜像配置，cmdSet 表示之前的指令中是否设置过CMD
+func applyConfigInstruction(config *image.ImageConfig, inst *image.Instruction, cmdSet bool) error {
+	// 指令中的变量使用执行该指令前的环境变量替换
+	env := append([]string{}, config.Config.Env...)
+	switch inst.Cmd {
+	case "ENV":
+		for _, kv := range inst.Args {
+			pair := strings.SplitN(kv, "=", 2)
+			config.Config.Env = image.SetEnv(config.Config.Env, pair[0]+"="+image.Expand(pair[1], env))
+		}
+	case "WORKDIR":
+		dir := image.Expand(inst.Args[0], env)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join("/", config.Config.WorkingDir, dir)
+		}
+		config.Config.WorkingDir = filepath.Clean(dir)
+	case "CMD":
+		config.Config.Cmd = commandArgs(inst)
+	case "ENTRYPOINT":
+		config.Config.Entrypoint = commandArgs(inst)
+		// 与docker一致，设置ENTRYPOINT会清空从基础镜像继承的CMD
+		if !cmdSet {
+			config.Config.Cmd = nil
+		}
+	case "USER":
+		config.Config.User = image.Expand(inst.Args[0], env)
+	case "LABEL":
+		if config.Config.Labels == nil {
+			config.Config.Labels = map[string]string{}
+		}
+		for _, kv := range inst.Args {
+			pair := strings.SplitN(kv, "=", 2)
+			config.Config.Labels[image.Expand(pair[0], env)] = image.Expand(pair[1], env)
+		}
+	case "EXPOSE":
+		if config.Config.ExposedPorts == nil {
+			config.Config.ExposedPorts = map[string]struct{}{}
+		}
+		for _, port := range inst.Args {
+			port = image.Expand(port, env)
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			config.Config.ExposedPorts[port] = struct{}{}
+		}
+	default:
+		return fmt.Errorf("unsupported instruction %s", inst.Cmd)
+	}
+	return nil
+}
+
+// 得到 RUN/CMD/ENTRYPOINT 的命令，shell 格式使用 /bin/sh -c 执行
+func commandArgs(inst *image.Instruction) []string {
+	if inst.JSON {
+		return append([]string{}, inst.Args...)
+	}
+	return []string{"/bin/sh", "-c", inst.Args[0]}
+}
+
+// buildContainerPrefix RUN 指令使用的临时容器的名字前缀，这些容器不记录容器信息
+const buildContainerPrefix = "build-"
+
+// 在基于父镜像的临时容器中执行 RUN 指令，并将容器的可写层存为镜像层
+func (b *builder) runContainer(parent *image.Image, config *image.ImageConfig, inst *image.Instruction) (image.Descriptor, string, error) {
+	containerName := buildContainerPrefix + randStringBytes(10)
+	// 构建容器的输出直接打印到终端，与 docker build 一致可以访问网络(apt-get、pip install 等)，使用宿主机的网络命名空间
+	process, writePipe, err := container.NewProcess(true, nil, containerName, parent.ID, parent.ID, config.Config.Env, 0, nil, true)
+	defer container.DeleteWorkSpace(nil, containerName, container.StorageDriver)
+	if err != nil {
+		return image.Descriptor{}, "", fmt.Errorf("create build container fails: %v", err)
+	}
+	// 与 run --net host 一致，挂载宿主机的 DNS 配置，镜像中没有可用的 resolv.conf 时也能解析域名
+	defer container.DeleteContainerInfo(containerName)
+	etcMounts, err := container.SetUpEtcFiles(containerName, containerName, nil, &container.EtcConfig{}, true)
+	if err != nil {
+		writePipe.Close()
+		return image.Descriptor{}, "", fmt.Errorf("set up etc files of build container fails: %v", err)
+	}
+	created := missingMountTargets(fmt.Sprintf(container.MntUrl, containerName), etcMounts)
+	process.Stdin = nil
+	if err := process.Start(); err != nil {
+		writePipe.Close()
+		return image.Descriptor{}, "", fmt.Errorf("start build container fails: %v", err)
+	}
+	sendInitCommand(&container.InitConfig{
+		Cmd:      commandArgs(inst),
+		WorkDir:  config.Config.WorkingDir,
+		User:     config.Config.User,
+		Mounts:   etcMounts,
+		Hostname: containerName,
+	}, writePipe)
+	if err := process.Wait(); err != nil {
+		return image.Descriptor{}, "", fmt.Errorf("command %q returned: %v", strings.Join(commandArgs(inst), " "), err)
+	}
+	// 目录下还有 RUN 创建的文件时删除失败，保留
+	for _, path := range created {
+		os.Remove(path)
+	}
+	return storeContainerLayer(&container.ContainerInfo{Name: containerName, Image: parent.ID, ImageID: parent.ID, StorageDriver: container.StorageDriver})
+}
+
+// 得到镜像中不存在、由 init 进程为 mounts 创建的挂载点及其父目录(由下到上)，这些文件不属于镜像层，提交前删除
+func missingMountTargets(root string, mounts []container.Mount) []string {
+	var missing []string
+	for _, mount := range mounts {
+		target, err := container.ResolvePath(root, mount.Destination, true)
+		if err != nil {
+			continue
+		}
+		for path := target; path != filepath.Clean(root); path = filepath.Dir(path) {
+			if _, err := os.Lstat(path); err == nil {
+				break
+			}
+			missing = append(missing, path)
+		}
+	}
+	return missing
+}
+
+// 将构建上下文中的文件复制到临时目录，并将该目录存为镜像层
+// ADD 会将本地的 tar 包解压到目标目录
+func (b *builder) copyFiles(parent *image.Image, config *image.ImageConfig, inst *image.Instruction) (image.Descriptor, string, error) {
+	env := config.Config.Env
+	srcs := inst.Args[:len(inst.Args)-1]
+	dest := image.Expand(inst.Args[len(inst.Args)-1], env)
+	// 目标以'/'结尾或有多个源文件时，目标为目录
+	destIsDir := strings.HasSuffix(dest, "/") || len(srcs) > 1
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join("/", config.Config.WorkingDir, dest)
+	}
+	dest = filepath.Clean(dest)
+
+	uid, gid, err := parseChown(inst.Flags["chown"])
+	if err != nil {
+		return image.Descriptor{}, "", err
+	}
+	stageDir, err := os.MkdirTemp("", "minidocker-build-")
+	if err != nil {
+		return image.Descriptor{}, "", err
+	}
+	defer os.RemoveAll(stageDir)
+
+	layerDirs, err := image.LayerDirs(parent)
+	if err != nil {
+		return image.Descriptor{}, "", err
+	}
+	for _, src := range srcs {
+		matches, err := b.contextPaths(image.Expand(src, env))
+		if err != nil {
+			return image.Descriptor{}, "", err
+		}
+		for _, srcPath := range matches {
+			info, err := os.Stat(srcPath)
+			if err != nil {
+				return image.Descriptor{}, "", err
+			}
+			target := dest
+			if !info.IsDir() && (destIsDir || len(matches) > 1) {
+				target = filepath.Join(dest, filepath.Base(srcPath))
+			}
+			// 父目录的属性沿用镜像中已有的目录，避免覆盖如/tmp的权限
+			parentDir := filepath.Dir(target)
+			if info.IsDir() || (inst.Cmd == "ADD" && isTarArchive(srcPath)) {
+				parentDir = target
+			}
+			if err := mkdirWithImageAttrs(stageDir, parentDir, layerDirs); err != nil {
+				return image.Descriptor{}, "", err
+			}
+
+			if inst.Cmd == "ADD" && !info.IsDir() && isTarArchive(srcPath) {
+				if err := extractTarArchive(srcPath, filepath.Join(stageDir, target)); err != nil {
+					return image.Descriptor{}, "", fmt.Errorf("extract %s fails: %v", src, err)
+				}
+				continue
+			}
+			if err := archive.CopyPath(srcPath, filepath.Join(stageDir, target), uid, gid); err != nil {
+				return image.Descriptor{}, "", fmt.Errorf("copy %s fails: %v", src, err)
+			}
+		}
+	}
+	return image.StoreLayerFromDir(stageDir)
+}
+
+// 得到构建上下文中匹配的文件，路径限制在上下文目录内
+func (b *builder) contextPaths(src string) ([]string, error) {
+	pattern := filepath.Join(b.contextDir, filepath.Clean("/"+src))
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: no such file or directory in build context", src)
+	}
+	return matches, nil
+}
+
+// 计算 COPY/ADD 源文件的摘要，源文件的路径、权限和内容发生变化都会使缓存失效
+func (b *builder) hashSources(srcs []string, env []string) (string, error) {
+	hash := sha256.New()
+	for _, src := range srcs {
+		matches, err := b.contextPaths(image.Expand(src, env))
+		if err != nil {
+			return "", err
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			err := filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				relPath, _ := filepath.Rel(b.contextDir, path)
+				fmt.Fprintf(hash, "%s\x00%v\x00%d\x00", relPath, info.Mode(), info.Size())
+				if !info.Mode().IsRegular() {
+					return nil
+				}
+				file, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				_, err = io.Copy(hash, file)
+				return err
+			})
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// 在暂存目录中创建目录，目录在镜像中已存在时沿用镜像中的权限和属主
+func mkdirWithImageAttrs(stageDir, dir string, layerDirs []string) error {
+	current := "/"
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		current = filepath.Join(current, part)
+		stagePath := filepath.Join(stageDir, current)
+		if exist, _ := container.PathExists(stagePath); exist {
+			continue
+		}
+		mode, uid, gid := os.FileMode(0755), 0, 0
+		for _, layerDir := range layerDirs {
+			if info, err := os.Lstat(filepath.Join(layerDir, current)); err == nil {
+				if info.IsDir() {
+					mode = info.Mode().Perm() | info.Mode()&os.ModeSticky
+					if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+						uid, gid = int(stat.Uid), int(stat.Gid)
+					}
+				}
+				break
+			}
+		}
+		if err := os.Mkdir(stagePath, mode); err != nil {
+			return err
+		}
+		if err := os.Chown(stagePath, uid, gid); err != nil {
+			return err
+		}
+		if err := os.Chmod(stagePath, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 解析 --chown=uid:gid, 未指定时复制的文件属于root
+func parseChown(chown string) (int, int, error) {
+	if chown == "" {
+		return 0, 0, nil
+	}
+	parts := strings.SplitN(chown, ":", 2)
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("only numeric --chown is supported: %s", chown)
+	}
+	gid := uid
+	if len(parts) == 2 {
+		if gid, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, fmt.Errorf("only numeric --chown is supported: %s", chown)
+		}
+	}
+	return uid, gid, nil
+}
+
+// 根据文件名判断是否为 tar 包
+func isTarArchive(path string) bool {
+	for _, suffix := range []string{".tar", ".tar.gz", ".tgz", ".tar.zst"} {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 解压(可能压缩过的) tar 包到目录
+func extractTarArchive(path, dst string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stream, err := archive.DecompressStream(file)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	return archive.Untar(stream, dst)
+}
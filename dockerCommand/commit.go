@@ -2,19 +2,80 @@ package dockerCommand
 
 import (
 	"MiniDocker/container"
+	"MiniDocker/image"
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"os/exec"
+	"io"
+	"time"
 )
 
-// CommitContainer 将容器文件系统打包成${imageName}.tar
-func CommitContainer(containerName, imageName string) {
-	mntURL := fmt.Sprintf(container.MntUrl, containerName)
-	mntURL += "/"
-	imageTar := container.RootUrl + "/" + imageName + ".tar"
-	logrus.Infof("containerURL: %v", mntURL)
-	logrus.Infof("imageTar: %v", imageTar)
-	if _, err := exec.Command("tar", "-czf", imageTar, "-C", mntURL, ".").CombinedOutput(); err != nil {
-		logrus.Errorf("Tar folder: %v fails: %v", mntURL, err)
+// CommitContainer 将容器的可写层提交为新镜像并命名为${imageName}, 存入本地镜像仓库
+func CommitContainer(containerName, imageName string) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info fails: %v", containerName, err)
 	}
+
+	// 在容器镜像的基础上增加一层；未记录镜像的旧容器将整个文件系统提交为一层
+	config := image.NewImageConfig()
+	var layers []image.Descriptor
+	var desc image.Descriptor
+	var diffID string
+	if containerInfo.Image != "" {
+		var parent *image.Image
+		if parent, err = containerImage(containerInfo); err != nil {
+			return err
+		}
+		config = parent.Config.Clone()
+		layers = append(layers, parent.Manifest.Layers...)
+		desc, diffID, err = storeContainerLayer(containerInfo)
+	} else {
+		layerDir := fmt.Sprintf(container.MntUrl, containerName)
+		logrus.Infof("commit layer dir: %v", layerDir)
+		desc, diffID, err = image.StoreLayerFromDir(layerDir)
+	}
+	if err != nil {
+		return fmt.Errorf("store layer of container %s fails: %v", containerName, err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	config.Created = now
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	config.History = append(config.History, image.History{
+		Created:   now,
+		CreatedBy: containerInfo.Command,
+		Comment:   "commit from container " + containerName,
+	})
+	img, err := image.CreateImage(config, append(layers, desc))
+	if err != nil {
+		return err
+	}
+	if imageName != "" {
+		if err := image.TagImage(img.ID, imageName); err != nil {
+			return err
+		}
+	}
+	fmt.Println(img.ID)
+	return nil
+}
+
+// 由存储驱动将容器相对于镜像的修改存为镜像层
+func storeContainerLayer(info *container.ContainerInfo) (image.Descriptor, string, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(container.CommitChanges(info, writer))
+	}()
+	defer reader.Close()
+	return image.StoreLayer(reader)
+}
+
+// 得到容器创建时使用的镜像，记录了镜像ID时按ID查找，不受镜像名被重新指向的影响
+func containerImage(info *container.ContainerInfo) (*image.Image, error) {
+	if info.ImageID != "" {
+		img, err := image.GetImageByID(info.ImageID)
+		if err != nil {
+			return nil, fmt.Errorf("get image %s of container %s fails: %v", info.ImageID, info.Name, err)
+		}
+		return img, nil
+	}
+	return getOrImportImage(info.Image)
 }
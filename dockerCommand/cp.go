@@ -0,0 +1,177 @@
+package dockerCommand
+
+import (
+	"MiniDocker/archive"
+	"MiniDocker/container"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CopyFiles `docker cp` 在宿主机和容器之间复制文件或目录，保留属主、权限和修改时间
+// src 和 dst 中有且只有一个为 container:path 格式，宿主机路径为 - 时从标准输入读取或向标准输出写入 tar 流
+func CopyFiles(src, dst string) error {
+	srcContainer, srcPath := splitCopyArg(src)
+	dstContainer, dstPath := splitCopyArg(dst)
+	switch {
+	case srcContainer != "" && dstContainer != "":
+		return fmt.Errorf("copying between containers is not supported")
+	case srcContainer == "" && dstContainer == "":
+		return fmt.Errorf("must specify at least one container source, use: container:path")
+	case srcContainer != "":
+		return copyFromContainer(srcContainer, srcPath, dstPath)
+	default:
+		return copyToContainer(srcPath, dstContainer, dstPath)
+	}
+}
+
+// 解析 container:path 格式的参数，以 / 或 . 开头的参数为宿主机路径
+func splitCopyArg(arg string) (string, string) {
+	if filepath.IsAbs(arg) || strings.HasPrefix(arg, ".") {
+		return "", arg
+	}
+	name, path, ok := strings.Cut(arg, ":")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return "", arg
+	}
+	return name, path
+}
+
+// 得到容器信息和容器文件系统的根目录
+func containerRootfs(containerName string) (*container.ContainerInfo, string, error) {
+	info, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return nil, "", fmt.Errorf("get container %s info fails: %v", containerName, err)
+	}
+	root, err := container.GetRootfs(info)
+	return info, root, err
+}
+
+// 从容器复制到宿主机, dst 为 - 时将 tar 流写到标准输出
+// 使用用户命名空间的容器，tar 流中的属主为容器内的 id
+func copyFromContainer(containerName, srcPath, dst string) error {
+	info, root, err := containerRootfs(containerName)
+	if err != nil {
+		return err
+	}
+	src, err := container.ResolvePath(root, srcPath, false)
+	if err != nil {
+		return err
+	}
+	if dst == "-" {
+		// 标准输出用于传输 tar 流，日志改为输出到标准错误
+		logrus.SetOutput(os.Stderr)
+		if info.IDMappings == nil {
+			return archive.TarPath(src, os.Stdout)
+		}
+		reader, writer := io.Pipe()
+		go func() {
+			writer.CloseWithError(archive.TarPath(src, writer))
+		}()
+		mapped := archive.MapIDs(reader, info.IDMappings.ToContainer)
+		defer reader.Close()
+		defer mapped.Close()
+		_, err := io.Copy(os.Stdout, mapped)
+		return err
+	}
+	return copyPath(src, dst, srcPath, dst, -1, -1)
+}
+
+// 从宿主机复制到容器, src 为 - 时从标准输入读取 tar 流解压到容器内的目录
+// 使用用户命名空间的容器，tar 流中的属主按容器内的 id 转换，复制的文件属于容器内的 root
+func copyToContainer(src, containerName, dstPath string) error {
+	info, root, err := containerRootfs(containerName)
+	if err != nil {
+		return err
+	}
+	dst, err := container.ResolvePath(root, dstPath, true)
+	if err != nil {
+		return err
+	}
+	if src == "-" {
+		if info, err := os.Stat(dst); err != nil || !info.IsDir() {
+			return fmt.Errorf("destination %s must be a directory when copying from stdin", dstPath)
+		}
+		if info.IDMappings == nil {
+			return archive.Untar(os.Stdin, dst)
+		}
+		mapped := archive.MapIDs(os.Stdin, info.IDMappings.ToHost)
+		defer mapped.Close()
+		return archive.Untar(mapped, dst)
+	}
+	dir, name, err := copyTarget(src, dst, src, dstPath)
+	if err != nil {
+		return err
+	}
+	/*
+		容器内的符号链接可能指向宿主机上的文件(运行中容器的 rootfs 为 /proc/<pid>/root，其中的绝对链接按宿主机的根目录解析)
+		因此不直接写入目标路径，而是打包为 tar 流后由 Untar 解压，Untar 不会跟随目标目录中的符号链接，并会替换已存在的条目
+	*/
+	reader, writer := io.Pipe()
+	go func() {
+		if name == "" {
+			writer.CloseWithError(archive.Tar(src, writer))
+		} else {
+			writer.CloseWithError(archive.TarPathAs(src, name, writer))
+		}
+	}()
+	defer reader.Close()
+	if info.IDMappings == nil {
+		return archive.Untar(reader, dir)
+	}
+	// 复制的文件属于容器内的 root
+	uid, gid := info.IDMappings.RootPair()
+	mapped := archive.MapIDs(reader, func(int, int) (int, int) { return uid, gid })
+	defer mapped.Close()
+	return archive.Untar(mapped, dir)
+}
+
+/*
+按照 cp 的规则复制，srcArg、dstArg 为用户输入的路径
+uid、gid 为 -1 时保留源文件的属主
+*/
+func copyPath(src, dst, srcArg, dstArg string, uid, gid int) error {
+	dir, name, err := copyTarget(src, dst, srcArg, dstArg)
+	if err != nil {
+		return err
+	}
+	return archive.CopyPath(src, filepath.Join(dir, name), uid, gid)
+}
+
+/*
+按照 cp 的规则得到复制的目标目录和文件名，srcArg、dstArg 为用户输入的路径
+1.dst 为已存在的目录时复制到 dst/<src文件名>, src 以 /. 结尾时只复制目录中的内容，此时文件名为空
+2.dst 不存在时以 dst 为名创建，其父目录必须存在；dst 以 / 结尾时必须是目录
+3.不能用目录覆盖已存在的文件
+*/
+func copyTarget(src, dst, srcArg, dstArg string) (string, string, error) {
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return "", "", fmt.Errorf("no such file or directory: %s", srcArg)
+	}
+	dstInfo, err := os.Stat(dst)
+	switch {
+	case err == nil && dstInfo.IsDir():
+		if srcInfo.IsDir() && strings.HasSuffix(srcArg, "/.") {
+			return dst, "", nil
+		}
+		return dst, filepath.Base(src), nil
+	case err == nil:
+		if srcInfo.IsDir() {
+			return "", "", fmt.Errorf("cannot copy a directory to file %s", dstArg)
+		}
+	case os.IsNotExist(err):
+		if strings.HasSuffix(dstArg, "/") && !srcInfo.IsDir() {
+			return "", "", fmt.Errorf("destination directory %s does not exist", dstArg)
+		}
+		if parent, err := os.Stat(filepath.Dir(dst)); err != nil || !parent.IsDir() {
+			return "", "", fmt.Errorf("parent directory of %s does not exist", dstArg)
+		}
+	default:
+		return "", "", err
+	}
+	return filepath.Dir(dst), filepath.Base(dst), nil
+}
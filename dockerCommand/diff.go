@@ -0,0 +1,22 @@
+package dockerCommand
+
+import (
+	"MiniDocker/container"
+	"fmt"
+)
+
+// DiffContainer 列出容器文件系统相对于镜像的变化, A 新增, C 修改, D 删除
+func DiffContainer(containerName string) error {
+	info, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info fails: %v", containerName, err)
+	}
+	changes, err := container.GetChanges(info)
+	if err != nil {
+		return fmt.Errorf("get changes of container %s fails: %v", containerName, err)
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package dockerCommand
+
+import (
+	"MiniDocker/container"
+	"MiniDocker/image"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+)
+
+// ExportContainer 将容器镜像层与可写层合并后的文件系统导出为 tar 包，output 为空时输出到标准输出
+// 与 docker 一致，数据卷等容器内的挂载不会被导出
+func ExportContainer(containerName, output string) error {
+	var w io.Writer = os.Stdout
+	if output == "" {
+		// 标准输出用于传输文件系统数据，日志改为输出到标准错误
+		logrus.SetOutput(os.Stderr)
+	}
+	info, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info fails: %v", containerName, err)
+	}
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("create file %s fails: %v", output, err)
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := container.ExportRootfs(info, w); err != nil {
+		if output != "" {
+			_ = os.Remove(output)
+		}
+		return fmt.Errorf("export container %s fails: %v", containerName, err)
+	}
+	return nil
+}
+
+// ImportImage 将 export 导出的文件系统 tar 包导入为只有一层的镜像，source 为 - 时从标准输入读取
+// changes 为应用到镜像配置的 Dockerfile 指令，支持 ENV、WORKDIR、CMD、ENTRYPOINT、USER、LABEL、EXPOSE
+func ImportImage(source, name string, changes []string) error {
+	config := image.NewImageConfig()
+	for _, change := range changes {
+		inst, err := image.ParseInstruction(change)
+		if err != nil {
+			return fmt.Errorf("invalid change %q: %v", change, err)
+		}
+		switch inst.Cmd {
+		case "FROM", "RUN", "COPY", "ADD":
+			return fmt.Errorf("invalid change %q: %s is not supported", change, inst.Cmd)
+		}
+		if err := applyConfigInstruction(config, inst, true); err != nil {
+			return err
+		}
+	}
+
+	var r io.Reader = os.Stdin
+	if source != "-" {
+		file, err := os.Open(source)
+		if err != nil {
+			return fmt.Errorf("open file %s fails: %v", source, err)
+		}
+		defer file.Close()
+		r = file
+	}
+	img, err := image.ImportImageFrom(r, name, "imported from "+source, config)
+	if err != nil {
+		return fmt.Errorf("import %s fails: %v", source, err)
+	}
+	fmt.Println(img.ID)
+	return nil
+}
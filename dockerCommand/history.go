@@ -0,0 +1,45 @@
+package dockerCommand
+
+import (
+	"MiniDocker/image"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// CREATED BY 列默认截断的长度
+const historyCreatedByWidth = 45
+
+// ImageHistory 打印镜像每一层的创建命令、创建时间、大小和注释，最新的层在最前
+func ImageHistory(imageName string, noTrunc bool) error {
+	img, err := getOrImportImage(imageName)
+	if err != nil {
+		return err
+	}
+	histories, err := img.LayerHistory()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	_, _ = fmt.Fprint(w, "IMAGE\tCREATED\tCREATED BY\tSIZE\tCOMMENT\n")
+	for i := len(histories) - 1; i >= 0; i-- {
+		item := histories[i]
+		id := item.ID
+		if id != image.MissingID {
+			id = image.ShortID(id)
+		}
+		createdBy := strings.Join(strings.Fields(item.CreatedBy), " ")
+		if !noTrunc && len(createdBy) > historyCreatedByWidth {
+			createdBy = createdBy[:historyCreatedByWidth-3] + "..."
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			id,
+			humanDuration(item.Created),
+			createdBy,
+			humanSize(item.Size),
+			item.Comment)
+	}
+	return w.Flush()
+}
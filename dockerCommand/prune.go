@@ -0,0 +1,216 @@
+package dockerCommand
+
+import (
+	"MiniDocker/archive"
+	"MiniDocker/cgroups"
+	"MiniDocker/container"
+	"MiniDocker/image"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// orphanGracePeriod 最近修改过的目录可能属于正在创建的容器，还没有记录容器信息，不视为崩溃的容器留下的目录
+const orphanGracePeriod = 10 * time.Minute
+
+// PruneContainers 删除所有已退出的容器及其 cgroup，以及崩溃的容器留下的、没有容器信息的挂载点和可写层
+// 正在构建的镜像使用的临时容器(build-*)没有容器信息，不会被删除
+func PruneContainers() error {
+	containers, err := getAllContainerInfos()
+	if err != nil {
+		return err
+	}
+	var reclaimed int64
+	known := map[string]bool{}
+	fmt.Println("Deleted Containers:")
+	for _, info := range containers {
+		if isContainerRunning(info) {
+			known[info.Name] = true
+			continue
+		}
+		size, _ := archive.DirSize(fmt.Sprintf(container.WriteLayerUrl, info.Name))
+		infoDir := fmt.Sprintf(container.DefaultInfoLocation, info.Name)
+		if err := os.RemoveAll(infoDir); err != nil {
+			return fmt.Errorf("remove container %s info fails: %v", info.Name, err)
+		}
+		container.DeleteWorkSpace(info.Mounts, info.Name, info.StorageDriver)
+		cgroups.NewCgroupManager(CgroupPath(info.Id)).Remove()
+		reclaimed += size
+		fmt.Println(info.Name)
+	}
+
+	size, err := pruneOrphanDirs(known)
+	if err != nil {
+		return err
+	}
+	reclaimed += size
+	fmt.Printf("Total reclaimed space: %s\n", humanSize(reclaimed))
+	return nil
+}
+
+// PruneImages 删除没有名字的镜像，all 为 true 时删除所有未被容器使用的镜像，并回收不再被引用的层
+func PruneImages(all bool) error {
+	inUse, err := imagesInUse(false)
+	if err != nil {
+		return err
+	}
+	removed, err := image.PruneImages(all, inUse)
+	fmt.Println("Deleted Images:")
+	for _, id := range removed {
+		fmt.Println(id)
+	}
+	if err != nil {
+		return fmt.Errorf("prune images fails: %v", err)
+	}
+	pids, err := runningContainerPids()
+	if err != nil {
+		return err
+	}
+	reclaimed, err := image.GarbageCollect(pids)
+	if err != nil {
+		return fmt.Errorf("remove unreferenced layers fails: %v", err)
+	}
+	fmt.Printf("Total reclaimed space: %s\n", humanSize(reclaimed))
+	return nil
+}
+
+// SystemPrune 依次删除已退出的容器、无用的镜像和不再被引用的层，volumes 为 true 时同时删除未使用的数据卷
+func SystemPrune(all, volumes bool) error {
+	if err := PruneContainers(); err != nil {
+		return err
+	}
+	if volumes {
+		if err := PruneVolumes(); err != nil {
+			return err
+		}
+	}
+	return PruneImages(all)
+}
+
+// 删除没有对应容器信息的挂载点、可写层、overlay 工作目录和可写层的镜像文件
+// 构建用的临时容器和最近修改过的目录可能仍在使用，跳过
+// @return 回收的字节数
+func pruneOrphanDirs(known map[string]bool) (int64, error) {
+	var reclaimed int64
+	for _, pattern := range []string{container.MntUrl, container.WriteLayerUrl, container.WorkLayerUrl, container.StorageUrl} {
+		parent := filepath.Dir(fmt.Sprintf(pattern, "x"))
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return reclaimed, err
+		}
+		for _, entry := range entries {
+			if known[entry.Name()] || strings.HasPrefix(entry.Name(), buildContainerPrefix) {
+				continue
+			}
+			if info, err := entry.Info(); err != nil || time.Since(info.ModTime()) < orphanGracePeriod {
+				continue
+			}
+			if infoExists, _ := container.PathExists(fmt.Sprintf(container.DefaultInfoLocation, entry.Name())); infoExists {
+				continue
+			}
+			path := filepath.Join(parent, entry.Name())
+			// 删除前必须卸载其中所有的挂载点，否则会删除数据卷或镜像层中的文件
+			if err := container.UnmountAll(path); err != nil {
+				logrus.Warnf("skip %s: %v", path, err)
+				continue
+			}
+			if pattern == container.WriteLayerUrl {
+				size, _ := archive.DirSize(path)
+				reclaimed += size
+			}
+			if err := os.RemoveAll(path); err != nil {
+				return reclaimed, fmt.Errorf("remove %s fails: %v", path, err)
+			}
+			logrus.Infof("remove orphaned dir %s", path)
+		}
+	}
+	return reclaimed, nil
+}
+
+// 得到所有容器的信息
+func getAllContainerInfos() ([]*container.ContainerInfo, error) {
+	dirUrl := filepath.Dir(filepath.Clean(fmt.Sprintf(container.DefaultInfoLocation, "x")))
+	files, err := os.ReadDir(dirUrl)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read dir %v fails: %v", dirUrl, err)
+	}
+	var containers []*container.ContainerInfo
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		// 跳过网络配置等没有容器信息的目录
+		configPath := filepath.Join(dirUrl, file.Name(), container.ConfigName)
+		if exists, _ := container.PathExists(configPath); !exists {
+			continue
+		}
+		info, err := getContainerInfoByName(file.Name())
+		if err != nil {
+			continue
+		}
+		containers = append(containers, info)
+	}
+	return containers, nil
+}
+
+// 判断容器是否在运行，状态为运行但 init 进程已不存在的容器视为已退出
+func isContainerRunning(info *container.ContainerInfo) bool {
+	if info.Status != container.RUNNING {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(info.Pid))
+	if err != nil {
+		return false
+	}
+	return syscall.Kill(pid, 0) == nil
+}
+
+// 得到容器使用的镜像ID，runningOnly 为 true 时只统计运行中的容器
+func imagesInUse(runningOnly bool) (map[string]bool, error) {
+	containers, err := getAllContainerInfos()
+	if err != nil {
+		return nil, err
+	}
+	inUse := map[string]bool{}
+	for _, info := range containers {
+		if info.Image == "" || (runningOnly && !isContainerRunning(info)) {
+			continue
+		}
+		// 按创建容器时记录的镜像ID统计，镜像名之后可能已指向其他镜像；之前版本记录的容器只有镜像名
+		if info.ImageID != "" {
+			inUse[info.ImageID] = true
+		} else if img, err := image.GetImage(info.Image); err == nil {
+			inUse[img.ID] = true
+		}
+	}
+	return inUse, nil
+}
+
+// 运行中的容器的 init 进程，rootless 模式下容器的 overlay 只在容器进程的挂载命名空间中可见
+func runningContainerPids() ([]int, error) {
+	containers, err := getAllContainerInfos()
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, info := range containers {
+		if !isContainerRunning(info) {
+			continue
+		}
+		if pid, err := strconv.Atoi(strings.TrimSpace(info.Pid)); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
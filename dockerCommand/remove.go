@@ -1,6 +1,7 @@
 package dockerCommand
 
 import (
+	"MiniDocker/cgroups"
 	"MiniDocker/container"
 	"fmt"
 	"github.com/sirupsen/logrus"
@@ -25,5 +26,6 @@ func RemoveContainer(containerName string) {
 	if err := os.RemoveAll(infoDir); err != nil {
 		logrus.Errorf("remove file %s fails: %v", infoDir, err)
 	}
-	container.DeleteWorkSpace(containerInfo.Volume, containerName)
+	container.DeleteWorkSpace(containerInfo.Mounts, containerName, containerInfo.StorageDriver)
+	cgroups.NewCgroupManager(CgroupPath(containerInfo.Id)).Remove()
 }
@@ -4,47 +4,108 @@ import (
 	"MiniDocker/cgroups"
 	"MiniDocker/cgroups/subsystem"
 	"MiniDocker/container"
+	"MiniDocker/image"
 	"MiniDocker/network"
+	"encoding/json"
 	"github.com/sirupsen/logrus"
+	"net"
 	"os"
+	"os/exec"
 	"strconv"
 	"strings"
 )
 
 // Run `docker run` 时真正调用的函数
-func Run(tty bool, containerCmd []string, res *subsystem.ResourceConfig, volume string, containerName string, imageName string, envSlice []string, nw string, portmapping []string) {
+// initConfig 为用户指定的容器启动配置(命令、挂载点、只读根目录等)，未指定的部分使用镜像中的默认配置
+// etcConfig 为容器的 hosts 和 DNS 配置, storageSize 大于 0 时限制可写层的大小
+// idMappings 不为空时容器运行在新的用户命名空间中, 容器内的 root 映射为宿主机上的普通用户
+func Run(tty bool, initConfig *container.InitConfig, res *subsystem.ResourceConfig, storageSize int64, containerName string, imageName string, envSlice []string, nw string, portmapping []string, etcConfig *container.EtcConfig, verify bool, idMappings *container.IDMappings) {
 	// 生成10位数字的容器ID
 	containerID := randStringBytes(10)
 	// 若未指定容器名则以容器ID作为容器名
 	if containerName == "" {
 		containerName = containerID
 	}
+	// 未指定主机名时以容器ID作为主机名
+	if initConfig.Hostname == "" {
+		initConfig.Hostname = containerID
+	}
+
+	// 镜像名只解析一次，签名校验、默认配置和容器的只读层使用同一个镜像，期间镜像名被重新指向其他镜像也不受影响
+	// 本地镜像仓库中的镜像记录其ID，不在其中时为/root/下的tar镜像
+	var img *image.Image
+	imageID := ""
+	if found, err := image.GetImage(imageName); err == nil {
+		img, imageID = found, found.ID
+	}
+
+	// 校验镜像签名
+	if err := verifyRunImage(img, imageName, verify); err != nil {
+		logrus.Errorf("%v", err)
+		return
+	}
+
+	// 使用镜像中的默认配置补全启动命令和环境变量
+	envSlice = applyImageConfig(img, initConfig, envSlice)
+	containerCmd := initConfig.Cmd
+	mounts := initConfig.Mounts
 
 	// `docker init <containerCmd>` 创建隔离了namespace的新进程, 返回的写通道口用于传容器命令
-	initProcess, writePipe := container.NewProcess(tty, volume, containerName, imageName, envSlice)
+	initProcess, writePipe, err := container.NewProcess(tty, mounts, containerName, imageName, imageID, envSlice, storageSize, idMappings, nw == network.NetworkHost)
+	if err != nil {
+		logrus.Errorf("new process fails: %v", err)
+		return
+	}
 	logrus.Infof("parent pid: %v", os.Getpid())
 	// start the init process
+	// 用户命名空间的映射范围不可用等情况下启动失败，进程不存在，只需关闭管道并删除工作空间
 	if err := initProcess.Start(); err != nil {
-		logrus.Error(err)
+		logrus.Errorf("start container %s fails: %v", containerName, err)
+		writePipe.Close()
+		for _, file := range initProcess.ExtraFiles {
+			file.Close()
+		}
+		container.DeleteWorkSpace(mounts, containerName, container.StorageDriver)
+		container.DeleteContainerInfo(containerName)
+		return
 	}
 
+	// 创建 cgroupManager 控制所有 hierarchies层级 的资源配置, 每个容器使用独立的 cgroup
+	cm := cgroups.NewCgroupManager(CgroupPath(containerID))
+
 	// 记录容器信息
-	containerName, err := container.RecordContainerInfo(initProcess.Process.Pid, containerCmd, containerName, containerID, volume)
-	if err != nil {
+	if _, err := container.RecordContainerInfo(initProcess.Process.Pid, containerCmd, containerName, containerID, mounts, imageName, imageID, idMappings, initConfig.Capabilities); err != nil {
 		logrus.Errorf("record container info fails: %v", err)
+		abortRun(initProcess, writePipe, containerName, mounts, cm)
 		return
 	}
 
-	// 创建 cgroupManager 控制所有 hierarchies层级 的资源配置
-	cm := cgroups.NewCgroupManager("simple-docker")
-	defer cm.Remove()
-	cm.Set(res)
-	cm.AddProcess(initProcess.Process.Pid)
+	if err := cm.Set(res); err != nil {
+		logrus.Errorf("set cgroup of container %s fails: %v", containerName, err)
+		abortRun(initProcess, writePipe, containerName, mounts, cm)
+		return
+	}
+	if err := cm.AddProcess(initProcess.Process.Pid); err != nil {
+		logrus.Errorf("add container %s to cgroup fails: %v", containerName, err)
+		abortRun(initProcess, writePipe, containerName, mounts, cm)
+		return
+	}
 
-	if nw != "" {
+	var ip net.IP
+	switch nw {
+	case network.NetworkHost:
+		// 使用宿主机的网络命名空间，不需要配置
+	case "", network.NetworkNone:
+		// 未连接网络的容器只有回环接口
+		containerInfo := &container.ContainerInfo{Pid: strconv.Itoa(initProcess.Process.Pid)}
+		if err := network.SetUpLoopback(containerInfo); err != nil {
+			logrus.Warnf("set up loopback fails: %v", err)
+		}
+	default:
 		// 配置容器网络
 		if err := network.Init(); err != nil {
 			logrus.Errorf("init network fails: %v", err)
+			abortRun(initProcess, writePipe, containerName, mounts, cm)
 			return
 		}
 		containerInfo := &container.ContainerInfo{
@@ -53,14 +114,24 @@ func Run(tty bool, containerCmd []string, res *subsystem.ResourceConfig, volume
 			Name:        containerName,
 			PortMapping: portmapping,
 		}
-		if err := network.Connect(nw, containerInfo); err != nil {
+		if ip, err = network.Connect(nw, containerInfo); err != nil {
 			logrus.Errorf("Error Connect Network %v", err)
+			abortRun(initProcess, writePipe, containerName, mounts, cm)
 			return
 		}
 	}
 
+	// 生成 hosts、hostname、resolv.conf 并挂载到容器内，需要容器的ip
+	etcMounts, err := container.SetUpEtcFiles(containerName, initConfig.Hostname, ip, etcConfig, nw == network.NetworkHost)
+	if err != nil {
+		logrus.Errorf("set up etc files fails: %v", err)
+		abortRun(initProcess, writePipe, containerName, mounts, cm)
+		return
+	}
+	initConfig.Mounts = append(initConfig.Mounts, etcMounts...)
+
 	// 发生容器起始命令
-	sendInitCommand(containerCmd, writePipe)
+	sendInitCommand(initConfig, writePipe)
 
 	// 等待进程运行完毕(-it)
 	if tty {
@@ -70,16 +141,55 @@ func Run(tty bool, containerCmd []string, res *subsystem.ResourceConfig, volume
 		//mntURl := "/root/mnt/"
 		//rootURL := "/root/"
 		container.DeleteContainerInfo(containerName)
-		container.DeleteWorkSpace(volume, containerName)
+		container.DeleteWorkSpace(mounts, containerName, container.StorageDriver)
+		cm.Remove()
 	}
 
 	os.Exit(0)
 }
 
-// 通过管道发送容器的起始命令，并关闭通道
-func sendInitCommand(containerCmd []string, writePipe *os.File) {
-	cmdString := strings.Join(containerCmd, " ")
-	logrus.Infof("init command is: %v", cmdString)
-	writePipe.WriteString(cmdString)
+// 容器启动过程中出错时结束阻塞在管道上的 init 进程，并删除已创建的工作空间、容器信息和 cgroup
+func abortRun(initProcess *exec.Cmd, writePipe *os.File, containerName string, mounts []container.Mount, cm *cgroups.CgroupManager) {
+	writePipe.Close()
+	if err := initProcess.Process.Kill(); err != nil {
+		logrus.Warnf("kill init process of container %s fails: %v", containerName, err)
+	}
+	initProcess.Wait()
+	container.DeleteContainerInfo(containerName)
+	container.DeleteWorkSpace(mounts, containerName, container.StorageDriver)
+	cm.Remove()
+}
+
+// CgroupPath 容器在各个 hierarchy 中的 cgroup 路径
+func CgroupPath(containerID string) string {
+	return "minidocker-" + containerID
+}
+
+// 通过管道发送容器的启动配置，并关闭通道
+func sendInitCommand(initConfig *container.InitConfig, writePipe *os.File) {
+	logrus.Infof("init command is: %v", strings.Join(initConfig.Cmd, " "))
+	configBytes, err := json.Marshal(initConfig)
+	if err != nil {
+		logrus.Errorf("marshal init config fails: %v", err)
+	}
+	writePipe.Write(configBytes)
 	writePipe.Close()
 }
+
+// 若镜像位于本地镜像仓库中(img 不为空)，使用其默认的入口命令、环境变量、工作目录和用户
+// @return 镜像环境变量与用户指定的环境变量合并后的结果
+func applyImageConfig(img *image.Image, initConfig *container.InitConfig, envSlice []string) []string {
+	if img == nil {
+		return envSlice
+	}
+	config := img.Config.Config
+	// 未指定命令时使用镜像的CMD, ENTRYPOINT总是作为命令前缀
+	cmd := initConfig.Cmd
+	if len(cmd) == 0 {
+		cmd = config.Cmd
+	}
+	initConfig.Cmd = append(append([]string{}, config.Entrypoint...), cmd...)
+	initConfig.WorkDir = config.WorkingDir
+	initConfig.User = config.User
+	return append(append([]string{}, config.Env...), envSlice...)
+}
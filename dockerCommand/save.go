@@ -0,0 +1,60 @@
+package dockerCommand
+
+import (
+	"MiniDocker/image"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+)
+
+// SaveImages 将镜像导出为 tar 包，output 为空时输出到标准输出
+func SaveImages(refs []string, output string) error {
+	var w io.Writer = os.Stdout
+	if output == "" {
+		// 标准输出用于传输镜像数据，日志改为输出到标准错误
+		logrus.SetOutput(os.Stderr)
+	}
+	// /root/下的tar镜像先导入本地镜像仓库
+	for _, ref := range refs {
+		if _, err := getOrImportImage(ref); err != nil {
+			return err
+		}
+	}
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("create file %s fails: %v", output, err)
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := image.Save(refs, w); err != nil {
+		if output != "" {
+			_ = os.Remove(output)
+		}
+		return fmt.Errorf("save images fails: %v", err)
+	}
+	return nil
+}
+
+// LoadImages 从 tar 包导入镜像，input 为空时从标准输入读取
+func LoadImages(input string) error {
+	var r io.Reader = os.Stdin
+	if input != "" {
+		file, err := os.Open(input)
+		if err != nil {
+			return fmt.Errorf("open file %s fails: %v", input, err)
+		}
+		defer file.Close()
+		r = file
+	}
+	loaded, err := image.Load(r)
+	if err != nil {
+		return fmt.Errorf("load images fails: %v", err)
+	}
+	for _, name := range loaded {
+		fmt.Printf("Loaded image: %s\n", name)
+	}
+	return nil
+}
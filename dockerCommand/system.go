@@ -0,0 +1,115 @@
+package dockerCommand
+
+import (
+	"MiniDocker/archive"
+	"MiniDocker/container"
+	"MiniDocker/image"
+	"MiniDocker/volume"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// 一类资源的磁盘占用
+type diskUsage struct {
+	kind        string
+	total       int
+	active      int
+	size        int64
+	reclaimable int64
+}
+
+// SystemDf 打印镜像、容器可写层、数据卷和容器日志占用的磁盘空间
+func SystemDf() error {
+	containers, err := getAllContainerInfos()
+	if err != nil {
+		return err
+	}
+
+	// 镜像：被运行中的容器使用的镜像为活跃镜像
+	images, err := image.Images()
+	if err != nil {
+		return err
+	}
+	active, err := imagesInUse(true)
+	if err != nil {
+		return err
+	}
+	imageSize, activeSize, err := image.DiskUsage(active)
+	if err != nil {
+		return err
+	}
+	imageUsage := diskUsage{kind: "Images", total: len(images), active: len(active), size: imageSize, reclaimable: imageSize - activeSize}
+
+	// 容器：统计可写层，包括崩溃的容器留下的可写层
+	containerUsage := diskUsage{kind: "Containers", total: len(containers)}
+	logUsage := diskUsage{kind: "Logs"}
+	running := map[string]bool{}
+	activeVolumes := map[string]bool{}
+	for _, info := range containers {
+		if isContainerRunning(info) {
+			running[info.Name] = true
+			containerUsage.active++
+		}
+		logPath := filepath.Join(fmt.Sprintf(container.DefaultInfoLocation, info.Name), container.ContainerLogFile)
+		if stat, err := os.Stat(logPath); err == nil {
+			logUsage.total++
+			logUsage.size += stat.Size()
+			if running[info.Name] {
+				logUsage.active++
+			} else {
+				logUsage.reclaimable += stat.Size()
+			}
+		}
+		for _, mount := range info.Mounts {
+			if mount.Type == container.MountTypeVolume && running[info.Name] {
+				activeVolumes[mount.Name] = true
+			}
+		}
+	}
+
+	// 数据卷：只统计命名数据卷，绑定挂载的宿主机目录不由 MiniDocker 管理
+	volumes, err := volume.List()
+	if err != nil {
+		return err
+	}
+	volumeUsage := diskUsage{kind: "Volumes", total: len(volumes)}
+	for _, vol := range volumes {
+		size, _ := archive.DirSize(vol.Mountpoint)
+		volumeUsage.size += size
+		if activeVolumes[vol.Name] {
+			volumeUsage.active++
+		} else {
+			volumeUsage.reclaimable += size
+		}
+	}
+	writeLayers, err := os.ReadDir(filepath.Dir(fmt.Sprintf(container.WriteLayerUrl, "x")))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range writeLayers {
+		size, _ := archive.DirSize(fmt.Sprintf(container.WriteLayerUrl, entry.Name()))
+		containerUsage.size += size
+		if !running[entry.Name()] {
+			containerUsage.reclaimable += size
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	_, _ = fmt.Fprint(w, "TYPE\tTOTAL\tACTIVE\tSIZE\tRECLAIMABLE\n")
+	for _, usage := range []diskUsage{imageUsage, containerUsage, volumeUsage, logUsage} {
+		percent := 0
+		if usage.size > 0 {
+			percent = int(usage.reclaimable * 100 / usage.size)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s (%d%%)\n",
+			usage.kind,
+			usage.total,
+			usage.active,
+			humanSize(usage.size),
+			humanSize(usage.reclaimable),
+			percent)
+	}
+	return w.Flush()
+}
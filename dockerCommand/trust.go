@@ -0,0 +1,98 @@
+package dockerCommand
+
+import (
+	"MiniDocker/image"
+	"MiniDocker/trust"
+	"crypto"
+	"fmt"
+	"os"
+)
+
+// GenerateKey 生成签名密钥对，保存为 ${name}.key 和 ${name}.pub
+func GenerateKey(keyType, name string) error {
+	privatePEM, publicPEM, err := trust.GenerateKey(keyType)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(name+".key", privatePEM, 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(name+".pub", publicPEM, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Generated key pair %s.key, %s.pub\n", name, name)
+	return nil
+}
+
+// SignImage 使用本地私钥对镜像签名
+func SignImage(imageName, keyPath string) error {
+	key, err := trust.LoadPrivateKey(keyPath)
+	if err != nil {
+		return err
+	}
+	img, err := getOrImportImage(imageName)
+	if err != nil {
+		return err
+	}
+	sig, err := trust.Sign(img.ID, key)
+	if err != nil {
+		return fmt.Errorf("sign image %s fails: %v", imageName, err)
+	}
+	fmt.Printf("Signed %s (%s) with key %s\n", imageName, img.ShortID(), sig.KeyID[:12])
+	return nil
+}
+
+// VerifyImage 校验镜像签名，未指定公钥时使用签名策略中的可信公钥
+func VerifyImage(imageName string, keyPaths []string) error {
+	img, err := image.GetImage(imageName)
+	if err != nil {
+		return err
+	}
+	if len(keyPaths) == 0 {
+		policy, err := trust.LoadPolicy()
+		if err != nil {
+			return err
+		}
+		if err := policy.VerifyImage(img.ID); err != nil {
+			return fmt.Errorf("verify image %s fails: %v", imageName, err)
+		}
+	} else {
+		var keys []crypto.PublicKey
+		for _, path := range keyPaths {
+			key, err := trust.LoadPublicKey(path)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, key)
+		}
+		if err := trust.Verify(img.ID, keys); err != nil {
+			return fmt.Errorf("verify image %s fails: %v", imageName, err)
+		}
+	}
+	fmt.Printf("Verified %s (%s)\n", imageName, img.ShortID())
+	return nil
+}
+
+// 启动容器前校验镜像签名，指定 --verify 或签名策略要求签名时才校验
+// img 为 imageName 解析得到的镜像，校验通过后容器使用同一个镜像ID，为空时为/root/下的tar镜像
+// tar镜像没有签名，由 CreateReadOnlyLayer 根据签名策略拒绝
+func verifyRunImage(img *image.Image, imageName string, verify bool) error {
+	policy, err := trust.LoadPolicy()
+	if err != nil {
+		return err
+	}
+	if !verify && !policy.RequireSignature {
+		return nil
+	}
+	if img == nil {
+		return fmt.Errorf("image %s is not in the local image store and can not be verified", imageName)
+	}
+	if err := policy.VerifyImage(img.ID); err != nil {
+		return fmt.Errorf("verify image %s fails: %v", imageName, err)
+	}
+	// 签名只覆盖镜像ID, 还需确认本地的配置和各层与镜像ID一致
+	if err := image.VerifyContent(img); err != nil {
+		return fmt.Errorf("verify content of image %s fails: %v", imageName, err)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package dockerCommand
 
 import (
 	"MiniDocker/container"
+	"MiniDocker/image"
 	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
@@ -74,3 +75,51 @@ func randStringBytes(n int) string {
 	}
 	return string(b)
 }
+
+// 从本地镜像仓库中得到镜像，兼容/root/下的tar镜像，将其导入本地镜像仓库
+func getOrImportImage(name string) (*image.Image, error) {
+	if img, err := image.GetImage(name); err == nil {
+		return img, nil
+	}
+	tarPath := filepath.Join(container.RootUrl, name) + ".tar"
+	if exist, _ := container.PathExists(tarPath); exist {
+		return image.ImportImage(tarPath, name, "imported from "+tarPath)
+	}
+	return nil, fmt.Errorf("no such image: %s", name)
+}
+
+// 将字节数转换为易读的格式，如 1.5MB
+func humanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.4g%s", value, units[i])
+}
+
+// 将 RFC3339 格式的时间转换为距今多久，如 3 hours ago
+func humanDuration(created string) string {
+	if created == "" {
+		return ""
+	}
+	t, err := time.Parse(time.RFC3339Nano, created)
+	if err != nil {
+		return created
+	}
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%d seconds ago", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%d minutes ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d hours ago", int(d.Hours()))
+	case d < 30*24*time.Hour:
+		return fmt.Sprintf("%d days ago", int(d.Hours()/24))
+	default:
+		return t.Local().Format("2006-01-02 15:04:05")
+	}
+}
@@ -0,0 +1,145 @@
+package dockerCommand
+
+import (
+	"MiniDocker/archive"
+	"MiniDocker/container"
+	"MiniDocker/volume"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// CreateVolume 使用 driver 驱动创建命名数据卷，opts 和 labels 格式为 key=value
+func CreateVolume(name, driver string, opts, labels []string) error {
+	optMap, err := parseKeyValues(opts)
+	if err != nil {
+		return err
+	}
+	labelMap, err := parseKeyValues(labels)
+	if err != nil {
+		return err
+	}
+	vol, _, err := volume.Create(name, driver, optMap, labelMap)
+	if err != nil {
+		return err
+	}
+	fmt.Println(vol.Name)
+	return nil
+}
+
+// 解析 key=value 格式的参数，没有参数时返回 nil
+func parseKeyValues(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	result := map[string]string{}
+	for _, kv := range values {
+		key, value, _ := strings.Cut(kv, "=")
+		if key == "" {
+			return nil, fmt.Errorf("invalid option %q, use: key=value", kv)
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// ListVolumes 列出所有命名数据卷
+func ListVolumes() error {
+	volumes, err := volume.List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	_, _ = fmt.Fprint(w, "DRIVER\tVOLUME NAME\n")
+	for _, vol := range volumes {
+		fmt.Fprintf(w, "%s\t%s\n", vol.Driver, vol.Name)
+	}
+	return w.Flush()
+}
+
+// InspectVolumes 以 JSON 格式打印数据卷的信息
+func InspectVolumes(names []string) error {
+	var volumes []*volume.Volume
+	for _, name := range names {
+		vol, err := volume.Get(name)
+		if err != nil {
+			return err
+		}
+		volumes = append(volumes, vol)
+	}
+	data, err := json.MarshalIndent(volumes, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// RemoveVolumes 删除数据卷，被容器(包括已停止的容器)使用的数据卷不能删除
+func RemoveVolumes(names []string) error {
+	inUse, err := volumesInUse()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if users := inUse[name]; len(users) > 0 {
+			return fmt.Errorf("remove volume %s fails: volume is in use by container %s", name, strings.Join(users, ", "))
+		}
+		if err := volume.Remove(name); err != nil {
+			return fmt.Errorf("remove volume %s fails: %v", name, err)
+		}
+		fmt.Println(name)
+	}
+	return nil
+}
+
+// PruneVolumes 删除所有未被容器使用的数据卷
+func PruneVolumes() error {
+	inUse, err := volumesInUse()
+	if err != nil {
+		return err
+	}
+	used := map[string]bool{}
+	var reclaimed int64
+	volumes, err := volume.List()
+	if err != nil {
+		return err
+	}
+	for _, vol := range volumes {
+		if len(inUse[vol.Name]) > 0 {
+			used[vol.Name] = true
+			continue
+		}
+		size, _ := archive.DirSize(vol.Mountpoint)
+		reclaimed += size
+	}
+	removed, err := volume.Prune(used)
+	fmt.Println("Deleted Volumes:")
+	for _, name := range removed {
+		fmt.Println(name)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Total reclaimed space: %s\n", humanSize(reclaimed))
+	return nil
+}
+
+// 得到容器使用的命名数据卷, 数据卷名 -> 使用该数据卷的容器名
+func volumesInUse() (map[string][]string, error) {
+	containers, err := getAllContainerInfos()
+	if err != nil {
+		return nil, err
+	}
+	inUse := map[string][]string{}
+	for _, info := range containers {
+		for _, mount := range info.Mounts {
+			if mount.Type == container.MountTypeVolume {
+				inUse[mount.Name] = append(inUse[mount.Name], info.Name)
+			}
+		}
+	}
+	return inUse, nil
+}
@@ -0,0 +1,57 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// BuildCacheName 构建缓存文件，记录 (父镜像ID + 指令) 到构建结果镜像ID的映射
+const BuildCacheName = "buildcache.json"
+
+// BuildCacheKey 由父镜像ID、指令以及指令依赖内容(如 COPY 的文件摘要)计算缓存键
+func BuildCacheKey(parentID, instruction, contentDigest string) string {
+	sum := sha256.Sum256([]byte(parentID + "\n" + instruction + "\n" + contentDigest))
+	return hex.EncodeToString(sum[:])
+}
+
+// LookupBuildCache 查找缓存的构建结果，缓存的镜像已被删除时视为未命中
+func LookupBuildCache(key string) (*Image, bool) {
+	cache := loadBuildCache()
+	id, ok := cache[key]
+	if !ok {
+		return nil, false
+	}
+	img, err := loadImage(id)
+	if err != nil {
+		return nil, false
+	}
+	return img, true
+}
+
+// StoreBuildCache 记录一步构建的结果
+func StoreBuildCache(key, imageID string) error {
+	cache := loadBuildCache()
+	cache[key] = imageID
+	content, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(ImageRootUrl, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(ImageRootUrl, BuildCacheName), content, 0644)
+}
+
+// 加载构建缓存，文件不存在或损坏时返回空缓存
+func loadBuildCache() map[string]string {
+	cache := map[string]string{}
+	content, err := os.ReadFile(filepath.Join(ImageRootUrl, BuildCacheName))
+	if err != nil {
+		return cache
+	}
+	_ = json.Unmarshal(content, &cache)
+	return cache
+}
@@ -0,0 +1,271 @@
+package image
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Instruction Dockerfile 中的一条指令
+type Instruction struct {
+	Cmd      string            // 指令名，统一为大写，如 RUN
+	Args     []string          // 指令参数
+	Flags    map[string]string // 指令选项，如 COPY --chown=1000:1000 中的 chown
+	JSON     bool              // RUN/CMD/ENTRYPOINT 是否为 exec 格式(JSON数组)
+	Original string            // 原始指令文本，用于构建缓存和镜像历史
+	Line     int               // 指令所在行号
+}
+
+// 支持的指令
+var supportedInstructions = map[string]bool{
+	"FROM": true, "RUN": true, "COPY": true, "ADD": true, "ENV": true, "WORKDIR": true,
+	"CMD": true, "ENTRYPOINT": true, "USER": true, "LABEL": true, "EXPOSE": true,
+}
+
+// ParseDockerfile 解析 Dockerfile，返回按顺序排列的指令
+func ParseDockerfile(r io.Reader) ([]*Instruction, error) {
+	var instructions []*Instruction
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo, startLine := 0, 0
+	logical := ""
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		// 注释和空行, 在续行中同样忽略
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		if logical == "" {
+			startLine = lineNo
+		}
+		// 以'\'结尾表示指令在下一行继续
+		if strings.HasSuffix(line, "\\") {
+			logical += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		logical += line
+		inst, err := parseInstruction(logical, startLine)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, inst)
+		logical = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(logical) != "" {
+		inst, err := parseInstruction(logical, startLine)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, inst)
+	}
+	if len(instructions) == 0 || instructions[0].Cmd != "FROM" {
+		return nil, fmt.Errorf("dockerfile must begin with a FROM instruction")
+	}
+	return instructions, nil
+}
+
+// ParseDockerfileFromPath 从文件中解析 Dockerfile
+func ParseDockerfileFromPath(path string) ([]*Instruction, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseDockerfile(file)
+}
+
+// ParseInstruction 解析单条指令，如 import --change 指定的 "CMD [\"/bin/sh\"]"
+func ParseInstruction(line string) (*Instruction, error) {
+	return parseInstruction(line, 1)
+}
+
+// 解析单条指令
+func parseInstruction(line string, lineNo int) (*Instruction, error) {
+	line = strings.TrimSpace(line)
+	fields := strings.SplitN(line, " ", 2)
+	cmd := strings.ToUpper(fields[0])
+	if !supportedInstructions[cmd] {
+		return nil, fmt.Errorf("line %d: unknown instruction: %s", lineNo, fields[0])
+	}
+	rest := ""
+	if len(fields) == 2 {
+		rest = strings.TrimSpace(fields[1])
+	}
+	inst := &Instruction{Cmd: cmd, Original: cmd + " " + rest, Flags: map[string]string{}, Line: lineNo}
+
+	// 解析 --key=value 形式的选项
+	if cmd == "COPY" || cmd == "ADD" {
+		for strings.HasPrefix(rest, "--") {
+			parts := strings.SplitN(rest, " ", 2)
+			kv := strings.SplitN(strings.TrimPrefix(parts[0], "--"), "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("line %d: invalid flag %s", lineNo, parts[0])
+			}
+			inst.Flags[kv[0]] = kv[1]
+			rest = ""
+			if len(parts) == 2 {
+				rest = strings.TrimSpace(parts[1])
+			}
+		}
+	}
+
+	var err error
+	switch cmd {
+	case "RUN", "CMD", "ENTRYPOINT":
+		if args, ok := parseJSONArray(rest); ok {
+			inst.Args, inst.JSON = args, true
+		} else {
+			inst.Args = []string{rest}
+		}
+	case "COPY", "ADD":
+		if args, ok := parseJSONArray(rest); ok {
+			inst.Args = args
+		} else {
+			inst.Args = strings.Fields(rest)
+		}
+		if len(inst.Args) < 2 {
+			return nil, fmt.Errorf("line %d: %s requires at least two arguments", lineNo, cmd)
+		}
+	case "ENV", "LABEL":
+		inst.Args, err = parseKeyValues(rest)
+	case "FROM":
+		inst.Args = strings.Fields(rest)
+		if len(inst.Args) != 1 {
+			return nil, fmt.Errorf("line %d: FROM requires exactly one argument, multi-stage builds are not supported", lineNo)
+		}
+	case "EXPOSE":
+		inst.Args = strings.Fields(rest)
+	default:
+		inst.Args = []string{rest}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", lineNo, err)
+	}
+	if len(inst.Args) == 0 || (len(inst.Args) == 1 && inst.Args[0] == "") {
+		return nil, fmt.Errorf("line %d: %s requires at least one argument", lineNo, cmd)
+	}
+	return inst, nil
+}
+
+// 解析 exec 格式的参数，如 ["/bin/sh", "-c", "echo hi"]
+func parseJSONArray(s string) ([]string, bool) {
+	if !strings.HasPrefix(s, "[") {
+		return nil, false
+	}
+	var args []string
+	if err := json.Unmarshal([]byte(s), &args); err != nil {
+		return nil, false
+	}
+	return args, true
+}
+
+// 解析 ENV/LABEL 的参数，支持 key=value 和旧的 key value 两种格式
+// @return key=value 形式的数组
+func parseKeyValues(s string) ([]string, error) {
+	words, err := splitWords(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("missing key=value")
+	}
+	// 旧格式: ENV key value with spaces
+	if !strings.Contains(words[0], "=") {
+		key := words[0]
+		value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), key))
+		return []string{key + "=" + unquote(value)}, nil
+	}
+	for _, word := range words {
+		if !strings.Contains(word, "=") {
+			return nil, fmt.Errorf("invalid key=value: %s", word)
+		}
+	}
+	return words, nil
+}
+
+// 按空白分割单词，引号内的空白不分割，引号本身会被去掉
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '\\' && quote != '\'' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in: %s", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// 去掉值两端成对的引号
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// Expand 使用构建时的环境变量替换字符串中的 $VAR 和 ${VAR}
+func Expand(s string, env []string) string {
+	return os.Expand(s, func(key string) string {
+		return LookupEnv(env, key)
+	})
+}
+
+// LookupEnv 在 key=value 形式的环境变量数组中查找变量
+func LookupEnv(env []string, key string) string {
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], key+"=") {
+			return strings.TrimPrefix(env[i], key+"=")
+		}
+	}
+	return ""
+}
+
+// SetEnv 设置环境变量，已存在则替换
+func SetEnv(env []string, kv string) []string {
+	key := strings.SplitN(kv, "=", 2)[0]
+	for i := range env {
+		if strings.SplitN(env[i], "=", 2)[0] == key {
+			env[i] = kv
+			return env
+		}
+	}
+	return append(env, kv)
+}
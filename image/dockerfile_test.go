@@ -0,0 +1,64 @@
+package image
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDockerfile(t *testing.T) {
+	dockerfile := `# comment
+FROM busybox
+ENV PATH=/usr/bin:/bin GREETING="hello world"
+RUN echo $GREETING \
+    && mkdir /app
+COPY --chown=1000:1000 a.txt b.txt /app/
+CMD ["sh", "-c", "echo hi"]
+expose 80 53/udp
+`
+	instructions, err := ParseDockerfile(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instructions) != 6 {
+		t.Fatalf("expect 6 instructions, got %d", len(instructions))
+	}
+	env := instructions[1]
+	if env.Args[1] != "GREETING=hello world" {
+		t.Errorf("unexpected ENV args: %q", env.Args)
+	}
+	run := instructions[2]
+	if run.JSON || run.Args[0] != "echo $GREETING  && mkdir /app" {
+		t.Errorf("unexpected RUN args: %q", run.Args)
+	}
+	cp := instructions[3]
+	if cp.Flags["chown"] != "1000:1000" || len(cp.Args) != 3 {
+		t.Errorf("unexpected COPY: %q %v", cp.Args, cp.Flags)
+	}
+	cmd := instructions[4]
+	if !cmd.JSON || len(cmd.Args) != 3 {
+		t.Errorf("unexpected CMD: %q", cmd.Args)
+	}
+	if instructions[5].Cmd != "EXPOSE" || len(instructions[5].Args) != 2 {
+		t.Errorf("unexpected EXPOSE: %q", instructions[5].Args)
+	}
+}
+
+func TestParseDockerfileErrors(t *testing.T) {
+	for _, dockerfile := range []string{
+		"RUN echo hi",
+		"FROM busybox\nFOO bar",
+		"FROM busybox AS base",
+		"FROM busybox\nCOPY onlyone",
+	} {
+		if _, err := ParseDockerfile(strings.NewReader(dockerfile)); err == nil {
+			t.Errorf("expect error for dockerfile %q", dockerfile)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	env := []string{"A=1", "B=2", "A=3"}
+	if got := Expand("$A-${B}-$C", env); got != "3-2-" {
+		t.Errorf("unexpected expand result: %s", got)
+	}
+}
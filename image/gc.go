@@ -0,0 +1,312 @@
+package image
+
+import (
+	"MiniDocker/archive"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 中断的 pull/build/load 留下的临时文件超过该时间才会被回收，避免影响正在进行的操作
+const staleTmpAge = time.Hour
+
+// Images 返回本地仓库中的所有镜像
+func Images() ([]*Image, error) {
+	entries, err := os.ReadDir(imagesDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var images []*Image
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		img, err := loadImage(entry.Name())
+		if err != nil {
+			logrus.Warnf("skip broken image %s: %v", entry.Name(), err)
+			continue
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// Tags 返回镜像ID到镜像名的映射
+func Tags() (map[string][]string, error) {
+	repos, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string][]string{}
+	for name, id := range repos {
+		tags[id] = append(tags[id], name)
+	}
+	return tags, nil
+}
+
+// RemoveImage 删除镜像以及指向它的镜像名、构建缓存和签名，镜像的层由 GarbageCollect 回收
+func RemoveImage(id string) error {
+	repos, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	for name, imageID := range repos {
+		if imageID == id {
+			delete(repos, name)
+		}
+	}
+	if err := dumpRepositories(repos); err != nil {
+		return err
+	}
+
+	cache := loadBuildCache()
+	for key, imageID := range cache {
+		if imageID == id {
+			delete(cache, key)
+		}
+	}
+	content, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(ImageRootUrl, BuildCacheName), content, 0644); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(SignatureDir(id)); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(imagesDir(), strings.TrimPrefix(id, "sha256:")))
+}
+
+// PruneImages 删除无用的镜像，inUse 为容器正在使用的镜像ID
+// 默认只删除没有名字且不被构建缓存引用的镜像，all 为 true 时删除所有未被容器使用的镜像
+// @return 删除的镜像ID
+func PruneImages(all bool, inUse map[string]bool) ([]string, error) {
+	images, err := Images()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := Tags()
+	if err != nil {
+		return nil, err
+	}
+	cached := map[string]bool{}
+	for _, id := range loadBuildCache() {
+		cached[id] = true
+	}
+
+	var removed []string
+	for _, img := range images {
+		if inUse[img.ID] {
+			continue
+		}
+		if !all && (len(tags[img.ID]) > 0 || cached[img.ID]) {
+			continue
+		}
+		if err := RemoveImage(img.ID); err != nil {
+			return removed, err
+		}
+		removed = append(removed, img.ID)
+	}
+	return removed, nil
+}
+
+// GarbageCollect 删除不被任何镜像引用的数据块和层目录、层在 RemapUrl 下转换了属主的副本，以及中断的操作留下的临时文件
+// 仍被 overlay 挂载使用的层和副本不会被删除，pids 为运行中的容器的进程，其挂载命名空间中的挂载也一并检查
+// @return 回收的字节数
+func GarbageCollect(pids []int) (int64, error) {
+	images, err := Images()
+	if err != nil {
+		return 0, err
+	}
+	blobs, layers := referenced(images)
+	for _, dir := range mountedLayers(pids) {
+		layers[filepath.Base(dir)] = true
+	}
+	layers["empty"] = true
+
+	var reclaimed int64
+	sweep := func(dir string, keep map[string]bool) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		for _, entry := range entries {
+			if keep[entry.Name()] {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			if isTmpEntry(entry.Name()) && !isStale(path) {
+				continue
+			}
+			size, _ := archive.DirSize(path)
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			logrus.Infof("remove %s", path)
+			reclaimed += size
+		}
+		return nil
+	}
+	if err := sweep(filepath.Join(ImageRootUrl, "blobs", "sha256"), blobs); err != nil {
+		return reclaimed, err
+	}
+	if err := sweep(filepath.Join(ImageRootUrl, "layers"), layers); err != nil {
+		return reclaimed, err
+	}
+	// 副本以层的 diffID 命名，未被挂载的 tar 镜像的副本也一并删除
+	pairs, err := os.ReadDir(RemapUrl)
+	if err != nil && !os.IsNotExist(err) {
+		return reclaimed, err
+	}
+	for _, pair := range pairs {
+		if err := sweep(filepath.Join(RemapUrl, pair.Name()), layers); err != nil {
+			return reclaimed, err
+		}
+	}
+
+	// load 命令解压使用的临时目录
+	entries, err := os.ReadDir(ImageRootUrl)
+	if err != nil {
+		return reclaimed, err
+	}
+	tmpDirs := map[string]bool{}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".load-") {
+			tmpDirs[entry.Name()] = true
+		}
+	}
+	return reclaimed, sweep(ImageRootUrl, tmpDirs)
+}
+
+// DiskUsage 统计本地仓库和 RemapUrl 占用的空间，以及 active 中的镜像所引用的数据块、层和层的副本占用的空间
+func DiskUsage(active map[string]bool) (int64, int64, error) {
+	size, err := archive.DirSize(ImageRootUrl)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	remapSize, err := archive.DirSize(RemapUrl)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+	size += remapSize
+	images, err := Images()
+	if err != nil {
+		return 0, 0, err
+	}
+	var activeImages []*Image
+	for _, img := range images {
+		if active[img.ID] {
+			activeImages = append(activeImages, img)
+		}
+	}
+	blobs, layers := referenced(activeImages)
+	var activeSize int64
+	for blob := range blobs {
+		if info, err := os.Stat(blobPath(blob)); err == nil {
+			activeSize += info.Size()
+		}
+	}
+	for layer := range layers {
+		layerSize, _ := archive.DirSize(LayerDir(layer))
+		activeSize += layerSize
+	}
+	pairs, _ := os.ReadDir(RemapUrl)
+	for _, pair := range pairs {
+		for layer := range layers {
+			layerSize, _ := archive.DirSize(filepath.Join(RemapUrl, pair.Name(), layer))
+			activeSize += layerSize
+		}
+	}
+	return size, activeSize, nil
+}
+
+// 镜像引用的数据块(manifest、配置、层)和层目录，均以摘要的十六进制表示
+func referenced(images []*Image) (map[string]bool, map[string]bool) {
+	blobs, layers := map[string]bool{}, map[string]bool{}
+	hexOf := func(digest string) string {
+		return strings.TrimPrefix(digest, "sha256:")
+	}
+	for _, img := range images {
+		blobs[hexOf(img.ID)] = true
+		blobs[hexOf(img.Manifest.Config.Digest)] = true
+		for _, layer := range img.Manifest.Layers {
+			blobs[hexOf(layer.Digest)] = true
+		}
+		for _, diffID := range img.Config.RootFS.DiffIDs {
+			layers[hexOf(diffID)] = true
+		}
+	}
+	return blobs, layers
+}
+
+/*
+mountedLayers 从 /proc/self/mounts 和 pids 的 /proc/<pid>/mounts 中找出作为 overlay lowerdir 挂载的层目录和 RemapUrl 下的层副本
+rootless 模式下每条命令都在新的挂载命名空间中执行，运行中的容器的 overlay 只在容器进程的挂载命名空间中可见
+*/
+func mountedLayers(pids []int) []string {
+	files := []string{"/proc/self/mounts"}
+	for _, pid := range pids {
+		files = append(files, fmt.Sprintf("/proc/%d/mounts", pid))
+	}
+	var dirs []string
+	for _, file := range files {
+		dirs = append(dirs, overlayLowerDirs(file)...)
+	}
+	return dirs
+}
+
+// 从挂载信息文件中找出作为 overlay lowerdir 的层目录和层副本，进程已退出时忽略
+func overlayLowerDirs(mountsFile string) []string {
+	file, err := os.Open(mountsFile)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	layersDir := filepath.Join(ImageRootUrl, "layers")
+	remapDir := filepath.Clean(RemapUrl)
+	var dirs []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[2] != "overlay" {
+			continue
+		}
+		for _, option := range strings.Split(fields[3], ",") {
+			if !strings.HasPrefix(option, "lowerdir=") {
+				continue
+			}
+			for _, dir := range strings.Split(strings.TrimPrefix(option, "lowerdir="), ":") {
+				if filepath.Dir(dir) == layersDir || filepath.Dir(filepath.Dir(dir)) == remapDir {
+					dirs = append(dirs, dir)
+				}
+			}
+		}
+	}
+	return dirs
+}
+
+// 判断是否为写入过程中使用的临时文件
+func isTmpEntry(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp")
+}
+
+// 判断临时文件是否已经长时间未修改
+func isStale(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && time.Since(info.ModTime()) > staleTmpAge
+}
@@ -0,0 +1,168 @@
+package image
+
+import (
+	"MiniDocker/archive"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 创建只有一层的镜像，层中包含内容为 content 的文件
+func createTestImage(t *testing.T, content string) (*Image, string) {
+	t.Helper()
+	layerDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(layerDir, "data"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	desc, diffID, err := StoreLayerFromDir(layerDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewImageConfig()
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	img, err := CreateImage(config, []Descriptor{desc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img, diffID
+}
+
+func TestPruneAndGarbageCollect(t *testing.T) {
+	ImageRootUrl = t.TempDir()
+	tagged, taggedLayer := createTestImage(t, "tagged")
+	if err := TagImage(tagged.ID, "app:v1"); err != nil {
+		t.Fatal(err)
+	}
+	dangling, danglingLayer := createTestImage(t, "dangling")
+	used, usedLayer := createTestImage(t, "used")
+	cached, _ := createTestImage(t, "cached")
+	if err := StoreBuildCache("key", cached.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := PruneImages(false, map[string]bool{used.ID: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != dangling.ID {
+		t.Fatalf("removed %v, expect only %s", removed, dangling.ID)
+	}
+	reclaimed, err := GarbageCollect(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed == 0 {
+		t.Error("expect reclaimed space")
+	}
+	if exist, _ := pathExists(LayerDir(danglingLayer)); exist {
+		t.Error("layer of dangling image not removed")
+	}
+	if HasBlob(dangling.Manifest.Layers[0].Digest) || HasBlob(dangling.ID) {
+		t.Error("blobs of dangling image not removed")
+	}
+	for _, layer := range []string{taggedLayer, usedLayer} {
+		if exist, _ := pathExists(LayerDir(layer)); !exist {
+			t.Errorf("layer %s removed", layer)
+		}
+	}
+
+	// all 删除除正在使用的镜像之外的所有镜像，包括构建缓存
+	removed, err = PruneImages(true, map[string]bool{used.ID: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 {
+		t.Fatalf("removed %v, expect tagged and cached images", removed)
+	}
+	if _, err := GetImage("app:v1"); err == nil {
+		t.Error("tag of removed image still exists")
+	}
+	if _, ok := LookupBuildCache("key"); ok {
+		t.Error("build cache of removed image still exists")
+	}
+	if _, err := GetImage(used.ID); err != nil {
+		t.Errorf("image in use removed: %v", err)
+	}
+}
+
+func TestGarbageCollectRemappedLayers(t *testing.T) {
+	ImageRootUrl = t.TempDir()
+	RemapUrl = t.TempDir()
+	kept, keptLayer := createTestImage(t, "kept")
+	removed, removedLayer := createTestImage(t, "removed")
+	pairDir := filepath.Join(RemapUrl, "100000.100000")
+	for _, name := range []string{strings.TrimPrefix(keptLayer, "sha256:"), strings.TrimPrefix(removedLayer, "sha256:"), "dir-legacy"} {
+		if err := os.MkdirAll(filepath.Join(pairDir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(pairDir, name, "data"), []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	total, active, err := DiskUsage(map[string]bool{kept.ID: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	layerSize, _ := archive.DirSize(LayerDir(keptLayer))
+	if total <= active || active < layerSize+int64(len("content")) {
+		t.Errorf("disk usage %d, active %d does not include remapped layers", total, active)
+	}
+
+	if err := RemoveImage(removed.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GarbageCollect(nil); err != nil {
+		t.Fatal(err)
+	}
+	if exist, _ := pathExists(filepath.Join(pairDir, strings.TrimPrefix(keptLayer, "sha256:"))); !exist {
+		t.Error("remapped copy of kept layer removed")
+	}
+	for _, name := range []string{strings.TrimPrefix(removedLayer, "sha256:"), "dir-legacy"} {
+		if exist, _ := pathExists(filepath.Join(pairDir, name)); exist {
+			t.Errorf("remapped copy %s not removed", name)
+		}
+	}
+}
+
+func TestGetImageByIDAfterRetag(t *testing.T) {
+	ImageRootUrl = t.TempDir()
+	old, _ := createTestImage(t, "old")
+	if err := TagImage(old.ID, "app:v1"); err != nil {
+		t.Fatal(err)
+	}
+	retagged, _ := createTestImage(t, "new")
+	if err := TagImage(retagged.ID, "app:v1"); err != nil {
+		t.Fatal(err)
+	}
+	if img, err := GetImageByID(old.ID); err != nil || img.ID != old.ID {
+		t.Errorf("get %s after retag: got %v, %v", old.ID, img, err)
+	}
+	// 只接受完整的镜像ID，不解析镜像名和ID前缀
+	for _, ref := range []string{"app:v1", old.ID[:19], "sha256:../../images"} {
+		if _, err := GetImageByID(ref); err == nil {
+			t.Errorf("%s: expected error", ref)
+		}
+	}
+}
+
+func TestOverlayLowerDirs(t *testing.T) {
+	ImageRootUrl = "/data/image/"
+	RemapUrl = "/data/.remap"
+	layer := "/data/image/layers/" + strings.Repeat("0a", 32)
+	remapped := "/data/.remap/100000.100000/" + strings.Repeat("0b", 32)
+	mounts := filepath.Join(t.TempDir(), "mounts")
+	content := "proc /proc proc rw 0 0\n" +
+		"overlay / overlay rw,lowerdir=" + layer + ":" + remapped + ":/other/layer,upperdir=/data/writeLayer/c1,workdir=/data/.tmpWork/c1 0 0\n"
+	if err := os.WriteFile(mounts, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if dirs := overlayLowerDirs(mounts); len(dirs) != 2 || dirs[0] != layer || dirs[1] != remapped {
+		t.Errorf("got %v, expect %s and %s", dirs, layer, remapped)
+	}
+	// 进程已退出
+	if dirs := overlayLowerDirs(filepath.Join(t.TempDir(), "missing")); dirs != nil {
+		t.Errorf("got %v for missing mounts file", dirs)
+	}
+}
@@ -0,0 +1,496 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	MediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeIndex    = "application/vnd.oci.image.index.v1+json"
+	MediaTypeConfig   = "application/vnd.oci.image.config.v1+json"
+	MediaTypeLayer    = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	// Docker 镜像仓库使用的格式，结构与 OCI 格式一致
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerConfig       = "application/vnd.docker.container.image.v1+json"
+)
+
+var (
+	// ImageRootUrl 本地镜像仓库根目录
+	// blobs/sha256/<hex>: 按内容寻址的镜像数据(层、配置、manifest)
+	// images/<hex>: 本地所有镜像的 manifest, 文件名即镜像ID
+	// layers/<hex>: 解压后的镜像层目录, 作为 overlay 的 lowerdir
+	// repositories.json: 镜像名到镜像ID的映射
+	ImageRootUrl     = "/root/image/"
+	RepositoriesName = "repositories.json"
+	// RemapUrl 使用用户命名空间的容器所用的、转换了属主的镜像层副本: <uid>.<gid>/<diffID 的十六进制>
+	// 副本由 container 包创建，对应的层被回收时由 GarbageCollect 一并回收
+	RemapUrl = "/root/.remap"
+)
+
+// SetRoot 设置数据根目录，本地镜像仓库位于 root/image 下
+func SetRoot(root string) {
+	ImageRootUrl = filepath.Join(root, "image") + "/"
+	RemapUrl = filepath.Join(root, ".remap")
+}
+
+// Descriptor 描述一个按内容寻址的数据块
+type Descriptor struct {
+	MediaType string    `json:"mediaType"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	Platform  *Platform `json:"platform,omitempty"` // 仅在 Index 中用于区分不同平台的镜像
+
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Platform 镜像适用的平台
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Index 多平台镜像的索引(manifest list)，每一项指向一个平台的 Manifest
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Manifest 镜像清单，记录镜像配置和各个层
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// ContainerConfig 镜像中记录的容器默认运行配置
+type ContainerConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+}
+
+// RootFS 镜像的文件系统，按顺序记录各层未压缩内容的摘要
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// History 镜像每一步构建的记录
+type History struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
+
+// ImageConfig OCI 镜像配置
+type ImageConfig struct {
+	Created      string          `json:"created,omitempty"`
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Config       ContainerConfig `json:"config"`
+	RootFS       RootFS          `json:"rootfs"`
+	History      []History       `json:"history,omitempty"`
+}
+
+// Image 本地仓库中的一个镜像
+type Image struct {
+	ID       string // manifest 的摘要
+	Manifest *Manifest
+	Config   *ImageConfig
+}
+
+// NewImageConfig 创建一个不含任何层的空镜像配置
+func NewImageConfig() *ImageConfig {
+	return &ImageConfig{
+		Created:      time.Now().UTC().Format(time.RFC3339Nano),
+		Architecture: runtime.GOARCH,
+		OS:           runtime.GOOS,
+		RootFS:       RootFS{Type: "layers", DiffIDs: []string{}},
+	}
+}
+
+// Clone 深拷贝镜像配置，构建时在父镜像配置的基础上修改
+func (c *ImageConfig) Clone() *ImageConfig {
+	bytes, _ := json.Marshal(c)
+	clone := &ImageConfig{}
+	_ = json.Unmarshal(bytes, clone)
+	return clone
+}
+
+// ShortID 镜像ID的前12位，用于展示
+func (img *Image) ShortID() string {
+	return ShortID(img.ID)
+}
+
+// ShortID 截取摘要的前12位
+func ShortID(digest string) string {
+	hexStr := strings.TrimPrefix(digest, "sha256:")
+	if len(hexStr) > 12 {
+		return hexStr[:12]
+	}
+	return hexStr
+}
+
+// Digest 计算数据的 sha256 摘要
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// 摘要的格式, 摘要会作为数据块和层目录的文件名, 也会出现在 overlay 的 lowerdir 中
+var digestRegexp = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// ValidDigest 校验来自镜像仓库或导入文件的摘要，拒绝 sha256:../.. 等可以逃出本地镜像仓库的值
+func ValidDigest(digest string) error {
+	if !digestRegexp.MatchString(digest) {
+		return fmt.Errorf("invalid digest %q", digest)
+	}
+	return nil
+}
+
+// ValidateManifest 校验 manifest 中配置和各层的摘要
+func ValidateManifest(manifest *Manifest) error {
+	for _, desc := range append([]Descriptor{manifest.Config}, manifest.Layers...) {
+		if err := ValidDigest(desc.Digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 校验镜像配置中各层的 diffID
+func validateDiffIDs(config *ImageConfig) error {
+	for _, diffID := range config.RootFS.DiffIDs {
+		if err := ValidDigest(diffID); err != nil {
+			return fmt.Errorf("invalid diff id: %v", err)
+		}
+	}
+	return nil
+}
+
+// 各目录路径
+func blobPath(digest string) string {
+	return filepath.Join(ImageRootUrl, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+func imagesDir() string {
+	return filepath.Join(ImageRootUrl, "images")
+}
+
+// LayerDir 返回层解压后的目录
+func LayerDir(diffID string) string {
+	return filepath.Join(ImageRootUrl, "layers", strings.TrimPrefix(diffID, "sha256:"))
+}
+
+// SignatureDir 返回镜像签名的存储目录
+func SignatureDir(id string) string {
+	return filepath.Join(ImageRootUrl, "signatures", strings.TrimPrefix(id, "sha256:"))
+}
+
+// WriteBlob 写入一个数据块并返回其描述符
+func WriteBlob(mediaType string, data []byte) (Descriptor, error) {
+	desc := Descriptor{MediaType: mediaType, Digest: Digest(data), Size: int64(len(data))}
+	path := blobPath(desc.Digest)
+	if exist, _ := pathExists(path); exist {
+		return desc, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return desc, err
+	}
+	return desc, os.WriteFile(path, data, 0644)
+}
+
+// ReadBlob 读取数据块
+func ReadBlob(digest string) ([]byte, error) {
+	return os.ReadFile(blobPath(digest))
+}
+
+// OpenBlob 打开数据块用于流式读取
+func OpenBlob(digest string) (*os.File, error) {
+	return os.Open(blobPath(digest))
+}
+
+// HasBlob 判断数据块是否已存在于本地仓库
+func HasBlob(digest string) bool {
+	exist, _ := pathExists(blobPath(digest))
+	return exist
+}
+
+// WriteBlobFrom 从数据流写入数据块，写入的内容必须与给定的摘要一致
+func WriteBlobFrom(digest string, r io.Reader) error {
+	path := blobPath(digest)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), ".tmp-blob-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmpFile, hash), r); err != nil {
+		return err
+	}
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != digest {
+		return fmt.Errorf("digest mismatch: expect %s, got %s", digest, actual)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// CreateImage 根据镜像配置和层描述符在本地仓库中创建镜像
+func CreateImage(config *ImageConfig, layers []Descriptor) (*Image, error) {
+	if len(config.RootFS.DiffIDs) != len(layers) {
+		return nil, fmt.Errorf("config has %d diff ids but %d layers given", len(config.RootFS.DiffIDs), len(layers))
+	}
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return createImage(configBytes, config, layers)
+}
+
+// CreateImageFromConfig 使用原始的镜像配置内容创建镜像，保证配置的摘要不变(如导入其它主机导出的镜像)
+func CreateImageFromConfig(configBytes []byte, layers []Descriptor) (*Image, error) {
+	config := &ImageConfig{}
+	if err := json.Unmarshal(configBytes, config); err != nil {
+		return nil, fmt.Errorf("unmarshal image config fails: %v", err)
+	}
+	if len(config.RootFS.DiffIDs) != len(layers) {
+		return nil, fmt.Errorf("config has %d diff ids but %d layers given", len(config.RootFS.DiffIDs), len(layers))
+	}
+	return createImage(configBytes, config, layers)
+}
+
+// 写入镜像配置并生成 manifest
+func createImage(configBytes []byte, config *ImageConfig, layers []Descriptor) (*Image, error) {
+	configDesc, err := WriteBlob(MediaTypeConfig, configBytes)
+	if err != nil {
+		return nil, fmt.Errorf("write config blob fails: %v", err)
+	}
+	manifest := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeManifest,
+		Config:        configDesc,
+		Layers:        append([]Descriptor{}, layers...),
+	}
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return saveManifest(manifestBytes, manifest, config)
+}
+
+// ImportManifest 将从其它地方(如镜像仓库)得到的 manifest 原样保存为本地镜像，保证镜像ID不变
+// manifest 引用的配置和层必须已经写入本地仓库
+func ImportManifest(manifestBytes []byte) (*Image, error) {
+	manifest := &Manifest{}
+	if err := json.Unmarshal(manifestBytes, manifest); err != nil {
+		return nil, fmt.Errorf("unmarshal manifest fails: %v", err)
+	}
+	if err := ValidateManifest(manifest); err != nil {
+		return nil, err
+	}
+	configBytes, err := ReadBlob(manifest.Config.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("read image config fails: %v", err)
+	}
+	config := &ImageConfig{}
+	if err := json.Unmarshal(configBytes, config); err != nil {
+		return nil, fmt.Errorf("unmarshal image config fails: %v", err)
+	}
+	if err := validateDiffIDs(config); err != nil {
+		return nil, err
+	}
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("config has %d diff ids but manifest has %d layers", len(config.RootFS.DiffIDs), len(manifest.Layers))
+	}
+	for i, layer := range manifest.Layers {
+		if err := unpackLayer(layer.Digest, config.RootFS.DiffIDs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return saveManifest(manifestBytes, manifest, config)
+}
+
+// ManifestBytes 读取镜像 manifest 的原始内容
+func ManifestBytes(id string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(imagesDir(), strings.TrimPrefix(id, "sha256:")))
+}
+
+// 保存 manifest 到 images 目录，manifest 的摘要即镜像ID
+func saveManifest(manifestBytes []byte, manifest *Manifest, config *ImageConfig) (*Image, error) {
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = MediaTypeManifest
+	}
+	desc, err := WriteBlob(mediaType, manifestBytes)
+	if err != nil {
+		return nil, fmt.Errorf("write manifest blob fails: %v", err)
+	}
+	if err := os.MkdirAll(imagesDir(), 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(imagesDir(), strings.TrimPrefix(desc.Digest, "sha256:"))
+	if err := os.WriteFile(path, manifestBytes, 0644); err != nil {
+		return nil, err
+	}
+	return &Image{ID: desc.Digest, Manifest: manifest, Config: config}, nil
+}
+
+// GetImage 通过镜像名(name[:tag])、镜像ID或ID前缀查找本地镜像
+func GetImage(ref string) (*Image, error) {
+	id, err := resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	return loadImage(id)
+}
+
+// GetImageByID 通过完整的镜像ID查找本地镜像，不解析镜像名
+func GetImageByID(id string) (*Image, error) {
+	if err := ValidDigest(id); err != nil {
+		return nil, err
+	}
+	return loadImage(id)
+}
+
+// 从 images 目录加载镜像
+func loadImage(id string) (*Image, error) {
+	path := filepath.Join(imagesDir(), strings.TrimPrefix(id, "sha256:"))
+	manifestBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(manifestBytes, manifest); err != nil {
+		return nil, fmt.Errorf("unmarshal manifest %s fails: %v", id, err)
+	}
+	configBytes, err := ReadBlob(manifest.Config.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("read config of image %s fails: %v", id, err)
+	}
+	config := &ImageConfig{}
+	if err := json.Unmarshal(configBytes, config); err != nil {
+		return nil, fmt.Errorf("unmarshal config of image %s fails: %v", id, err)
+	}
+	return &Image{ID: "sha256:" + strings.TrimPrefix(id, "sha256:"), Manifest: manifest, Config: config}, nil
+}
+
+// 将镜像引用解析为镜像ID
+func resolve(ref string) (string, error) {
+	repos, err := loadRepositories()
+	if err != nil {
+		return "", err
+	}
+	if id, ok := repos[NormalizeName(ref)]; ok {
+		return id, nil
+	}
+	// 按ID或ID前缀查找
+	prefix := strings.TrimPrefix(ref, "sha256:")
+	if len(prefix) == 0 || strings.Trim(prefix, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("no such image: %s", ref)
+	}
+	entries, err := os.ReadDir(imagesDir())
+	if err != nil {
+		return "", fmt.Errorf("no such image: %s", ref)
+	}
+	found := ""
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), prefix) {
+			if found != "" {
+				return "", fmt.Errorf("image id prefix %s is ambiguous", ref)
+			}
+			found = "sha256:" + entry.Name()
+		}
+	}
+	if found == "" {
+		return "", fmt.Errorf("no such image: %s", ref)
+	}
+	return found, nil
+}
+
+// NormalizeName 为未指定tag的镜像名补全默认的 latest
+func NormalizeName(name string) string {
+	// 最后一个'/'之后的':'才是tag分隔符，避免把仓库地址的端口当作tag
+	if strings.LastIndex(name, ":") > strings.LastIndex(name, "/") {
+		return name
+	}
+	return name + ":latest"
+}
+
+// TagImage 为镜像设置名字
+func TagImage(id, name string) error {
+	repos, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	repos[NormalizeName(name)] = id
+	return dumpRepositories(repos)
+}
+
+// 加载镜像名到镜像ID的映射
+func loadRepositories() (map[string]string, error) {
+	repos := map[string]string{}
+	content, err := os.ReadFile(filepath.Join(ImageRootUrl, RepositoriesName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return repos, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &repos); err != nil {
+		logrus.Errorf("unmarshal repositories fails: %v", err)
+		return nil, err
+	}
+	return repos, nil
+}
+
+// 保存镜像名到镜像ID的映射
+func dumpRepositories(repos map[string]string) error {
+	if err := os.MkdirAll(ImageRootUrl, 0755); err != nil {
+		return err
+	}
+	content, err := json.Marshal(repos)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(ImageRootUrl, RepositoriesName), content, 0644)
+}
+
+// 判断文件路径是否存在
+func pathExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
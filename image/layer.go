@@ -0,0 +1,328 @@
+package image
+
+import (
+	"MiniDocker/archive"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StoreLayerFromDir 将目录(通常是容器的 upper 层)打包为镜像层并存入本地仓库
+// @return 压缩后层的描述符, 未压缩内容的摘要(diffID)
+func StoreLayerFromDir(dir string) (Descriptor, string, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(archive.TarLayer(dir, writer))
+	}()
+	defer reader.Close()
+	return StoreLayer(reader)
+}
+
+// StoreLayer 将 tar 流(可以是压缩过的)以 gzip 格式存入本地仓库，并解压到层目录
+func StoreLayer(r io.Reader) (Descriptor, string, error) {
+	desc := Descriptor{MediaType: MediaTypeLayer}
+	stream, err := archive.DecompressStream(r)
+	if err != nil {
+		return desc, "", fmt.Errorf("decompress layer fails: %v", err)
+	}
+	defer stream.Close()
+
+	blobDir := filepath.Dir(blobPath("tmp"))
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		return desc, "", err
+	}
+	tmpFile, err := os.CreateTemp(blobDir, ".tmp-layer-")
+	if err != nil {
+		return desc, "", err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	// 同时计算未压缩内容的摘要和压缩后数据的摘要
+	diffHash, blobHash := sha256.New(), sha256.New()
+	counter := &countWriter{}
+	gzWriter := gzip.NewWriter(io.MultiWriter(tmpFile, blobHash, counter))
+	if _, err := io.Copy(io.MultiWriter(gzWriter, diffHash), stream); err != nil {
+		return desc, "", fmt.Errorf("write layer blob fails: %v", err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		return desc, "", err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return desc, "", err
+	}
+
+	desc.Digest = "sha256:" + hex.EncodeToString(blobHash.Sum(nil))
+	desc.Size = counter.n
+	diffID := "sha256:" + hex.EncodeToString(diffHash.Sum(nil))
+	if err := os.Rename(tmpFile.Name(), blobPath(desc.Digest)); err != nil {
+		return desc, "", err
+	}
+	if err := unpackLayer(desc.Digest, diffID); err != nil {
+		return desc, "", err
+	}
+	return desc, diffID, nil
+}
+
+/*
+将层数据块解压到层目录，已解压过的层直接跳过
+层目录由所有镜像共享, 解压时计算未压缩内容的摘要, 与 diffID 不一致时丢弃, 避免镜像以其他镜像的 diffID 替换共享的层
+*/
+func unpackLayer(blobDigest, diffID string) error {
+	if err := ValidDigest(blobDigest); err != nil {
+		return err
+	}
+	if err := ValidDigest(diffID); err != nil {
+		return fmt.Errorf("invalid diff id: %v", err)
+	}
+	layerDir := LayerDir(diffID)
+	if exist, _ := pathExists(layerDir); exist {
+		return nil
+	}
+	blob, err := os.Open(blobPath(blobDigest))
+	if err != nil {
+		return fmt.Errorf("open layer blob %s fails: %v", blobDigest, err)
+	}
+	defer blob.Close()
+	stream, err := archive.DecompressStream(blob)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	// 先解压到临时目录，成功后再重命名，避免留下不完整的层
+	tmpDir := layerDir + ".tmp"
+	_ = os.RemoveAll(tmpDir)
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	tee := io.TeeReader(stream, hash)
+	if err := archive.UntarLayer(tee, tmpDir); err != nil {
+		_ = os.RemoveAll(tmpDir)
+		return fmt.Errorf("unpack layer %s fails: %v", diffID, err)
+	}
+	// tar 结束标记之后可能还有填充数据, 也计入摘要
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		_ = os.RemoveAll(tmpDir)
+		return fmt.Errorf("read layer %s fails: %v", diffID, err)
+	}
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != diffID {
+		_ = os.RemoveAll(tmpDir)
+		return fmt.Errorf("layer %s diff id mismatch: got %s", diffID, actual)
+	}
+	return os.Rename(tmpDir, layerDir)
+}
+
+/*
+VerifyContent 校验本地仓库中镜像的内容与镜像ID一致，签名只覆盖镜像ID(manifest 的摘要)
+1.manifest 的摘要等于镜像ID，配置和各层数据块的摘要与 manifest 中的一致
+2.各层数据块解压后的摘要与配置中的 diffID 一致
+层目录只在解压后的内容与 diffID 一致时才会创建，因此运行时挂载的层与签名的镜像一致
+*/
+func VerifyContent(img *Image) error {
+	manifestBytes, err := ManifestBytes(img.ID)
+	if err != nil {
+		return err
+	}
+	if actual := Digest(manifestBytes); actual != img.ID {
+		return fmt.Errorf("manifest digest mismatch: expect %s, got %s", img.ID, actual)
+	}
+	if err := ValidateManifest(img.Manifest); err != nil {
+		return err
+	}
+	if err := validateDiffIDs(img.Config); err != nil {
+		return err
+	}
+	if len(img.Config.RootFS.DiffIDs) != len(img.Manifest.Layers) {
+		return fmt.Errorf("config has %d diff ids but manifest has %d layers", len(img.Config.RootFS.DiffIDs), len(img.Manifest.Layers))
+	}
+	if _, err := verifyBlob(img.Manifest.Config.Digest, false); err != nil {
+		return err
+	}
+	for i, layer := range img.Manifest.Layers {
+		diffID, err := verifyBlob(layer.Digest, true)
+		if err != nil {
+			return err
+		}
+		if diffID != img.Config.RootFS.DiffIDs[i] {
+			return fmt.Errorf("layer %s diff id mismatch: expect %s, got %s", layer.Digest, img.Config.RootFS.DiffIDs[i], diffID)
+		}
+	}
+	return nil
+}
+
+// 校验数据块的摘要, layer 为 true 时同时返回解压后内容的摘要
+func verifyBlob(digest string, layer bool) (string, error) {
+	blob, err := OpenBlob(digest)
+	if err != nil {
+		return "", err
+	}
+	defer blob.Close()
+	blobHash := sha256.New()
+	reader := io.TeeReader(blob, blobHash)
+	diffID := ""
+	if layer {
+		stream, err := archive.DecompressStream(reader)
+		if err != nil {
+			return "", err
+		}
+		diffHash := sha256.New()
+		_, err = io.Copy(diffHash, stream)
+		stream.Close()
+		if err != nil {
+			return "", fmt.Errorf("read layer %s fails: %v", digest, err)
+		}
+		diffID = "sha256:" + hex.EncodeToString(diffHash.Sum(nil))
+	}
+	// 压缩流结束后可能还有剩余数据
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return "", err
+	}
+	if actual := "sha256:" + hex.EncodeToString(blobHash.Sum(nil)); actual != digest {
+		return "", fmt.Errorf("blob digest mismatch: expect %s, got %s", digest, actual)
+	}
+	return diffID, nil
+}
+
+// LayerDirs 返回镜像各层解压后的目录，按 overlay lowerdir 的要求从上层到下层排列
+func LayerDirs(img *Image) ([]string, error) {
+	diffIDs := img.Config.RootFS.DiffIDs
+	if len(diffIDs) != len(img.Manifest.Layers) {
+		return nil, fmt.Errorf("config has %d diff ids but manifest has %d layers", len(diffIDs), len(img.Manifest.Layers))
+	}
+	if err := ValidateManifest(img.Manifest); err != nil {
+		return nil, err
+	}
+	if err := validateDiffIDs(img.Config); err != nil {
+		return nil, err
+	}
+	dirs := make([]string, 0, len(diffIDs))
+	for i := len(diffIDs) - 1; i >= 0; i-- {
+		if err := unpackLayer(img.Manifest.Layers[i].Digest, diffIDs[i]); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, LayerDir(diffIDs[i]))
+	}
+	// overlay 至少需要一个 lowerdir, 空镜像使用一个空目录
+	if len(dirs) == 0 {
+		emptyDir := LayerDir("empty")
+		if err := os.MkdirAll(emptyDir, 0755); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, emptyDir)
+	}
+	return dirs, nil
+}
+
+// ImportImage 将根文件系统的 tar 包导入为只有一层的镜像并命名
+func ImportImage(tarPath, name, comment string) (*Image, error) {
+	file, err := os.Open(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, err := ImportImageFrom(file, name, comment, NewImageConfig())
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("import %s as image %s", tarPath, img.ShortID())
+	return img, nil
+}
+
+// ImportImageFrom 将 tar 流(可以是压缩过的)作为唯一的层导入为镜像，config 为镜像的运行配置
+func ImportImageFrom(r io.Reader, name, comment string, config *ImageConfig) (*Image, error) {
+	desc, diffID, err := StoreLayer(r)
+	if err != nil {
+		return nil, err
+	}
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	config.History = append(config.History, History{
+		Created: time.Now().UTC().Format(time.RFC3339Nano),
+		Comment: comment,
+	})
+	img, err := CreateImage(config, []Descriptor{desc})
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		if err := TagImage(img.ID, name); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// 统计写入字节数
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// MissingID 构建记录对应的中间镜像不在本地时显示的镜像ID，与 docker 一致
+const MissingID = "<missing>"
+
+// LayerHistory 镜像的一条构建记录，不产生层的记录 DiffID 为空
+type LayerHistory struct {
+	History
+	ID     string // 记录对应的镜像ID，只有最新的一条记录对应的镜像是已知的，其余为 MissingID
+	DiffID string
+	Size   int64 // 层解压后的大小
+}
+
+// LayerHistory 将镜像的构建记录与各层对应起来，按从旧到新的顺序返回
+func (img *Image) LayerHistory() ([]LayerHistory, error) {
+	diffIDs := img.Config.RootFS.DiffIDs
+	var result []LayerHistory
+	layer := 0
+	for _, history := range img.Config.History {
+		item := LayerHistory{History: history}
+		if !history.EmptyLayer && layer < len(diffIDs) {
+			size, err := layerSize(img, layer)
+			if err != nil {
+				return nil, err
+			}
+			item.DiffID, item.Size = diffIDs[layer], size
+			layer++
+		}
+		result = append(result, item)
+	}
+	// 从其他工具导入的镜像可能缺少构建记录
+	for ; layer < len(diffIDs); layer++ {
+		size, err := layerSize(img, layer)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, LayerHistory{DiffID: diffIDs[layer], Size: size})
+	}
+	for i := range result {
+		result[i].ID = MissingID
+	}
+	if len(result) > 0 {
+		result[len(result)-1].ID = img.ID
+	}
+	return result, nil
+}
+
+// 得到镜像第 i 层解压后的大小
+func layerSize(img *Image, i int) (int64, error) {
+	diffID := img.Config.RootFS.DiffIDs[i]
+	if err := unpackLayer(img.Manifest.Layers[i].Digest, diffID); err != nil {
+		return 0, err
+	}
+	size, err := archive.DirSize(LayerDir(diffID))
+	if err != nil {
+		return 0, fmt.Errorf("get size of layer %s fails: %v", diffID, err)
+	}
+	return size, nil
+}
@@ -0,0 +1,164 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidDigest(t *testing.T) {
+	valid := "sha256:" + strings.Repeat("0a", 32)
+	if err := ValidDigest(valid); err != nil {
+		t.Errorf("%s: %v", valid, err)
+	}
+	for _, digest := range []string{"", "sha256:../../etc", "sha256:" + strings.Repeat("0A", 32), "sha512:" + strings.Repeat("0a", 32), valid + ",upperdir=/", valid[:len(valid)-1]} {
+		if err := ValidDigest(digest); err == nil {
+			t.Errorf("%q: expected error", digest)
+		}
+	}
+}
+
+func TestUnpackLayerVerifiesDiffID(t *testing.T) {
+	ImageRootUrl = t.TempDir()
+	layerDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(layerDir, "data"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	desc, diffID, err := StoreLayerFromDir(layerDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 以其他层的 diffID 解压时不能写入该层的目录
+	other := "sha256:" + strings.Repeat("ab", 32)
+	if err := unpackLayer(desc.Digest, other); err == nil {
+		t.Fatalf("expected diff id mismatch")
+	}
+	if exist, _ := pathExists(LayerDir(other)); exist {
+		t.Errorf("layer dir of %s should not exist", other)
+	}
+	if exist, _ := pathExists(LayerDir(other) + ".tmp"); exist {
+		t.Errorf("tmp dir of %s should be removed", other)
+	}
+
+	if err := os.RemoveAll(LayerDir(diffID)); err != nil {
+		t.Fatal(err)
+	}
+	if err := unpackLayer(desc.Digest, diffID); err != nil {
+		t.Fatalf("unpack fails: %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(LayerDir(diffID), "data")); err != nil || string(content) != "content" {
+		t.Errorf("unexpected layer content %q: %v", content, err)
+	}
+}
+
+func TestVerifyContent(t *testing.T) {
+	ImageRootUrl = t.TempDir()
+	layerDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(layerDir, "data"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	desc, diffID, err := StoreLayerFromDir(layerDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewImageConfig()
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	img, err := CreateImage(config, []Descriptor{desc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyContent(img); err != nil {
+		t.Fatalf("verify fails: %v", err)
+	}
+
+	// 替换层数据块后校验失败
+	if err := os.WriteFile(blobPath(desc.Digest), []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyContent(img); err == nil {
+		t.Errorf("expected error for tampered layer")
+	}
+}
+
+func TestLayerHistory(t *testing.T) {
+	ImageRootUrl = t.TempDir()
+	var descs []Descriptor
+	var diffIDs []string
+	for _, content := range []string{"base", "app"} {
+		layerDir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(layerDir, "data"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		desc, diffID, err := StoreLayerFromDir(layerDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		descs, diffIDs = append(descs, desc), append(diffIDs, diffID)
+	}
+
+	tests := []struct {
+		name      string
+		layers    int
+		history   []History
+		createdBy []string // 各条记录的创建命令，从旧到新
+		diffIDs   []string // 各条记录对应的层，不产生层的记录为空
+	}{
+		{
+			name:      "empty layers between layers",
+			layers:    2,
+			history:   []History{{CreatedBy: "ADD base"}, {CreatedBy: "ENV A=1", EmptyLayer: true}, {CreatedBy: "RUN app"}, {CreatedBy: "CMD sh", EmptyLayer: true}},
+			createdBy: []string{"ADD base", "ENV A=1", "RUN app", "CMD sh"},
+			diffIDs:   []string{diffIDs[0], "", diffIDs[1], ""},
+		},
+		{
+			name:      "no history",
+			layers:    2,
+			createdBy: []string{"", ""},
+			diffIDs:   []string{diffIDs[0], diffIDs[1]},
+		},
+		{
+			name:      "fewer history entries than layers",
+			layers:    2,
+			history:   []History{{CreatedBy: "ADD base"}},
+			createdBy: []string{"ADD base", ""},
+			diffIDs:   []string{diffIDs[0], diffIDs[1]},
+		},
+		{
+			name:      "only empty layers",
+			history:   []History{{CreatedBy: "ENV A=1", EmptyLayer: true}},
+			createdBy: []string{"ENV A=1"},
+			diffIDs:   []string{""},
+		},
+	}
+	for _, tt := range tests {
+		config := NewImageConfig()
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffIDs[:tt.layers]...)
+		config.History = tt.history
+		img, err := CreateImage(config, descs[:tt.layers])
+		if err != nil {
+			t.Fatal(err)
+		}
+		histories, err := img.LayerHistory()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(histories) != len(tt.createdBy) {
+			t.Fatalf("%s: got %d entries, expect %d", tt.name, len(histories), len(tt.createdBy))
+		}
+		for i, item := range histories {
+			// 只有最新的一条记录对应的镜像是已知的
+			id := MissingID
+			if i == len(histories)-1 {
+				id = img.ID
+			}
+			if item.CreatedBy != tt.createdBy[i] || item.DiffID != tt.diffIDs[i] || item.ID != id {
+				t.Errorf("%s: entry %d got (%q, %s, %s), expect (%q, %s, %s)", tt.name, i, item.CreatedBy, item.DiffID, item.ID, tt.createdBy[i], tt.diffIDs[i], id)
+			}
+			if (item.DiffID == "") != (item.Size == 0) {
+				t.Errorf("%s: entry %d has diff id %q and size %d", tt.name, i, item.DiffID, item.Size)
+			}
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ociLayoutFile      = "oci-layout"
+	ociIndexFile       = "index.json"
+	dockerManifestFile = "manifest.json"
+
+	// 导出的镜像名记录在 index.json 的注解中
+	annotationImageName = "io.containerd.image.name"
+	annotationRefName   = "org.opencontainers.image.ref.name"
+)
+
+// docker save 格式中 manifest.json 的一项
+type dockerManifestItem struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// Save 将镜像导出为 tar 包，同时包含 OCI image layout 和 docker save 的 manifest.json
+// 可被 MiniDocker 和 Docker 的 load 命令导入
+func Save(refs []string, w io.Writer) error {
+	repos, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	written := map[string]bool{}
+	index := Index{SchemaVersion: 2, MediaType: MediaTypeIndex}
+	var dockerManifest []dockerManifestItem
+
+	for _, ref := range refs {
+		img, err := GetImage(ref)
+		if err != nil {
+			return err
+		}
+		manifestBytes, err := ManifestBytes(img.ID)
+		if err != nil {
+			return err
+		}
+		// 写入镜像引用的所有数据块，多个镜像共享的数据块只写一次
+		for _, desc := range append([]Descriptor{img.Manifest.Config}, img.Manifest.Layers...) {
+			if err := writeBlobEntry(tw, desc.Digest, written); err != nil {
+				return err
+			}
+		}
+		if err := writeBlobEntry(tw, img.ID, written); err != nil {
+			return err
+		}
+
+		desc := Descriptor{MediaType: img.Manifest.MediaType, Digest: img.ID, Size: int64(len(manifestBytes))}
+		if desc.MediaType == "" {
+			desc.MediaType = MediaTypeManifest
+		}
+		item := dockerManifestItem{Config: blobEntryName(img.Manifest.Config.Digest)}
+		for _, layer := range img.Manifest.Layers {
+			item.Layers = append(item.Layers, blobEntryName(layer.Digest))
+		}
+		// 通过镜像名导出时记录镜像名，通过ID导出时不记录
+		if name := NormalizeName(ref); repos[name] == img.ID {
+			item.RepoTags = []string{name}
+			desc.Annotations = map[string]string{
+				annotationImageName: name,
+				annotationRefName:   name[strings.LastIndex(name, ":")+1:],
+			}
+		}
+		index.Manifests = append(index.Manifests, desc)
+		dockerManifest = append(dockerManifest, item)
+	}
+
+	if err := writeJSONEntry(tw, ociLayoutFile, map[string]string{"imageLayoutVersion": "1.0.0"}); err != nil {
+		return err
+	}
+	if err := writeJSONEntry(tw, ociIndexFile, index); err != nil {
+		return err
+	}
+	if err := writeJSONEntry(tw, dockerManifestFile, dockerManifest); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// tar 包中数据块的路径
+func blobEntryName(digest string) string {
+	return "blobs/sha256/" + strings.TrimPrefix(digest, "sha256:")
+}
+
+// 将数据块写入 tar 包
+func writeBlobEntry(tw *tar.Writer, digest string, written map[string]bool) error {
+	if written[digest] {
+		return nil
+	}
+	blob, err := OpenBlob(digest)
+	if err != nil {
+		return fmt.Errorf("open blob %s fails: %v", digest, err)
+	}
+	defer blob.Close()
+	info, err := blob.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:     blobEntryName(digest),
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     info.Size(),
+		ModTime:  time.Unix(0, 0),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, blob); err != nil {
+		return err
+	}
+	written[digest] = true
+	return nil
+}
+
+// 将对象以 json 格式写入 tar 包
+func writeJSONEntry(tw *tar.Writer, name string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(content)),
+		ModTime:  time.Unix(0, 0),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = tw.Write(content)
+	return err
+}
+
+// Load 从 save 导出的 tar 包(OCI image layout 或 docker save 格式)导入镜像
+// @return 导入的镜像名，未命名的镜像返回镜像ID
+func Load(r io.Reader) ([]string, error) {
+	if err := os.MkdirAll(ImageRootUrl, 0755); err != nil {
+		return nil, err
+	}
+	tmpDir, err := os.MkdirTemp(ImageRootUrl, ".load-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := extractFiles(r, tmpDir); err != nil {
+		return nil, err
+	}
+
+	// 优先使用 OCI 格式，能够保持镜像ID不变
+	if exist, _ := pathExists(filepath.Join(tmpDir, ociIndexFile)); exist {
+		return loadOCILayout(tmpDir)
+	}
+	if exist, _ := pathExists(filepath.Join(tmpDir, dockerManifestFile)); exist {
+		return loadDockerArchive(tmpDir)
+	}
+	return nil, fmt.Errorf("neither %s nor %s found in archive", ociIndexFile, dockerManifestFile)
+}
+
+// 导入 OCI image layout
+func loadOCILayout(dir string) ([]string, error) {
+	index := &Index{}
+	if err := readJSONFile(filepath.Join(dir, ociIndexFile), index); err != nil {
+		return nil, err
+	}
+	var loaded []string
+	for _, desc := range index.Manifests {
+		if desc.MediaType == MediaTypeIndex || desc.MediaType == MediaTypeDockerManifestList {
+			logrus.Warnf("skip nested index %s", desc.Digest)
+			continue
+		}
+		// 摘要用于拼接导入目录和本地仓库中的路径
+		if err := ValidDigest(desc.Digest); err != nil {
+			return nil, err
+		}
+		manifestBytes, err := os.ReadFile(filepath.Join(dir, blobEntryName(desc.Digest)))
+		if err != nil {
+			return nil, err
+		}
+		if Digest(manifestBytes) != desc.Digest {
+			return nil, fmt.Errorf("manifest digest mismatch: expect %s, got %s", desc.Digest, Digest(manifestBytes))
+		}
+		manifest := &Manifest{}
+		if err := json.Unmarshal(manifestBytes, manifest); err != nil {
+			return nil, fmt.Errorf("unmarshal manifest %s fails: %v", desc.Digest, err)
+		}
+		if err := ValidateManifest(manifest); err != nil {
+			return nil, err
+		}
+		for _, blob := range append([]Descriptor{manifest.Config}, manifest.Layers...) {
+			if err := copyBlob(dir, blob.Digest); err != nil {
+				return nil, err
+			}
+		}
+		img, err := ImportManifest(manifestBytes)
+		if err != nil {
+			return nil, err
+		}
+		name := desc.Annotations[annotationImageName]
+		if name == "" && strings.ContainsAny(desc.Annotations[annotationRefName], ":/") {
+			name = desc.Annotations[annotationRefName]
+		}
+		if name == "" {
+			loaded = append(loaded, img.ID)
+			continue
+		}
+		if err := TagImage(img.ID, name); err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, NormalizeName(name))
+	}
+	return loaded, nil
+}
+
+// 导入 docker save 格式，层可以是未压缩或压缩过的 tar 包
+func loadDockerArchive(dir string) ([]string, error) {
+	var items []dockerManifestItem
+	if err := readJSONFile(filepath.Join(dir, dockerManifestFile), &items); err != nil {
+		return nil, err
+	}
+	var loaded []string
+	for _, item := range items {
+		configPath, err := archiveEntryPath(dir, item.Config)
+		if err != nil {
+			return nil, err
+		}
+		configBytes, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		config := &ImageConfig{}
+		if err := json.Unmarshal(configBytes, config); err != nil {
+			return nil, fmt.Errorf("unmarshal config %s fails: %v", item.Config, err)
+		}
+		if len(config.RootFS.DiffIDs) != len(item.Layers) {
+			return nil, fmt.Errorf("config has %d diff ids but %d layers found", len(config.RootFS.DiffIDs), len(item.Layers))
+		}
+		if err := validateDiffIDs(config); err != nil {
+			return nil, err
+		}
+		var layers []Descriptor
+		for i, layerPath := range item.Layers {
+			path, err := archiveEntryPath(dir, layerPath)
+			if err != nil {
+				return nil, err
+			}
+			desc, diffID, err := storeLayerFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("load layer %s fails: %v", layerPath, err)
+			}
+			if diffID != config.RootFS.DiffIDs[i] {
+				return nil, fmt.Errorf("layer %s diff id mismatch: expect %s, got %s", layerPath, config.RootFS.DiffIDs[i], diffID)
+			}
+			layers = append(layers, desc)
+		}
+		img, err := CreateImageFromConfig(configBytes, layers)
+		if err != nil {
+			return nil, err
+		}
+		if len(item.RepoTags) == 0 {
+			loaded = append(loaded, img.ID)
+		}
+		for _, tag := range item.RepoTags {
+			if err := TagImage(img.ID, tag); err != nil {
+				return nil, err
+			}
+			loaded = append(loaded, NormalizeName(tag))
+		}
+	}
+	return loaded, nil
+}
+
+// manifest.json 中记录的文件必须是导入目录中的相对路径，不能是绝对路径或包含 ..
+func archiveEntryPath(dir, name string) (string, error) {
+	if name == "" || filepath.IsAbs(name) {
+		return "", fmt.Errorf("invalid path %q in %s", name, dockerManifestFile)
+	}
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if part == ".." {
+			return "", fmt.Errorf("invalid path %q in %s", name, dockerManifestFile)
+		}
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// 将层文件存入本地仓库
+func storeLayerFile(path string) (Descriptor, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Descriptor{}, "", err
+	}
+	defer file.Close()
+	return StoreLayer(file)
+}
+
+// 将导入目录中的数据块复制到本地仓库，并校验摘要
+func copyBlob(dir, digest string) error {
+	if HasBlob(digest) {
+		return nil
+	}
+	file, err := os.Open(filepath.Join(dir, blobEntryName(digest)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return WriteBlobFrom(digest, file)
+}
+
+// 读取 json 文件
+func readJSONFile(path string, v interface{}) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("unmarshal %s fails: %v", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// 解压 tar 包中的普通文件，拒绝指向目录之外的路径
+func extractFiles(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read archive fails: %v", err)
+		}
+		name := filepath.Clean(hdr.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, tr); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	ImageRootUrl = t.TempDir()
+	layerDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(layerDir, "data"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	desc, diffID, err := StoreLayerFromDir(layerDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewImageConfig()
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	img, err := CreateImage(config, []Descriptor{desc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := TagImage(img.ID, "app:v1"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Save([]string{"app:v1"}, &buf); err != nil {
+		t.Fatalf("save fails: %v", err)
+	}
+
+	// 导入到一个新的本地仓库
+	ImageRootUrl = t.TempDir()
+	loaded, err := Load(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("load fails: %v", err)
+	}
+	if len(loaded) != 1 || loaded[0] != "app:v1" {
+		t.Fatalf("unexpected loaded images: %v", loaded)
+	}
+	got, err := GetImage("app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != img.ID {
+		t.Errorf("loaded image id %s, expect %s", got.ID, img.ID)
+	}
+	content, err := os.ReadFile(filepath.Join(LayerDir(diffID), "data"))
+	if err != nil || string(content) != "content" {
+		t.Errorf("unexpected layer content %q: %v", content, err)
+	}
+}
+
+// 构造导入包, files 为文件名到内容的映射
+func archiveWithFiles(t *testing.T, files map[string]string) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestLoadRejectsUnsafeEntries(t *testing.T) {
+	ImageRootUrl = t.TempDir()
+	config := `{"rootfs":{"type":"layers","diff_ids":["sha256:` + strings.Repeat("ab", 32) + `"]}}`
+	archives := map[string]*bytes.Buffer{
+		"config outside archive": archiveWithFiles(t, map[string]string{dockerManifestFile: `[{"Config":"../../etc/passwd","Layers":[]}]`}),
+		"absolute layer path": archiveWithFiles(t, map[string]string{
+			dockerManifestFile: `[{"Config":"config.json","Layers":["/etc/passwd"]}]`,
+			"config.json":      config,
+		}),
+		"invalid diff id": archiveWithFiles(t, map[string]string{
+			dockerManifestFile: `[{"Config":"config.json","Layers":["layer.tar"]}]`,
+			"config.json":      `{"rootfs":{"type":"layers","diff_ids":["sha256:../x"]}}`,
+		}),
+		"invalid index digest": archiveWithFiles(t, map[string]string{ociIndexFile: `{"schemaVersion":2,"manifests":[{"digest":"sha256:../../../etc/passwd"}]}`}),
+	}
+	for name, archive := range archives {
+		if _, err := Load(archive); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("%s: expected invalid entry error, got %v", name, err)
+		}
+	}
+}
@@ -1,6 +1,13 @@
 package main
 
 import (
+	"MiniDocker/cgroups"
+	"MiniDocker/config"
+	"MiniDocker/container"
+	"MiniDocker/image"
+	"MiniDocker/network"
+	"MiniDocker/rootless"
+	"MiniDocker/volume"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	"os"
@@ -9,26 +16,117 @@ import (
 const usage = `Usage`
 
 func main() {
+	// rootless 模式下由 Reexec 启动的子进程需等待 id 映射写入
+	if err := rootless.Init(); err != nil {
+		logrus.Fatal(err)
+	}
 	app := cli.NewApp()
 	app.Name = "miniDocker"
 	app.Usage = usage
+	// 参数值中的','有特殊含义(如 -v 的选项)，多次指定参数得到多个值，不以','分割
+	app.DisableSliceFlagSeparator = true
+	// 全局参数, 优先于配置文件, 用于在同一台机器上运行相互隔离的多个实例
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:  "config-file",
+			Usage: "global config file, $XDG_CONFIG_HOME/minidocker/config.json in rootless mode",
+			Value: config.DefaultConfigFile,
+		},
+		&cli.StringFlag{
+			Name:  "root",
+			Usage: "root directory of persistent state: images, volumes and container layers (default: \"" + config.DefaultRoot + "\", $XDG_DATA_HOME/minidocker in rootless mode)",
+		},
+		&cli.StringFlag{
+			Name:  "exec-root",
+			Usage: "root directory of runtime state: container info, logs and networks (default: \"" + config.DefaultExecRoot + "\", $XDG_RUNTIME_DIR/minidocker in rootless mode)",
+		},
+		&cli.StringFlag{
+			Name:  "storage-driver",
+			Usage: "storage driver of new containers: overlay or native (default: overlay, native if overlay is unavailable)",
+		},
+	}
 	app.Commands = []*cli.Command{
 		&runCommand,
 		&initCommand,
 		&commitCommand,
+		&diffCommand,
+		&buildCommand,
+		&pullCommand,
+		&pushCommand,
+		&saveCommand,
+		&loadCommand,
+		&exportCommand,
+		&importCommand,
+		&historyCommand,
+		&imageCommand,
+		&trustCommand,
 		&listCommand,
 		&logCommand,
 		&execCommand,
+		&cpCommand,
 		&stopCommand,
 		&removeCommand,
 		&networkCommand,
+		&volumeCommand,
+		&containerCommand,
+		&systemCommand,
 	}
 	app.Before = func(ctx *cli.Context) error {
 		logrus.SetFormatter(&logrus.JSONFormatter{})
 		logrus.SetOutput(os.Stdout)
-		return nil
+		// 非 root 用户运行时在用户命名空间中重新运行命令，以子进程的退出码退出
+		if rootless.NeedReexec(ctx.Args().First()) {
+			code, err := rootless.Reexec()
+			if err != nil {
+				logrus.Error(err)
+			}
+			os.Exit(code)
+		}
+		return setUpConfig(ctx)
 	}
 	if err := app.Run(os.Args); err != nil {
 		logrus.Fatal(err)
 	}
 }
+
+// 读取配置文件和全局参数，设置各个包使用的数据根目录、运行时目录、存储驱动和默认的用户命名空间映射
+// 容器的 init 进程通过管道接收配置，不访问这些目录，也不应读取配置文件
+// rootless 模式下默认的配置文件和目录位于当前用户的 XDG 目录中
+func setUpConfig(ctx *cli.Context) error {
+	if ctx.Args().First() == initCommand.Name {
+		return nil
+	}
+	configFile, defaults := config.Defaults()
+	if rootless.Enabled() {
+		configFile, defaults = config.RootlessDefaults(rootless.HostUID())
+	}
+	if ctx.IsSet("config-file") {
+		configFile = ctx.String("config-file")
+	}
+	cfg, err := config.Load(configFile, ctx.IsSet("config-file"), defaults)
+	if err != nil {
+		return err
+	}
+	if ctx.IsSet("root") {
+		cfg.Root = ctx.String("root")
+	}
+	if ctx.IsSet("exec-root") {
+		cfg.ExecRoot = ctx.String("exec-root")
+	}
+	if ctx.IsSet("storage-driver") {
+		cfg.StorageDriver = ctx.String("storage-driver")
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	container.SetRoot(cfg.Root, cfg.ExecRoot)
+	image.SetRoot(cfg.Root)
+	volume.SetRoot(cfg.Root)
+	network.SetRoot(cfg.ExecRoot)
+	container.UsernsRemap = cfg.UsernsRemap
+	if rootless.Enabled() {
+		container.SetRootless()
+		cgroups.Rootless = true
+	}
+	return container.SetStorageDriver(cfg.StorageDriver)
+}
@@ -152,10 +152,9 @@ func setInterfaceIP(name string, rawIP string) error {
 	if err != nil {
 		return fmt.Errorf("abandoning retrieving the new bridge link from netlink, Run [ ip link ] to troubleshoot the error: %v", err)
 	}
-	fmt.Printf("rawip: %s", rawIP)
 	ipNet, err := netlink.ParseIPNet(rawIP)
 	if err != nil {
-		logrus.Errorf("ParseIPNet ip: %d fails: %s", rawIP, err)
+		logrus.Errorf("ParseIPNet ip: %s fails: %s", rawIP, err)
 		return err
 	}
 	addr := &netlink.Addr{IPNet: ipNet, Peer: ipNet, Label: "", Flags: 0, Scope: 0, Broadcast: nil}
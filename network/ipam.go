@@ -9,7 +9,7 @@ import (
 	"strings"
 )
 
-const ipamDefaultAllocatorPath = "/var/run/minidocker/network/ipam/subnet.json"
+var ipamDefaultAllocatorPath = "/var/run/minidocker/network/ipam/subnet.json"
 
 // IPAM 网络ip地址分配结构体，使用位图算法，为方便实现使用string中的一个字符表示一个状态位
 type IPAM struct {
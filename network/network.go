@@ -24,6 +24,13 @@ var (
 	networks           = map[string]*Network{}                  // 网络字段，存储网络信息
 )
 
+// SetRoot 设置运行时目录，网络配置和 ip 分配信息位于 execRoot/network 下
+func SetRoot(execRoot string) {
+	defaultNetworkPath = filepath.Join(execRoot, "network", "network") + "/"
+	ipamDefaultAllocatorPath = filepath.Join(execRoot, "network", "ipam", "subnet.json")
+	ipAllocator.SubnetAllocatorPath = ipamDefaultAllocatorPath
+}
+
 // Network 抽象的网络数据结构
 type Network struct {
 	Name    string     // 网络名
@@ -198,11 +205,12 @@ func ListNetwork() {
 }
 
 // Connect 创建容器并连接网络
-func Connect(networkName string, cinfo *container.ContainerInfo) error {
+// @return 分配给容器的ip
+func Connect(networkName string, cinfo *container.ContainerInfo) (net.IP, error) {
 	// 获取网络信息
 	network, ok := networks[networkName]
 	if !ok {
-		return fmt.Errorf("no such network: %s", networkName)
+		return nil, fmt.Errorf("no such network: %s", networkName)
 	}
 	logrus.Infof("ip: %s", network.IpRange.String())
 	_, cidr, _ := net.ParseCIDR(network.IpRange.String())
@@ -210,7 +218,7 @@ func Connect(networkName string, cinfo *container.ContainerInfo) error {
 	// 通过IPAM从网络的网段中获取可用的ip作为容器ip
 	ip, err := ipAllocator.Allocate(cidr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	logrus.Infof("allocate ip: %v", ip)
 	// 创建网络端点
@@ -223,14 +231,14 @@ func Connect(networkName string, cinfo *container.ContainerInfo) error {
 	logrus.Infof(" networK name: %v, ip: %v, mask: %v", network.Name, network.IpRange.String(), network.IpRange.Mask)
 	// 连接网络
 	if err := drivers[network.Driver].Connect(network, ep); err != nil {
-		return err
+		return nil, err
 	}
 	// 进入到容器网络namespace内配置网络容器设备的ip和路由
 	if err := configEndpointIpAddressAndRoute(ep, cinfo); err != nil {
-		return err
+		return nil, err
 	}
 	// 配置容器到宿主机的端口映射
-	return configPortmapping(ep, cinfo)
+	return ip, configPortmapping(ep, cinfo)
 }
 
 // DeleteNetwork 删除网络
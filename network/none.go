@@ -0,0 +1,50 @@
+package network
+
+import (
+	"MiniDocker/container"
+	"fmt"
+	"github.com/vishvananda/netns"
+	"os"
+	"runtime"
+)
+
+const (
+	// NetworkNone 不连接网络，容器的网络命名空间中只有回环接口
+	NetworkNone = "none"
+	// NetworkHost 容器使用宿主机的网络命名空间
+	NetworkHost = "host"
+)
+
+// CheckRootless rootless 模式下不能创建网桥、veth 和 iptables 规则，只支持 none 和 host 网络，也不支持端口映射
+func CheckRootless(networkName string, portMapping []string) error {
+	if networkName != "" && networkName != NetworkNone && networkName != NetworkHost {
+		return fmt.Errorf("network %s is not supported in rootless mode, bridges cannot be created, use --net none or --net host", networkName)
+	}
+	if len(portMapping) > 0 {
+		return fmt.Errorf("port mapping is not supported in rootless mode, use --net host to listen on host ports")
+	}
+	return nil
+}
+
+// SetUpLoopback 启用未连接网络的容器中的回环接口
+func SetUpLoopback(cinfo *container.ContainerInfo) error {
+	f, err := os.Open(fmt.Sprintf("/proc/%s/ns/net", cinfo.Pid))
+	if err != nil {
+		return fmt.Errorf("open container net file fails: %v", err)
+	}
+	defer f.Close()
+
+	// 只有当前线程进入容器的网络命名空间
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	origins, err := netns.Get()
+	if err != nil {
+		return err
+	}
+	defer origins.Close()
+	if err := netns.Set(netns.NsHandle(f.Fd())); err != nil {
+		return fmt.Errorf("set netns fails: %v", err)
+	}
+	defer netns.Set(origins)
+	return setInterfaceUP("lo")
+}
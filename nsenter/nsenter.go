@@ -9,6 +9,29 @@ package nsenter
 #include <string.h>
 #include <fcntl.h>
 #include <unistd.h>
+#include <grp.h>
+#include <sys/prctl.h>
+#include <sys/stat.h>
+
+// 读取容器 init 进程的 bounding 集合(/proc/[pid]/status 中的 CapBnd)
+static int read_bounding_set(const char *pid, unsigned long long *bounding) {
+	char path[64];
+	char line[256];
+	int found = 0;
+	snprintf(path, sizeof(path), "/proc/%s/status", pid);
+	FILE *f = fopen(path, "r");
+	if (f == NULL) {
+		return 0;
+	}
+	while (fgets(line, sizeof(line), f) != NULL) {
+		if (sscanf(line, "CapBnd: %llx", bounding) == 1) {
+			found = 1;
+			break;
+		}
+	}
+	fclose(f);
+	return found;
+}
 
 // 该函数类似于包的构造函数
 __attribute__((constructor)) void enter_namespace(void) {
@@ -33,6 +56,24 @@ __attribute__((constructor)) void enter_namespace(void) {
 	}
 	int i;
 	char nspath[1024];
+	// 容器使用了用户命名空间时需先进入, 之后才有权限进入该用户命名空间所拥有的其他namespace
+	// 与当前进程相同的用户命名空间不能再次进入
+	struct stat self_ns, target_ns;
+	int userns = 0;
+	sprintf(nspath, "/proc/%s/ns/user", minidocker_pid);
+	if (stat(nspath, &target_ns) == 0 && stat("/proc/self/ns/user", &self_ns) == 0 && target_ns.st_ino != self_ns.st_ino) {
+		int fd = open(nspath, O_RDONLY);
+		if (setns(fd, CLONE_NEWUSER) == -1) {
+			fprintf(stderr, "c- setns on user namespace failed: %s\n", strerror(errno));
+		} else {
+			fprintf(stdout, "c- setns on user namespace succeeded\n");
+			userns = 1;
+		}
+		close(fd);
+	}
+	// 进入 pid 和 mnt 命名空间之前, 读取容器的能力集合
+	unsigned long long bounding;
+	int has_bounding = read_bounding_set(minidocker_pid, &bounding);
 	// 需要进入的5中namespace
 	char *namespaces[] = { "ipc", "uts", "net", "pid", "mnt" };
 
@@ -49,6 +90,19 @@ __attribute__((constructor)) void enter_namespace(void) {
 		}
 		close(fd);
 	}
+	// 切换为容器内的 root, 宿主机的 root 在用户命名空间中没有映射, 执行命令后会失去权限
+	// rootless 模式下只映射了一个 gid 的用户命名空间禁止 setgroups, 忽略其错误
+	if (userns && ((setgroups(0, NULL) == -1 && errno != EPERM) || setresgid(0, 0, 0) == -1 || setresuid(0, 0, 0) == -1)) {
+		fprintf(stderr, "c- switch to root of user namespace failed: %s\n", strerror(errno));
+	}
+	// 与容器的 init 进程使用相同的 bounding 集合, 执行的命令不会拥有容器之外的能力
+	if (has_bounding) {
+		for (i=0; i<64; i++) {
+			if (!(bounding & (1ULL << i)) && prctl(PR_CAPBSET_DROP, i, 0, 0, 0) == -1 && errno != EINVAL) {
+				fprintf(stderr, "c- drop capability %d failed: %s\n", i, strerror(errno));
+			}
+		}
+	}
 	// 在进入的namespace中执行指定的命令
 	int res = system(minidocker_cmd);
 	exit(0);
@@ -0,0 +1,210 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Options 访问镜像仓库的选项
+type Options struct {
+	Username string // 用户名，用于 basic 认证或获取 token
+	Password string // 密码
+	Insecure bool   // 使用 http 而不是 https 访问镜像仓库
+}
+
+// 镜像仓库的 HTTP 客户端，遵循 OCI distribution 规范
+type client struct {
+	ref     *Reference
+	opts    Options
+	actions string // 需要的权限，如 pull 或 pull,push
+	http    *http.Client
+	auth    string // 认证后每个请求携带的 Authorization 头
+}
+
+func newClient(ref *Reference, opts Options, actions string) *client {
+	return &client{
+		ref:     ref,
+		opts:    opts,
+		actions: actions,
+		http:    &http.Client{Timeout: 30 * time.Minute},
+	}
+}
+
+// 镜像仓库 API 的根地址, localhost 默认使用 http
+func (c *client) baseURL() string {
+	scheme := "https"
+	host := c.ref.registryHost()
+	if c.opts.Insecure || strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.") {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/", scheme, host)
+}
+
+// 拼接仓库内资源的地址，如 manifests/latest、blobs/sha256:...
+func (c *client) url(resource string) string {
+	return c.baseURL() + c.ref.Repository + "/" + resource
+}
+
+// 发送请求，收到401时按 WWW-Authenticate 的要求认证后重试一次
+// 请求体需可重放(bytes.Reader 等)，以便认证后重新发送
+func (c *client) do(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.authenticate(challenge); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// 根据认证质询获取凭证，支持 Basic 和 Bearer token 两种方式
+func (c *client) authenticate(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.opts.Username == "" {
+			return fmt.Errorf("registry requires basic auth, please provide username and password")
+		}
+		req, _ := http.NewRequest(http.MethodGet, "", nil)
+		req.SetBasicAuth(c.opts.Username, c.opts.Password)
+		c.auth = req.Header.Get("Authorization")
+		return nil
+	case "bearer":
+		return c.fetchToken(params)
+	default:
+		return fmt.Errorf("unsupported auth challenge: %q", challenge)
+	}
+}
+
+// 向认证服务器申请访问仓库的 token
+func (c *client) fetchToken(params map[string]string) error {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("invalid token realm: %q", params["realm"])
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:%s", c.ref.Repository, c.actions))
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.opts.Username != "" {
+		req.SetBasicAuth(c.opts.Username, c.opts.Password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch token fails: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch token fails: %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("decode token fails: %v", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return fmt.Errorf("empty token from %s", realm.Host)
+	}
+	c.auth = "Bearer " + token.Token
+	return nil
+}
+
+// 解析 WWW-Authenticate 头，如 Bearer realm="https://auth",service="registry",scope="..."
+func parseChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+	rest := parts[1]
+	for rest != "" {
+		kv := strings.SplitN(rest, "=", 2)
+		if len(kv) != 2 {
+			break
+		}
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+		if strings.HasPrefix(value, "\"") {
+			end := strings.Index(value[1:], "\"")
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = strings.TrimPrefix(strings.TrimSpace(value[end+2:]), ",")
+		} else {
+			end := strings.Index(value, ",")
+			if end < 0 {
+				params[key] = value
+				break
+			}
+			params[key] = value[:end]
+			rest = value[end+1:]
+		}
+	}
+	return parts[0], params
+}
+
+// 将镜像仓库返回的错误转换为 error
+func responseError(resp *http.Response) error {
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	content, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := json.Unmarshal(content, &body); err == nil && len(body.Errors) > 0 {
+		return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, body.Errors[0].Code, body.Errors[0].Message)
+	}
+	return fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+}
+
+// 解析上传接口返回的 Location，可能是相对地址
+func (c *client) location(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("registry returned no upload location")
+	}
+	base, err := url.Parse(c.baseURL())
+	if err != nil {
+		return "", err
+	}
+	loc, err := base.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return loc.String(), nil
+}
@@ -0,0 +1,150 @@
+package registry
+
+import (
+	"MiniDocker/image"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+)
+
+// 拉取 manifest 时接受的格式
+var manifestAccept = strings.Join([]string{
+	image.MediaTypeManifest,
+	image.MediaTypeIndex,
+	image.MediaTypeDockerManifest,
+	image.MediaTypeDockerManifestList,
+}, ", ")
+
+// Pull 从镜像仓库拉取镜像并存入本地镜像仓库
+// 多平台镜像会选择与本机一致的平台(如 linux/amd64)
+func Pull(refStr string, opts Options) (*image.Image, error) {
+	ref, err := ParseReference(refStr)
+	if err != nil {
+		return nil, err
+	}
+	c := newClient(ref, opts, "pull")
+	fmt.Printf("Pulling %s\n", ref.String())
+
+	manifestBytes, mediaType, err := c.getManifest(ref.Reference())
+	if err != nil {
+		return nil, err
+	}
+	if ref.Digest != "" && image.Digest(manifestBytes) != ref.Digest {
+		return nil, fmt.Errorf("manifest digest mismatch: expect %s, got %s", ref.Digest, image.Digest(manifestBytes))
+	}
+	// 多平台镜像，选择本机平台对应的 manifest
+	if mediaType == image.MediaTypeIndex || mediaType == image.MediaTypeDockerManifestList {
+		desc, err := selectPlatform(manifestBytes)
+		if err != nil {
+			return nil, err
+		}
+		if err := image.ValidDigest(desc.Digest); err != nil {
+			return nil, err
+		}
+		if manifestBytes, _, err = c.getManifest(desc.Digest); err != nil {
+			return nil, err
+		}
+		if image.Digest(manifestBytes) != desc.Digest {
+			return nil, fmt.Errorf("manifest digest mismatch: expect %s, got %s", desc.Digest, image.Digest(manifestBytes))
+		}
+	}
+
+	manifest := &image.Manifest{}
+	if err := json.Unmarshal(manifestBytes, manifest); err != nil {
+		return nil, fmt.Errorf("unmarshal manifest fails: %v", err)
+	}
+	if manifest.SchemaVersion != 2 {
+		return nil, fmt.Errorf("unsupported manifest schema version %d", manifest.SchemaVersion)
+	}
+	// 摘要会用作本地文件名，需在判断数据块是否存在之前校验
+	if err := image.ValidateManifest(manifest); err != nil {
+		return nil, err
+	}
+	// 依次下载配置和各层，本地已存在的数据块跳过
+	for _, desc := range append([]image.Descriptor{manifest.Config}, manifest.Layers...) {
+		if image.HasBlob(desc.Digest) {
+			fmt.Printf("%s: already exists\n", image.ShortID(desc.Digest))
+			continue
+		}
+		if err := c.fetchBlob(desc); err != nil {
+			return nil, fmt.Errorf("fetch blob %s fails: %v", desc.Digest, err)
+		}
+		fmt.Printf("%s: pull complete\n", image.ShortID(desc.Digest))
+	}
+
+	img, err := image.ImportManifest(manifestBytes)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Digest == "" {
+		if err := image.TagImage(img.ID, ref.String()); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Printf("Digest: %s\nStatus: downloaded image for %s\n", img.ID, ref.String())
+	return img, nil
+}
+
+// 获取 manifest 的原始内容和格式
+func (c *client) getManifest(reference string) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url("manifests/"+reference), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", manifestAccept)
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", responseError(resp)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	mediaType := strings.TrimSpace(strings.SplitN(resp.Header.Get("Content-Type"), ";", 2)[0])
+	// 部分仓库不返回准确的 Content-Type, 以 manifest 中的 mediaType 为准
+	var versioned struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(content, &versioned); err == nil && versioned.MediaType != "" {
+		mediaType = versioned.MediaType
+	}
+	return content, mediaType, nil
+}
+
+// 从多平台索引中选择本机平台的 manifest
+func selectPlatform(indexBytes []byte) (*image.Descriptor, error) {
+	index := &image.Index{}
+	if err := json.Unmarshal(indexBytes, index); err != nil {
+		return nil, fmt.Errorf("unmarshal manifest list fails: %v", err)
+	}
+	for i, desc := range index.Manifests {
+		if desc.Platform != nil && desc.Platform.OS == runtime.GOOS && desc.Platform.Architecture == runtime.GOARCH {
+			return &index.Manifests[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no manifest for platform %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// 下载数据块，边下载边校验摘要
+func (c *client) fetchBlob(desc image.Descriptor) error {
+	req, err := http.NewRequest(http.MethodGet, c.url("blobs/"+desc.Digest), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return image.WriteBlobFrom(desc.Digest, resp.Body)
+}
@@ -0,0 +1,189 @@
+package registry
+
+import (
+	"MiniDocker/image"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// 分块上传时每块的大小
+const chunkSize = 5 * 1024 * 1024
+
+// Push 将本地镜像推送到镜像仓库
+// target 为空时推送到本地镜像名所指的仓库，否则推送到 target
+func Push(localRef, target string, opts Options) error {
+	img, err := image.GetImage(localRef)
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		target = localRef
+	}
+	ref, err := ParseReference(target)
+	if err != nil {
+		return err
+	}
+	if ref.Digest != "" {
+		return fmt.Errorf("cannot push to a digest reference: %s", target)
+	}
+	c := newClient(ref, opts, "pull,push")
+	fmt.Printf("Pushing %s\n", ref.String())
+
+	// 先上传各层和配置，manifest 引用的数据块必须都已存在
+	for _, desc := range append(append([]image.Descriptor{}, img.Manifest.Layers...), img.Manifest.Config) {
+		exist, err := c.blobExists(desc.Digest)
+		if err != nil {
+			return err
+		}
+		if exist {
+			fmt.Printf("%s: layer already exists\n", image.ShortID(desc.Digest))
+			continue
+		}
+		if err := c.pushBlob(desc); err != nil {
+			return fmt.Errorf("push blob %s fails: %v", desc.Digest, err)
+		}
+		fmt.Printf("%s: pushed\n", image.ShortID(desc.Digest))
+	}
+
+	manifestBytes, err := image.ManifestBytes(img.ID)
+	if err != nil {
+		return err
+	}
+	mediaType := img.Manifest.MediaType
+	if mediaType == "" {
+		mediaType = image.MediaTypeManifest
+	}
+	if err := c.putManifest(ref.Tag, mediaType, manifestBytes); err != nil {
+		return err
+	}
+	fmt.Printf("%s: digest: %s size: %d\n", ref.Tag, img.ID, len(manifestBytes))
+	return nil
+}
+
+// 判断镜像仓库中是否已存在数据块
+func (c *client) blobExists(digest string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, c.url("blobs/"+digest), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("HEAD blob %s: %s", digest, resp.Status)
+	}
+}
+
+// 分块上传数据块: POST 开始上传，PATCH 逐块上传，PUT 带上摘要完成上传
+func (c *client) pushBlob(desc image.Descriptor) error {
+	req, err := http.NewRequest(http.MethodPost, c.url("blobs/uploads/"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return responseError(resp)
+	}
+	location, err := c.location(resp)
+	if err != nil {
+		return err
+	}
+
+	blob, err := image.OpenBlob(desc.Digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	buf := make([]byte, chunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(blob, buf)
+		if n > 0 {
+			if location, err = c.patchChunk(location, buf[:n], offset); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// 完成上传
+	uploadURL, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	query := uploadURL.Query()
+	query.Set("digest", desc.Digest)
+	uploadURL.RawQuery = query.Encode()
+	req, err = http.NewRequest(http.MethodPut, uploadURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err = c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// 上传一块数据，返回下一次上传使用的地址
+func (c *client) patchChunk(location string, chunk []byte, offset int64) (string, error) {
+	req, err := http.NewRequest(http.MethodPatch, location, bytes.NewReader(chunk))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Length", strconv.Itoa(len(chunk)))
+	req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(len(chunk))-1))
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return "", responseError(resp)
+	}
+	return c.location(resp)
+}
+
+// 上传 manifest
+func (c *client) putManifest(reference, mediaType string, manifestBytes []byte) error {
+	req, err := http.NewRequest(http.MethodPut, c.url("manifests/"+reference), bytes.NewReader(manifestBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp)
+	}
+	return nil
+}
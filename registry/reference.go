@@ -0,0 +1,91 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// 未指定仓库地址时使用 Docker Hub
+	defaultDomain   = "docker.io"
+	defaultRegistry = "registry-1.docker.io"
+	officialPrefix  = "library/"
+)
+
+// Reference 镜像引用，如 localhost:5000/team/app:v1 或 busybox@sha256:...
+type Reference struct {
+	Domain     string // 镜像仓库地址，如 localhost:5000
+	Repository string // 仓库中的镜像名，如 team/app
+	Tag        string // 镜像tag
+	Digest     string // 镜像摘要，指定后优先于tag
+}
+
+// ParseReference 解析镜像引用
+func ParseReference(ref string) (*Reference, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("empty image reference")
+	}
+	r := &Reference{}
+	if i := strings.Index(ref, "@"); i >= 0 {
+		r.Digest = ref[i+1:]
+		ref = ref[:i]
+		if !strings.HasPrefix(r.Digest, "sha256:") {
+			return nil, fmt.Errorf("unsupported digest: %s", r.Digest)
+		}
+	}
+	// 最后一个'/'之后的':'才是tag分隔符
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		r.Tag = ref[i+1:]
+		ref = ref[:i]
+	}
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+
+	// 第一段包含'.'或':'或为localhost时视为仓库地址
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		r.Domain, r.Repository = parts[0], parts[1]
+	} else {
+		r.Domain, r.Repository = defaultDomain, ref
+	}
+	if r.Domain == defaultDomain && !strings.Contains(r.Repository, "/") {
+		r.Repository = officialPrefix + r.Repository
+	}
+	if r.Repository == "" || strings.ToLower(r.Repository) != r.Repository {
+		return nil, fmt.Errorf("invalid repository name: %s", r.Repository)
+	}
+	return r, nil
+}
+
+// Name 本地使用的镜像名，Docker Hub 的官方镜像省略仓库地址和 library/ 前缀
+func (r *Reference) Name() string {
+	if r.Domain == defaultDomain {
+		return strings.TrimPrefix(r.Repository, officialPrefix)
+	}
+	return r.Domain + "/" + r.Repository
+}
+
+// Reference 返回 manifest 接口使用的引用，摘要优先
+func (r *Reference) Reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// String 完整的镜像引用
+func (r *Reference) String() string {
+	if r.Digest != "" {
+		return r.Name() + "@" + r.Digest
+	}
+	return r.Name() + ":" + r.Tag
+}
+
+// 镜像仓库 API 的地址
+func (r *Reference) registryHost() string {
+	if r.Domain == defaultDomain {
+		return defaultRegistry
+	}
+	return r.Domain
+}
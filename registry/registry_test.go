@@ -0,0 +1,171 @@
+package registry
+
+import (
+	"MiniDocker/image"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 内存中的测试镜像仓库，使用 token 认证
+type testRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   map[string][]byte
+	server    *httptest.Server
+}
+
+func newTestRegistry() *testRegistry {
+	r := &testRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}, uploads: map[string][]byte{}}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+func (r *testRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.URL.Path == "/token" {
+		if user, pass, ok := req.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token":"secret"}`)
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer secret" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, r.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/v2/test/app/")
+	switch {
+	case strings.HasPrefix(path, "manifests/"):
+		reference := strings.TrimPrefix(path, "manifests/")
+		if req.Method == http.MethodPut {
+			content, _ := io.ReadAll(req.Body)
+			r.manifests[reference] = content
+			r.manifests[image.Digest(content)] = content
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		content, ok := r.manifests[reference]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(content)
+	case path == "blobs/uploads/" && req.Method == http.MethodPost:
+		id := fmt.Sprintf("%d", len(r.uploads)+1)
+		r.uploads[id] = nil
+		w.Header().Set("Location", "/v2/test/app/blobs/uploads/"+id)
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasPrefix(path, "blobs/uploads/"):
+		id := strings.TrimPrefix(path, "blobs/uploads/")
+		content, _ := io.ReadAll(req.Body)
+		r.uploads[id] = append(r.uploads[id], content...)
+		if req.Method == http.MethodPatch {
+			w.Header().Set("Location", "/v2/test/app/blobs/uploads/"+id)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		digest := req.URL.Query().Get("digest")
+		if image.Digest(r.uploads[id]) != digest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[digest] = r.uploads[id]
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(path, "blobs/"):
+		content, ok := r.blobs[strings.TrimPrefix(path, "blobs/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if req.Method != http.MethodHead {
+			w.Write(content)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestPushAndPull(t *testing.T) {
+	registry := newTestRegistry()
+	defer registry.server.Close()
+	host := strings.TrimPrefix(registry.server.URL, "http://")
+	opts := Options{Username: "user", Password: "pass", Insecure: true}
+
+	// 在一个本地仓库中创建镜像并推送
+	image.ImageRootUrl = t.TempDir()
+	layerDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(layerDir, "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	desc, diffID, err := image.StoreLayerFromDir(layerDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := image.NewImageConfig()
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	img, err := image.CreateImage(config, []image.Descriptor{desc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Push(img.ID, host+"/test/app:v1", opts); err != nil {
+		t.Fatalf("push fails: %v", err)
+	}
+
+	// 为推送的镜像增加一个多平台索引
+	index := image.Index{SchemaVersion: 2, MediaType: image.MediaTypeIndex, Manifests: []image.Descriptor{
+		{MediaType: image.MediaTypeManifest, Digest: "sha256:0000", Platform: &image.Platform{OS: "linux", Architecture: "other"}},
+		{MediaType: image.MediaTypeManifest, Digest: img.ID, Platform: &image.Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}},
+	}}
+	indexBytes, _ := json.Marshal(index)
+	registry.manifests["multi"] = indexBytes
+
+	// 在另一个空的本地仓库中拉取
+	image.ImageRootUrl = t.TempDir()
+	for _, tag := range []string{"v1", "multi"} {
+		pulled, err := Pull(host+"/test/app:"+tag, opts)
+		if err != nil {
+			t.Fatalf("pull %s fails: %v", tag, err)
+		}
+		if pulled.ID != img.ID {
+			t.Errorf("pulled image id %s, expect %s", pulled.ID, img.ID)
+		}
+		content, err := os.ReadFile(filepath.Join(image.LayerDir(diffID), "hello.txt"))
+		if err != nil || string(content) != "hello" {
+			t.Errorf("unexpected layer content %q: %v", content, err)
+		}
+	}
+	if _, err := image.GetImage(host + "/test/app:v1"); err != nil {
+		t.Errorf("pulled image is not tagged: %v", err)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	cases := map[string]string{
+		"busybox":                      "docker.io library/busybox latest",
+		"user/app:1.0":                 "docker.io user/app 1.0",
+		"localhost:5000/app":           "localhost:5000 app latest",
+		"reg.example.com/team/app:dev": "reg.example.com team/app dev",
+	}
+	for input, expect := range cases {
+		ref, err := ParseReference(input)
+		if err != nil {
+			t.Fatalf("parse %s fails: %v", input, err)
+		}
+		if got := ref.Domain + " " + ref.Repository + " " + ref.Tag; got != expect {
+			t.Errorf("parse %s: got %s, expect %s", input, got, expect)
+		}
+	}
+}
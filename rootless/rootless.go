@@ -0,0 +1,179 @@
+package rootless
+
+import (
+	"MiniDocker/container"
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+/*
+rootless 模式: 由非 root 用户运行时，先在新的用户命名空间中重新运行当前命令，进程在其中拥有 root 的能力
+之后的挂载、镜像解压以及容器的挂载、pid、uts、ipc 命名空间都在该用户命名空间中创建，宿主机上的文件属于当前用户(或其从属 id)
+*/
+
+// 传给用户命名空间中子进程的环境变量, 值为 wait 时需等待父进程通过 newuidmap/newgidmap 写入映射
+const stateEnv = "_MINIDOCKER_ROOTLESS"
+
+// 当前进程是否位于 rootless 模式创建的用户命名空间中
+var inUserNS bool
+
+// Enabled 是否以 rootless 模式运行: 由非 root 用户启动，或位于 rootless 模式创建的用户命名空间中
+func Enabled() bool {
+	return os.Geteuid() != 0 || inUserNS
+}
+
+// NeedReexec 非 root 用户运行的命令需要先进入用户命名空间
+// 容器的 init 进程已位于容器的命名空间中; exec 需要从宿主机进入容器的用户命名空间，不能位于另一个用户命名空间中
+func NeedReexec(command string) bool {
+	return os.Geteuid() != 0 && !inUserNS && command != "init" && command != "exec"
+}
+
+/*
+Init 在 main 开始时调用，识别由 Reexec 启动的子进程
+子进程的 uid 在父进程写入映射之前没有映射，执行时失去了所有能力，需等待映射写入后重新执行自身
+*/
+func Init() error {
+	state, ok := os.LookupEnv(stateEnv)
+	if !ok {
+		return nil
+	}
+	// 不传给容器进程
+	os.Unsetenv(stateEnv)
+	inUserNS = true
+	if state != "wait" {
+		return nil
+	}
+	pipe := os.NewFile(3, "rootless-sync")
+	_, err := io.ReadAll(pipe)
+	pipe.Close()
+	if err != nil {
+		return fmt.Errorf("wait for id mappings fails: %v", err)
+	}
+	os.Setenv(stateEnv, "mapped")
+	return syscall.Exec("/proc/self/exe", os.Args, os.Environ())
+}
+
+// HostUID 当前用户在宿主机上的 uid，位于用户命名空间中时为容器内 root 映射的 uid
+func HostUID() int {
+	if !inUserNS {
+		return os.Geteuid()
+	}
+	f, err := os.Open("/proc/self/uid_map")
+	if err != nil {
+		return os.Geteuid()
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "0" {
+			if uid, err := strconv.Atoi(fields[1]); err == nil {
+				return uid
+			}
+		}
+	}
+	return os.Geteuid()
+}
+
+/*
+Reexec 在新的用户命名空间和挂载命名空间中重新运行当前命令，返回子进程的退出码
+1.有 newuidmap/newgidmap 且在 /etc/subuid、/etc/subgid 中配置了当前用户的从属 id 时，当前用户映射为 root，从属 id 映射为 1 开始的 id
+2.否则只将当前用户映射为 root，镜像中属于其他用户的文件无法解压
+挂载命名空间随命令退出而销毁，其中的 overlay 等挂载不会留在宿主机上
+*/
+func Reexec() (int, error) {
+	uid, gid := os.Geteuid(), os.Getegid()
+	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+	}
+
+	maps, err := subIDMappings()
+	if err != nil {
+		logrus.Debugf("%v, map only uid %d and gid %d", err, uid, gid)
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: gid, Size: 1}}
+		cmd.Env = append(os.Environ(), stateEnv+"=mapped")
+		if err := cmd.Start(); err != nil {
+			return 1, fmt.Errorf("start process in user namespace fails: %v", err)
+		}
+		return wait(cmd)
+	}
+
+	readPipe, writePipe, err := os.Pipe()
+	if err != nil {
+		return 1, err
+	}
+	cmd.ExtraFiles = []*os.File{readPipe}
+	cmd.Env = append(os.Environ(), stateEnv+"=wait")
+	err = cmd.Start()
+	readPipe.Close()
+	if err != nil {
+		writePipe.Close()
+		return 1, fmt.Errorf("start process in user namespace fails: %v", err)
+	}
+	pid := strconv.Itoa(cmd.Process.Pid)
+	for _, helper := range []struct {
+		name string
+		id   int
+		maps []container.IDMap
+	}{{"newuidmap", uid, maps.UIDs}, {"newgidmap", gid, maps.GIDs}} {
+		if out, err := exec.Command(helper.name, helperArgs(pid, helper.id, helper.maps)...).CombinedOutput(); err != nil {
+			_ = cmd.Process.Kill()
+			writePipe.Close()
+			_ = cmd.Wait()
+			return 1, fmt.Errorf("%s fails: %v: %s", helper.name, err, strings.TrimSpace(string(out)))
+		}
+	}
+	writePipe.Close()
+	return wait(cmd)
+}
+
+// 当前用户的从属 id 范围，没有 newuidmap/newgidmap 时返回错误
+func subIDMappings() (*container.IDMappings, error) {
+	for _, helper := range []string{"newuidmap", "newgidmap"} {
+		if _, err := exec.LookPath(helper); err != nil {
+			return nil, fmt.Errorf("%s is not installed", helper)
+		}
+	}
+	current, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	// /etc/subgid 中同样以用户名记录
+	return container.LoadIDMappings(current.Username + ":" + current.Username)
+}
+
+// newuidmap <pid> 0 <uid> 1 1 <subuid> <count> ...
+func helperArgs(pid string, id int, maps []container.IDMap) []string {
+	args := []string{pid, "0", strconv.Itoa(id), "1"}
+	for _, m := range maps {
+		args = append(args, strconv.Itoa(m.ContainerID+1), strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+	}
+	return args
+}
+
+// 等待子进程退出，返回其退出码
+func wait(cmd *exec.Cmd) (int, error) {
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
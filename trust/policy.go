@@ -0,0 +1,92 @@
+package trust
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// PolicyPath 全局签名策略文件
+	PolicyPath = "/etc/minidocker/policy.json"
+	// DefaultTrustedKeysDir 策略未指定可信公钥时，使用该目录下所有 .pub 文件
+	DefaultTrustedKeysDir = "/etc/minidocker/trust"
+)
+
+// Policy 镜像签名策略
+type Policy struct {
+	// RequireSignature 为 true 时所有镜像在启动前都必须通过签名校验
+	RequireSignature bool `json:"requireSignature"`
+	// TrustedKeys 可信公钥文件或包含 .pub 文件的目录
+	TrustedKeys []string `json:"trustedKeys,omitempty"`
+}
+
+// LoadPolicy 读取全局签名策略，策略文件不存在时不要求签名
+func LoadPolicy() (*Policy, error) {
+	policy := &Policy{}
+	content, err := os.ReadFile(PolicyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return policy, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, policy); err != nil {
+		return nil, fmt.Errorf("unmarshal policy %s fails: %v", PolicyPath, err)
+	}
+	return policy, nil
+}
+
+// LoadTrustedKeys 读取策略中配置的可信公钥
+func (p *Policy) LoadTrustedKeys() ([]crypto.PublicKey, error) {
+	paths := p.TrustedKeys
+	if len(paths) == 0 {
+		paths = []string{DefaultTrustedKeysDir}
+	}
+	var keys []crypto.PublicKey
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) && len(p.TrustedKeys) == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("read trusted key %s fails: %v", path, err)
+		}
+		files := []string{path}
+		if info.IsDir() {
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			files = nil
+			for _, entry := range entries {
+				if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".pub") {
+					files = append(files, filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+		for _, file := range files {
+			key, err := LoadPublicKey(file)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// VerifyImage 使用策略中的可信公钥校验镜像签名
+func (p *Policy) VerifyImage(digest string) error {
+	keys, err := p.LoadTrustedKeys()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no trusted keys found, add public keys to %s or trustedKeys in %s", DefaultTrustedKeysDir, PolicyPath)
+	}
+	return Verify(digest, keys)
+}
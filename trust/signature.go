@@ -0,0 +1,164 @@
+package trust
+
+import (
+	"MiniDocker/image"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 签名算法
+const (
+	AlgorithmEd25519 = "ed25519"
+	AlgorithmECDSA   = "ecdsa-sha256"
+)
+
+// 签名内容的前缀，避免签名被用于其他用途
+const payloadPrefix = "minidocker image signature\n"
+
+// ErrNoSignature 镜像没有任何签名
+var ErrNoSignature = errors.New("image is not signed")
+
+// Signature 镜像的签名，对镜像ID(manifest的摘要)签名
+// 存储在本地镜像仓库的 signatures/${镜像ID}/${KeyID}.json
+type Signature struct {
+	Digest    string `json:"digest"`
+	KeyID     string `json:"keyId"`
+	Algorithm string `json:"algorithm"`
+	Signature []byte `json:"signature"`
+	Created   string `json:"created"`
+}
+
+// 签名的原始内容
+func payload(digest string) []byte {
+	return []byte(payloadPrefix + digest)
+}
+
+// Sign 使用私钥对镜像签名并保存
+func Sign(digest string, key crypto.Signer) (*Signature, error) {
+	keyID, err := KeyID(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	sig := &Signature{
+		Digest:  digest,
+		KeyID:   keyID,
+		Created: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		sig.Algorithm = AlgorithmEd25519
+		sig.Signature = ed25519.Sign(key, payload(digest))
+	case *ecdsa.PrivateKey:
+		sig.Algorithm = AlgorithmECDSA
+		hash := sha256.Sum256(payload(digest))
+		if sig.Signature, err = ecdsa.SignASN1(rand.Reader, key, hash[:]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	content, err := json.Marshal(sig)
+	if err != nil {
+		return nil, err
+	}
+	dir := image.SignatureDir(digest)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, keyID+".json"), content, 0644); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// Signatures 得到镜像的所有签名
+func Signatures(digest string) ([]*Signature, error) {
+	entries, err := os.ReadDir(image.SignatureDir(digest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var sigs []*Signature
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(image.SignatureDir(digest), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sig := &Signature{}
+		if err := json.Unmarshal(content, sig); err != nil {
+			return nil, fmt.Errorf("unmarshal signature %s fails: %v", entry.Name(), err)
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+// Verify 校验镜像至少有一个由可信公钥签发的有效签名
+func Verify(digest string, keys []crypto.PublicKey) error {
+	sigs, err := Signatures(digest)
+	if err != nil {
+		return err
+	}
+	if len(sigs) == 0 {
+		return ErrNoSignature
+	}
+	trusted := map[string]crypto.PublicKey{}
+	for _, key := range keys {
+		keyID, err := KeyID(key)
+		if err != nil {
+			return err
+		}
+		trusted[keyID] = key
+	}
+	var errs []string
+	for _, sig := range sigs {
+		key, ok := trusted[sig.KeyID]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("key %s is not trusted", shortKeyID(sig.KeyID)))
+			continue
+		}
+		if sig.Digest != digest {
+			errs = append(errs, fmt.Sprintf("signature by key %s is for %s", shortKeyID(sig.KeyID), sig.Digest))
+			continue
+		}
+		if verifySignature(key, sig) {
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("signature by key %s is invalid", shortKeyID(sig.KeyID)))
+	}
+	return fmt.Errorf("no valid signature from trusted keys: %s", strings.Join(errs, "; "))
+}
+
+// 使用公钥校验签名
+func verifySignature(key crypto.PublicKey, sig *Signature) bool {
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return sig.Algorithm == AlgorithmEd25519 && ed25519.Verify(key, payload(sig.Digest), sig.Signature)
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(payload(sig.Digest))
+		return sig.Algorithm == AlgorithmECDSA && ecdsa.VerifyASN1(key, hash[:], sig.Signature)
+	default:
+		return false
+	}
+}
+
+// 公钥标识的简写
+func shortKeyID(keyID string) string {
+	if len(keyID) > 12 {
+		return keyID[:12]
+	}
+	return keyID
+}
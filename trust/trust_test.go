@@ -0,0 +1,126 @@
+package trust
+
+import (
+	"MiniDocker/image"
+	"crypto"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 生成密钥对并写入临时目录
+func generateKeyFiles(t *testing.T, keyType, dir string) (string, string) {
+	t.Helper()
+	privatePEM, publicPEM, err := GenerateKey(keyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath := filepath.Join(dir, keyType+".key")
+	publicPath := filepath.Join(dir, keyType+".pub")
+	if err := os.WriteFile(privatePath, privatePEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(publicPath, publicPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return privatePath, publicPath
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for _, keyType := range []string{KeyTypeEd25519, KeyTypeECDSA} {
+		t.Run(keyType, func(t *testing.T) {
+			image.ImageRootUrl = t.TempDir()
+			keyDir := t.TempDir()
+			privatePath, publicPath := generateKeyFiles(t, keyType, keyDir)
+			_, otherPublic := generateKeyFiles(t, KeyTypeEd25519, t.TempDir())
+
+			digest := image.Digest([]byte("manifest"))
+			key, err := LoadPrivateKey(privatePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trusted, err := LoadPublicKey(publicPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			untrusted, err := LoadPublicKey(otherPublic)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := Verify(digest, []crypto.PublicKey{trusted}); err != ErrNoSignature {
+				t.Fatalf("expect ErrNoSignature, got %v", err)
+			}
+			sig, err := Sign(digest, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Verify(digest, []crypto.PublicKey{trusted}); err != nil {
+				t.Fatalf("verify fails: %v", err)
+			}
+			if err := Verify(digest, []crypto.PublicKey{untrusted}); err == nil {
+				t.Fatal("signature accepted by untrusted key")
+			}
+
+			// 签名只对签名时的镜像有效
+			other := image.Digest([]byte("other manifest"))
+			if err := os.MkdirAll(image.SignatureDir(other), 0755); err != nil {
+				t.Fatal(err)
+			}
+			content, _ := json.Marshal(sig)
+			if err := os.WriteFile(filepath.Join(image.SignatureDir(other), sig.KeyID+".json"), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := Verify(other, []crypto.PublicKey{trusted}); err == nil {
+				t.Fatal("signature copied from another image accepted")
+			}
+
+			// 篡改签名
+			sig.Signature[0] ^= 0xff
+			content, _ = json.Marshal(sig)
+			if err := os.WriteFile(filepath.Join(image.SignatureDir(digest), sig.KeyID+".json"), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := Verify(digest, []crypto.PublicKey{trusted}); err == nil {
+				t.Fatal("tampered signature accepted")
+			}
+		})
+	}
+}
+
+func TestPolicyTrustedKeys(t *testing.T) {
+	image.ImageRootUrl = t.TempDir()
+	keyDir := t.TempDir()
+	privatePath, _ := generateKeyFiles(t, KeyTypeEd25519, keyDir)
+	key, err := LoadPrivateKey(privatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := image.Digest([]byte("manifest"))
+	if _, err := Sign(digest, key); err != nil {
+		t.Fatal(err)
+	}
+
+	PolicyPath = filepath.Join(t.TempDir(), "policy.json")
+	policy, err := LoadPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.RequireSignature {
+		t.Fatal("signature required without a policy file")
+	}
+	content, _ := json.Marshal(&Policy{RequireSignature: true, TrustedKeys: []string{keyDir}})
+	if err := os.WriteFile(PolicyPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if policy, err = LoadPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	if !policy.RequireSignature {
+		t.Fatal("policy not loaded")
+	}
+	if err := policy.VerifyImage(digest); err != nil {
+		t.Fatalf("verify with trusted key dir fails: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package volume
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	PluginSockDir = "/run/minidocker/plugins/" // unix socket 插件目录, 插件监听 <name>.sock
+	PluginExecDir = "/etc/minidocker/plugins/" // 可执行文件插件目录, 插件为名为 <name> 的可执行文件
+	drivers       = map[string]VolumeDriver{}  // 驱动字典，存储已加载的驱动
+	driversLock   sync.Mutex                   // 保护 drivers
+)
+
+// VolumeDriver 数据卷驱动接口
+type VolumeDriver interface {
+	// Name 驱动名
+	Name() string
+	// Create 创建数据卷，opts 为驱动相关的选项
+	Create(name string, opts map[string]string) error
+	// Remove 删除数据卷及其中的数据
+	Remove(name string) error
+	// Mount 容器 id 使用数据卷前调用，返回宿主机上可以绑定挂载到容器内的目录
+	Mount(name, id string) (string, error)
+	// Unmount 容器 id 不再使用数据卷时调用
+	Unmount(name, id string) error
+	// Path 返回数据卷在宿主机上的目录，数据卷未挂载时可以为空
+	Path(name string) (string, error)
+	// List 列出驱动管理的所有数据卷
+	List() ([]string, error)
+}
+
+// RegisterDriver 注册数据卷驱动，同名驱动会被替换
+func RegisterDriver(driver VolumeDriver) {
+	driversLock.Lock()
+	defer driversLock.Unlock()
+	drivers[driver.Name()] = driver
+}
+
+// GetDriver 根据名字得到数据卷驱动，未注册的驱动从插件目录中查找
+// 优先使用 PluginSockDir 下的 unix socket 插件，其次是 PluginExecDir 下的可执行文件插件
+func GetDriver(name string) (VolumeDriver, error) {
+	if name == "" {
+		name = LocalDriver
+	}
+	driversLock.Lock()
+	defer driversLock.Unlock()
+	if driver, ok := drivers[name]; ok {
+		return driver, nil
+	}
+	if !ValidName(name) {
+		return nil, fmt.Errorf("invalid volume driver name %q", name)
+	}
+	var driver VolumeDriver
+	sockPath := filepath.Join(PluginSockDir, name+".sock")
+	execPath := filepath.Join(PluginExecDir, name)
+	if stat, err := os.Stat(sockPath); err == nil && stat.Mode()&os.ModeSocket != 0 {
+		driver = newSocketPlugin(name, sockPath)
+	} else if stat, err := os.Stat(execPath); err == nil && stat.Mode().IsRegular() && stat.Mode()&0111 != 0 {
+		driver = newExecPlugin(name, execPath)
+	} else {
+		return nil, fmt.Errorf("volume driver %s not found", name)
+	}
+	drivers[name] = driver
+	return driver, nil
+}
+
+// Drivers 得到所有内置驱动和插件目录中的驱动
+func Drivers() []VolumeDriver {
+	names := []string{LocalDriver}
+	for _, dir := range []string{PluginSockDir, PluginExecDir} {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			names = append(names, strings.TrimSuffix(entry.Name(), ".sock"))
+		}
+	}
+	var result []VolumeDriver
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if driver, err := GetDriver(name); err == nil {
+			result = append(result, driver)
+		}
+	}
+	return result
+}
+
+func init() {
+	RegisterDriver(&LocalVolumeDriver{})
+}
@@ -0,0 +1,68 @@
+package volume
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// LocalVolumeDriver 内置的本地数据卷驱动，数据存放在 VolumeRootUrl/<name>/_data
+type LocalVolumeDriver struct {
+}
+
+func (d *LocalVolumeDriver) Name() string {
+	return LocalDriver
+}
+
+// Create 创建数据目录，本地驱动不支持选项
+func (d *LocalVolumeDriver) Create(name string, opts map[string]string) error {
+	if len(opts) > 0 {
+		return fmt.Errorf("volume driver %s does not support options", d.Name())
+	}
+	path, _ := d.Path(name)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("mkdir %s fails: %v", path, err)
+	}
+	return nil
+}
+
+// Remove 删除数据目录
+func (d *LocalVolumeDriver) Remove(name string) error {
+	path, _ := d.Path(name)
+	return os.RemoveAll(path)
+}
+
+// Mount 本地数据卷不需要挂载，直接返回数据目录
+func (d *LocalVolumeDriver) Mount(name, id string) (string, error) {
+	path, _ := d.Path(name)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("volume %s data dir: %v", name, err)
+	}
+	return path, nil
+}
+
+func (d *LocalVolumeDriver) Unmount(name, id string) error {
+	return nil
+}
+
+func (d *LocalVolumeDriver) Path(name string) (string, error) {
+	return filepath.Join(volumeDir(name), dataDir), nil
+}
+
+// List 列出所有包含数据目录的数据卷
+func (d *LocalVolumeDriver) List() ([]string, error) {
+	entries, err := os.ReadDir(VolumeRootUrl)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(VolumeRootUrl, entry.Name(), dataDir)); err == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
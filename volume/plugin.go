@@ -0,0 +1,175 @@
+package volume
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"time"
+)
+
+/*
+数据卷插件协议，与 docker 的 volume plugin 协议一致
+每个方法(VolumeDriver.Create|Remove|Mount|Unmount|Path|List)接收一个 JSON 请求并返回一个 JSON 响应，响应中 Err 不为空表示失败
+unix socket 插件: 向 <name>.sock 发送 HTTP POST /<method>，请求体为 JSON
+可执行文件插件: 执行 <name> <method>，从标准输入读取请求，向标准输出写入响应
+*/
+
+const (
+	pluginContentType = "application/vnd.docker.plugins.v1+json"
+	pluginTimeout     = 30 * time.Second // 插件调用的超时时间
+)
+
+// 插件请求
+type pluginRequest struct {
+	Name string            `json:"Name,omitempty"`
+	ID   string            `json:"ID,omitempty"`
+	Opts map[string]string `json:"Opts,omitempty"`
+}
+
+// 插件响应
+type pluginResponse struct {
+	Mountpoint string `json:"Mountpoint,omitempty"`
+	Volumes    []struct {
+		Name       string `json:"Name"`
+		Mountpoint string `json:"Mountpoint,omitempty"`
+	} `json:"Volumes,omitempty"`
+	Err string `json:"Err,omitempty"`
+}
+
+// 插件驱动，通过 call 调用插件的方法
+type pluginDriver struct {
+	name string
+	call func(ctx context.Context, method string, body []byte) ([]byte, error)
+}
+
+// unix socket 插件
+func newSocketPlugin(name, sockPath string) *pluginDriver {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", sockPath)
+			},
+		},
+	}
+	return &pluginDriver{
+		name: name,
+		call: func(ctx context.Context, method string, body []byte) ([]byte, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://plugin/"+method, bytes.NewReader(body))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", pluginContentType)
+			resp, err := client.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			data, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return nil, err
+			}
+			// 出错时插件可以只返回状态码，也可以在响应中带上 Err
+			if resp.StatusCode != http.StatusOK && !json.Valid(data) {
+				return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(data))
+			}
+			return data, nil
+		},
+	}
+}
+
+// 可执行文件插件
+func newExecPlugin(name, execPath string) *pluginDriver {
+	return &pluginDriver{
+		name: name,
+		call: func(ctx context.Context, method string, body []byte) ([]byte, error) {
+			cmd := exec.CommandContext(ctx, execPath, method)
+			cmd.Stdin = bytes.NewReader(body)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			data, err := cmd.Output()
+			if err != nil && !json.Valid(data) {
+				return nil, fmt.Errorf("%v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+			}
+			return data, nil
+		},
+	}
+}
+
+// 调用插件的方法
+func (d *pluginDriver) do(method string, req pluginRequest) (*pluginResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+	data, err := d.call(ctx, "VolumeDriver."+method, body)
+	if err != nil {
+		return nil, fmt.Errorf("volume plugin %s %s fails: %v", d.name, method, err)
+	}
+	resp := &pluginResponse{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, resp); err != nil {
+			return nil, fmt.Errorf("volume plugin %s %s: invalid response %q", d.name, method, data)
+		}
+	}
+	if resp.Err != "" {
+		return nil, fmt.Errorf("volume plugin %s %s fails: %s", d.name, method, resp.Err)
+	}
+	return resp, nil
+}
+
+func (d *pluginDriver) Name() string {
+	return d.name
+}
+
+func (d *pluginDriver) Create(name string, opts map[string]string) error {
+	_, err := d.do("Create", pluginRequest{Name: name, Opts: opts})
+	return err
+}
+
+func (d *pluginDriver) Remove(name string) error {
+	_, err := d.do("Remove", pluginRequest{Name: name})
+	return err
+}
+
+func (d *pluginDriver) Mount(name, id string) (string, error) {
+	resp, err := d.do("Mount", pluginRequest{Name: name, ID: id})
+	if err != nil {
+		return "", err
+	}
+	if resp.Mountpoint == "" {
+		return "", fmt.Errorf("volume plugin %s returned an empty mountpoint for %s", d.name, name)
+	}
+	return resp.Mountpoint, nil
+}
+
+func (d *pluginDriver) Unmount(name, id string) error {
+	_, err := d.do("Unmount", pluginRequest{Name: name, ID: id})
+	return err
+}
+
+func (d *pluginDriver) Path(name string) (string, error) {
+	resp, err := d.do("Path", pluginRequest{Name: name})
+	if err != nil {
+		return "", err
+	}
+	return resp.Mountpoint, nil
+}
+
+func (d *pluginDriver) List() ([]string, error) {
+	resp, err := d.do("List", pluginRequest{})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, vol := range resp.Volumes {
+		names = append(names, vol.Name)
+	}
+	return names, nil
+}
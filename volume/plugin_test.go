@@ -0,0 +1,135 @@
+package volume
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 以 unix socket 插件的方式提供数据卷，数据目录位于 root 下
+func serveSocketPlugin(t *testing.T, sockPath, root string) {
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mounted := map[string]string{}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req pluginRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		resp := pluginResponse{}
+		path := filepath.Join(root, req.Name)
+		switch r.URL.Path {
+		case "/VolumeDriver.Create":
+			if req.Opts["size"] == "" {
+				resp.Err = "size is required"
+			} else if err := os.MkdirAll(path, 0755); err != nil {
+				resp.Err = err.Error()
+			}
+		case "/VolumeDriver.Remove":
+			if mounted[req.Name] != "" {
+				resp.Err = "volume is mounted"
+			} else {
+				_ = os.RemoveAll(path)
+			}
+		case "/VolumeDriver.Mount":
+			mounted[req.Name] = req.ID
+			resp.Mountpoint = path
+		case "/VolumeDriver.Unmount":
+			delete(mounted, req.Name)
+		case "/VolumeDriver.Path":
+			resp.Mountpoint = path
+		case "/VolumeDriver.List":
+			entries, _ := os.ReadDir(root)
+			for _, entry := range entries {
+				resp.Volumes = append(resp.Volumes, struct {
+					Name       string `json:"Name"`
+					Mountpoint string `json:"Mountpoint,omitempty"`
+				}{Name: entry.Name()})
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	})}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+}
+
+func TestSocketPlugin(t *testing.T) {
+	VolumeRootUrl = t.TempDir()
+	PluginSockDir = t.TempDir()
+	PluginExecDir = t.TempDir()
+	data := t.TempDir()
+	serveSocketPlugin(t, filepath.Join(PluginSockDir, "capped.sock"), data)
+
+	if _, _, err := Create("cache", "capped", nil, nil); err == nil {
+		t.Fatal("expect error returned by plugin")
+	}
+	vol, created, err := Create("cache", "capped", map[string]string{"size": "64m"}, nil)
+	if err != nil || !created || vol.Mountpoint != filepath.Join(data, "cache") {
+		t.Fatalf("create volume: %+v %v %v", vol, created, err)
+	}
+	path, err := vol.Mount("c1")
+	if err != nil || path != filepath.Join(data, "cache") {
+		t.Fatalf("mount volume: %v %v", path, err)
+	}
+	if err := Remove("cache"); err == nil {
+		t.Fatal("expect error removing a mounted volume")
+	}
+	if err := vol.Unmount("c1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 插件中存在但没有配置的数据卷也能被列出
+	if err := os.Mkdir(filepath.Join(data, "external"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	volumes, err := List()
+	if err != nil || len(volumes) != 2 || volumes[1].Name != "external" || volumes[1].Driver != "capped" {
+		t.Fatalf("list volumes: %v %v", volumes, err)
+	}
+	if err := Remove("external"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Remove("cache"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(data, "cache")); !os.IsNotExist(err) {
+		t.Errorf("volume data not removed: %v", err)
+	}
+}
+
+func TestExecPlugin(t *testing.T) {
+	VolumeRootUrl = t.TempDir()
+	PluginSockDir = t.TempDir()
+	PluginExecDir = t.TempDir()
+	script := `#!/bin/sh
+cat > /dev/null
+case "$1" in
+VolumeDriver.Mount|VolumeDriver.Path) echo '{"Mountpoint": "/srv/loop"}' ;;
+VolumeDriver.Remove) echo '{"Err": "busy"}' ;;
+VolumeDriver.List) echo '{"Volumes": [{"Name": "loop"}]}' ;;
+*) echo '{}' ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(PluginExecDir, "loopback"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	vol, _, err := Create("loop", "loopback", map[string]string{"size": "1G"}, nil)
+	if err != nil || vol.Driver != "loopback" || vol.Mountpoint != "/srv/loop" {
+		t.Fatalf("create volume: %+v %v", vol, err)
+	}
+	if path, err := vol.Mount("c1"); err != nil || path != "/srv/loop" {
+		t.Fatalf("mount volume: %v %v", path, err)
+	}
+	if err := Remove("loop"); err == nil {
+		t.Fatal("expect error returned by plugin")
+	}
+	if _, err := GetDriver("missing"); err == nil {
+		t.Fatal("expect error for missing driver")
+	}
+}
@@ -0,0 +1,248 @@
+package volume
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+var (
+	VolumeRootUrl = "/root/volumes/" // 命名数据卷存储位置, 每个数据卷一个目录
+)
+
+// SetRoot 设置数据根目录，数据卷位于 root/volumes 下
+func SetRoot(root string) {
+	VolumeRootUrl = filepath.Join(root, "volumes") + "/"
+}
+
+const (
+	dataDir    = "_data"     // 数据卷目录下存放数据的子目录
+	configFile = "opts.json" // 数据卷目录下的配置文件
+	// LocalDriver 默认的数据卷驱动，数据存放在本地目录
+	LocalDriver = "local"
+)
+
+// ErrNoSuchVolume 数据卷不存在
+var ErrNoSuchVolume = errors.New("no such volume")
+
+// 数据卷名的格式，与 docker 一致
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// Volume 命名数据卷，生命周期独立于容器
+type Volume struct {
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver"`
+	Mountpoint string            `json:"mountpoint"` // 宿主机上存放数据的目录
+	CreatedAt  time.Time         `json:"createdAt"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Options    map[string]string `json:"options,omitempty"` // 创建时传给驱动的选项
+}
+
+// ValidName 判断是否为合法的数据卷名
+func ValidName(name string) bool {
+	return nameRegexp.MatchString(name)
+}
+
+// 数据卷的目录
+func volumeDir(name string) string {
+	return filepath.Join(VolumeRootUrl, name)
+}
+
+// Create 使用 driver 驱动创建数据卷，name 为空时生成随机名字，数据卷已存在时直接返回
+// 数据卷的配置保存在 VolumeRootUrl/<name>/ 下，数据由驱动管理
+// @return created 是否为新建的数据卷
+func Create(name, driver string, opts, labels map[string]string) (vol *Volume, created bool, err error) {
+	if name == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, false, err
+		}
+		name = hex.EncodeToString(buf)
+	}
+	if !ValidName(name) {
+		return nil, false, fmt.Errorf("invalid volume name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	if driver == "" {
+		driver = LocalDriver
+	}
+	if vol, err := load(name); err == nil {
+		if vol.Driver != driver {
+			return nil, false, fmt.Errorf("volume %s already exists with driver %s", name, vol.Driver)
+		}
+		return vol, false, nil
+	}
+	d, err := GetDriver(driver)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := d.Create(name, opts); err != nil {
+		return nil, false, err
+	}
+	vol = &Volume{
+		Name:      name,
+		Driver:    d.Name(),
+		CreatedAt: time.Now(),
+		Labels:    labels,
+		Options:   opts,
+	}
+	vol.Mountpoint, _ = d.Path(name)
+	if err := os.MkdirAll(volumeDir(name), 0755); err == nil {
+		err = vol.dump()
+	}
+	if err != nil {
+		_ = d.Remove(name)
+		_ = os.RemoveAll(volumeDir(name))
+		return nil, false, fmt.Errorf("save volume %s fails: %v", name, err)
+	}
+	return vol, true, nil
+}
+
+// 将数据卷的配置保存到文件
+func (vol *Volume) dump() error {
+	data, err := json.MarshalIndent(vol, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(volumeDir(vol.Name), configFile), data, 0644)
+}
+
+// Get 根据名字得到数据卷，没有配置的数据卷从插件驱动中查找
+func Get(name string) (*Volume, error) {
+	vol, err := load(name)
+	if err == nil || !errors.Is(err, ErrNoSuchVolume) {
+		return vol, err
+	}
+	for _, d := range Drivers() {
+		if d.Name() == LocalDriver {
+			continue
+		}
+		names, _ := d.List()
+		for _, n := range names {
+			if n == name {
+				path, _ := d.Path(name)
+				return &Volume{Name: name, Driver: d.Name(), Mountpoint: path}, nil
+			}
+		}
+	}
+	return nil, err
+}
+
+// 读取数据卷的配置
+func load(name string) (*Volume, error) {
+	if !ValidName(name) {
+		return nil, fmt.Errorf("invalid volume name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(volumeDir(name), configFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrNoSuchVolume, name)
+		}
+		return nil, err
+	}
+	vol := &Volume{}
+	if err := json.Unmarshal(data, vol); err != nil {
+		return nil, fmt.Errorf("parse volume %s config fails: %v", name, err)
+	}
+	return vol, nil
+}
+
+// List 列出所有数据卷，包括插件驱动中没有配置的数据卷，按名字排序
+func List() ([]*Volume, error) {
+	entries, err := os.ReadDir(VolumeRootUrl)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var volumes []*Volume
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		vol, err := load(entry.Name())
+		if err != nil {
+			continue
+		}
+		seen[vol.Name] = true
+		volumes = append(volumes, vol)
+	}
+	for _, d := range Drivers() {
+		if d.Name() == LocalDriver {
+			continue
+		}
+		names, err := d.List()
+		if err != nil {
+			logrus.Warnf("list volumes of driver %s fails: %v", d.Name(), err)
+			continue
+		}
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				volumes = append(volumes, &Volume{Name: name, Driver: d.Name()})
+			}
+		}
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	return volumes, nil
+}
+
+// Remove 通过驱动删除数据卷及其中的数据，是否被容器使用由调用者检查
+func Remove(name string) error {
+	vol, err := Get(name)
+	if err != nil {
+		return err
+	}
+	d, err := GetDriver(vol.Driver)
+	if err != nil {
+		return err
+	}
+	if err := d.Remove(name); err != nil {
+		return err
+	}
+	return os.RemoveAll(volumeDir(name))
+}
+
+// Mount 容器 id 使用数据卷前调用，返回宿主机上的数据目录
+func (vol *Volume) Mount(id string) (string, error) {
+	d, err := GetDriver(vol.Driver)
+	if err != nil {
+		return "", err
+	}
+	return d.Mount(vol.Name, id)
+}
+
+// Unmount 容器 id 不再使用数据卷时调用
+func (vol *Volume) Unmount(id string) error {
+	d, err := GetDriver(vol.Driver)
+	if err != nil {
+		return err
+	}
+	return d.Unmount(vol.Name, id)
+}
+
+// Prune 删除所有不在 inUse 中的数据卷
+// @return 删除的数据卷名
+func Prune(inUse map[string]bool) ([]string, error) {
+	volumes, err := List()
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, vol := range volumes {
+		if inUse[vol.Name] {
+			continue
+		}
+		if err := Remove(vol.Name); err != nil {
+			return removed, fmt.Errorf("remove volume %s fails: %v", vol.Name, err)
+		}
+		removed = append(removed, vol.Name)
+	}
+	return removed, nil
+}
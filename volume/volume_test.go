@@ -0,0 +1,51 @@
+package volume
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVolumeLifecycle(t *testing.T) {
+	VolumeRootUrl = t.TempDir()
+
+	vol, created, err := Create("data", "", nil, map[string]string{"app": "db"})
+	if err != nil || !created {
+		t.Fatalf("create volume: %v %v", created, err)
+	}
+	if err := os.WriteFile(filepath.Join(vol.Mountpoint, "file"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 再次创建同名数据卷返回已有的数据卷，数据保留
+	again, created, err := Create("data", LocalDriver, nil, nil)
+	if err != nil || created || again.Labels["app"] != "db" {
+		t.Fatalf("create existing volume: %+v %v %v", again, created, err)
+	}
+	if _, err := os.Stat(filepath.Join(again.Mountpoint, "file")); err != nil {
+		t.Fatal(err)
+	}
+	anonymous, _, err := Create("", "", nil, nil)
+	if err != nil || len(anonymous.Name) != 64 {
+		t.Fatalf("create anonymous volume: %+v %v", anonymous, err)
+	}
+	for _, name := range []string{"../x", "a", "-a", "a/b"} {
+		if _, _, err := Create(name, "", nil, nil); err == nil {
+			t.Errorf("expect error for volume name %q", name)
+		}
+	}
+
+	volumes, err := List()
+	if err != nil || len(volumes) != 2 {
+		t.Fatalf("list volumes: %v %v", volumes, err)
+	}
+	removed, err := Prune(map[string]bool{"data": true})
+	if err != nil || len(removed) != 1 || removed[0] != anonymous.Name {
+		t.Fatalf("prune volumes: %v %v", removed, err)
+	}
+	if err := Remove("data"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get("data"); err == nil {
+		t.Error("expect error for removed volume")
+	}
+}